@@ -0,0 +1,54 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func fired(c <-chan time.Time) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestFake(t *testing.T) {
+	start := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	f := NewFake(start)
+
+	if !f.Now().Equal(start) {
+		t.Fatalf("now: got %s, want %s", f.Now(), start)
+	}
+
+	short := f.After(time.Second)
+	long := f.After(time.Minute)
+	if fired(short) || fired(long) || f.Timers() != 2 {
+		t.Fatal("timers fired before the clock moved")
+	}
+
+	f.Advance(999 * time.Millisecond)
+	if fired(short) {
+		t.Fatal("timer fired early")
+	}
+	f.Advance(time.Millisecond)
+	if !fired(short) || fired(long) {
+		t.Fatal("expected only the short timer to fire")
+	}
+
+	// Moving backwards doesn't fire anything.
+	f.Set(start)
+	if fired(long) || f.Timers() != 1 {
+		t.Fatal("timer fired moving backwards")
+	}
+
+	f.Set(start.Add(time.Hour))
+	if !fired(long) || f.Timers() != 0 {
+		t.Fatal("expected the long timer to fire")
+	}
+
+	if !fired(f.After(0)) {
+		t.Fatal("expected an expired timer to fire immediately")
+	}
+}
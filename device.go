@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bemasher/rtldavis/dsp"
+	"github.com/bemasher/rtldavis/receiver"
+)
+
+// A driver opens the device named by source, sampling at the configuration's
+// DeviceSampleRate.
+type driver func(cfg dsp.PacketConfig, source string) (receiver.Device, io.Closer, error)
+
+// Drivers selectable with -driver, registered by the files implementing them.
+// Some are only built with a build tag.
+var drivers = map[string]driver{}
+
+func registerDriver(name string, open driver) {
+	drivers[name] = open
+}
+
+// driverNames lists the registered drivers.
+func driverNames() string {
+	names := make([]string, 0, len(drivers))
+	for name := range drivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+func openDriver(name string, cfg dsp.PacketConfig, source string) (receiver.Device, io.Closer, error) {
+	open, ok := drivers[name]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown driver %q, built with: %s", name, driverNames())
+	}
+	return open(cfg, source)
+}
+
+// settleTimer is implemented by devices that know how long their tuner
+// takes to settle after a retune.
+type settleTimer interface {
+	SettleTime() time.Duration
+}
@@ -0,0 +1,99 @@
+package dsp
+
+import "fmt"
+
+// DCBlock selects how the rtl-sdr's DC spike is suppressed before
+// demodulation.
+//
+// The demodulator already keeps the signal away from DC: channels are tuned
+// below the signal and RotateFs4 moves it to the center of the passband,
+// which puts the spike near the passband's edge where FIR9 attenuates it.
+// Offset tuning, on tuners that support it, moves the LO leakage out of band
+// entirely. A DC blocker removes whatever leakage is left, which matters most
+// without offset tuning. Blocking costs little sensitivity since the signal
+// has no energy at DC, but the IIR blocker's notch widens as its pole moves
+// away from 1.
+type DCBlock int
+
+const (
+	// DCBlockNone leaves samples unchanged.
+	DCBlockNone DCBlock = iota
+
+	// DCBlockMean subtracts each block's mean. Cheapest, but the estimate
+	// jumps between blocks and a strong signal biases it.
+	DCBlockMean
+
+	// DCBlockIIR applies a single pole high-pass filter with a narrow notch
+	// at DC and continuous state across blocks.
+	DCBlockIIR
+)
+
+func ParseDCBlock(s string) (DCBlock, error) {
+	switch s {
+	case "none", "":
+		return DCBlockNone, nil
+	case "mean":
+		return DCBlockMean, nil
+	case "iir":
+		return DCBlockIIR, nil
+	default:
+		return DCBlockNone, fmt.Errorf("unknown dc block: %q", s)
+	}
+}
+
+func (b DCBlock) String() string {
+	switch b {
+	case DCBlockNone:
+		return "none"
+	case DCBlockMean:
+		return "mean"
+	case DCBlockIIR:
+		return "iir"
+	default:
+		return fmt.Sprintf("DCBlock(%d)", int(b))
+	}
+}
+
+// RemoveMean subtracts the mean of a block of samples in place.
+func RemoveMean(iq []complex128) {
+	var mean complex128
+	for _, s := range iq {
+		mean += s
+	}
+	mean /= complex(float64(len(iq)), 0)
+
+	for idx := range iq {
+		iq[idx] -= mean
+	}
+}
+
+// DefaultDCPole places the IIR blocker's -3 dB point at about 43 Hz at
+// 268.8 kHz, far below the signal's 9.6 kHz deviation.
+const DefaultDCPole = 0.999
+
+// DCBlocker is a single pole DC blocking filter:
+//
+//	y[n] = x[n] - x[n-1] + pole * y[n-1]
+type DCBlocker struct {
+	Pole float64
+
+	x1, y1 complex128
+}
+
+func NewDCBlocker(pole float64) *DCBlocker {
+	return &DCBlocker{Pole: pole}
+}
+
+// Execute filters in into out, which may be the same slice.
+func (b *DCBlocker) Execute(in, out []complex128) {
+	pole := complex(b.Pole, 0)
+	for idx, x := range in {
+		y := x - b.x1 + pole*b.y1
+		b.x1, b.y1 = x, y
+		out[idx] = y
+	}
+}
+
+func (b *DCBlocker) Reset() {
+	b.x1, b.y1 = 0, 0
+}
@@ -0,0 +1,57 @@
+package dsp
+
+import (
+	"math"
+	"math/cmplx"
+	"testing"
+)
+
+// A 9.6 kHz tone at 268.8 kHz on top of a DC offset.
+func toneWithDC(n int, dc complex128) []complex128 {
+	iq := make([]complex128, n)
+	for idx := range iq {
+		iq[idx] = cmplx.Rect(0.5, 2*math.Pi*9600/268800*float64(idx)) + dc
+	}
+	return iq
+}
+
+func mean(iq []complex128) (m complex128) {
+	for _, s := range iq {
+		m += s
+	}
+	return m / complex(float64(len(iq)), 0)
+}
+
+func TestRemoveMean(t *testing.T) {
+	// A whole number of periods so the tone itself has no mean.
+	iq := toneWithDC(28*20, complex(0.2, -0.1))
+	RemoveMean(iq)
+
+	if m := cmplx.Abs(mean(iq)); m > 1e-9 {
+		t.Fatalf("mean after removal: %g", m)
+	}
+}
+
+func TestDCBlocker(t *testing.T) {
+	b := NewDCBlocker(DefaultDCPole)
+	dc := complex(0.2, -0.1)
+
+	// Let the filter settle over several blocks.
+	block := make([]complex128, 512)
+	for n := 0; n < 40; n++ {
+		b.Execute(toneWithDC(len(block), dc), block)
+	}
+
+	if m := cmplx.Abs(mean(block)); m > 0.01 {
+		t.Fatalf("residual dc: %g", m)
+	}
+
+	// The tone must pass with little loss.
+	var power float64
+	for _, s := range block {
+		power += real(s)*real(s) + imag(s)*imag(s)
+	}
+	if amp := math.Sqrt(power / float64(len(block))); amp < 0.49 || amp > 0.51 {
+		t.Fatalf("tone amplitude: got %g, want 0.5", amp)
+	}
+}
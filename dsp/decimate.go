@@ -0,0 +1,176 @@
+package dsp
+
+import "math"
+
+// LowpassTaps designs a Hamming-windowed sinc low-pass filter with the given
+// number of taps and cutoff in cycles per sample. Taps are normalized for
+// unity gain at DC.
+func LowpassTaps(n int, cutoff float64) []float64 {
+	taps := make([]float64, n)
+	center := float64(n-1) / 2
+
+	var sum float64
+	for idx := range taps {
+		x := float64(idx) - center
+
+		sinc := 2 * cutoff
+		if x != 0 {
+			sinc = math.Sin(2*math.Pi*cutoff*x) / (math.Pi * x)
+		}
+
+		window := 1.0
+		if n > 1 {
+			window = 0.54 - 0.46*math.Cos(2*math.Pi*float64(idx)/float64(n-1))
+		}
+
+		taps[idx] = sinc * window
+		sum += taps[idx]
+	}
+
+	for idx := range taps {
+		taps[idx] /= sum
+	}
+
+	return taps
+}
+
+// Decimator low-pass filters complex samples and reduces their rate by Ratio,
+// carrying filter history and output timing across calls to Execute.
+//
+// When Ratio is an integer the filter is only evaluated at the samples that
+// are kept. Otherwise the filter runs at the full input rate and outputs are
+// linearly interpolated between filtered samples.
+type Decimator struct {
+	Ratio float64
+	Taps  []float64
+
+	// Last len(Taps)-1 input samples of the previous block.
+	history []complex128
+	buf     []complex128
+
+	// Full rate filter output for the general path.
+	filtered []complex128
+
+	// Position of the next output relative to the start of the next block
+	// and the last filtered sample of the previous block.
+	phase float64
+	last  complex128
+}
+
+func NewDecimator(ratio float64, taps []float64) *Decimator {
+	return &Decimator{
+		Ratio:   ratio,
+		Taps:    taps,
+		history: make([]complex128, len(taps)-1),
+	}
+}
+
+// NewIntegerDecimator returns a decimator by an integer factor with an
+// anti-alias filter passing the output's band up to 90% of its Nyquist rate.
+func NewIntegerDecimator(factor int) *Decimator {
+	return NewDecimator(float64(factor), LowpassTaps(8*factor+1, 0.45/float64(factor)))
+}
+
+// Execute decimates in to out and returns the number of samples written. If
+// Ratio is an integer that divides len(in), exactly len(in)/Ratio samples are
+// written.
+func (d *Decimator) Execute(in, out []complex128) int {
+	if factor := int(d.Ratio); float64(factor) == d.Ratio && d.phase == 0 && len(in)%factor == 0 {
+		return d.selectPhase(in, out, factor)
+	}
+	return d.resample(in, out)
+}
+
+// window returns history followed by in and stores the tail of in as the
+// history for the next block.
+func (d *Decimator) window(in []complex128) []complex128 {
+	if cap(d.buf) < len(d.history)+len(in) {
+		d.buf = make([]complex128, len(d.history)+len(in))
+	}
+	buf := d.buf[:len(d.history)+len(in)]
+
+	copy(buf, d.history)
+	copy(buf[len(d.history):], in)
+	copy(d.history, buf[len(buf)-len(d.history):])
+
+	return buf
+}
+
+// filter evaluates the filter for the input sample at buf[idx+len(Taps)-1].
+func (d *Decimator) filter(buf []complex128, idx int) (acc complex128) {
+	window := buf[idx : idx+len(d.Taps)]
+	for tIdx, tap := range d.Taps {
+		acc += window[tIdx] * complex(tap, 0)
+	}
+	return acc
+}
+
+// selectPhase is the fast path for integer ratios: since every output lands
+// exactly on an input sample, only every factor'th filter output is
+// computed.
+func (d *Decimator) selectPhase(in, out []complex128, factor int) int {
+	buf := d.window(in)
+
+	n := len(in) / factor
+	for oIdx := 0; oIdx < n; oIdx++ {
+		out[oIdx] = d.filter(buf, oIdx*factor)
+	}
+
+	if len(in) > 0 {
+		d.last = d.filter(buf, len(in)-1)
+	}
+
+	return n
+}
+
+// resample filters every input sample and interpolates outputs at arbitrary
+// positions.
+func (d *Decimator) resample(in, out []complex128) int {
+	buf := d.window(in)
+
+	if cap(d.filtered) < len(in) {
+		d.filtered = make([]complex128, len(in))
+	}
+	filtered := d.filtered[:len(in)]
+	for idx := range filtered {
+		filtered[idx] = d.filter(buf, idx)
+	}
+
+	// at returns filtered sample idx, where -1 is the last sample of the
+	// previous block.
+	at := func(idx int) complex128 {
+		if idx < 0 {
+			return d.last
+		}
+		return filtered[idx]
+	}
+
+	n := 0
+	t := d.phase
+	for ; t <= float64(len(in)-1); t += d.Ratio {
+		idx := int(math.Floor(t))
+		frac := t - float64(idx)
+
+		out[n] = at(idx)
+		if frac != 0 {
+			out[n] += (at(idx+1) - at(idx)) * complex(frac, 0)
+		}
+		n++
+	}
+
+	d.phase = t - float64(len(in))
+	if len(in) > 0 {
+		d.last = filtered[len(in)-1]
+	}
+
+	return n
+}
+
+// Reset clears filter history and output timing.
+func (d *Decimator) Reset() {
+	for idx := range d.history {
+		d.history[idx] = 0
+	}
+	d.phase = 0
+	d.last = 0
+}
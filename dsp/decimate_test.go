@@ -0,0 +1,142 @@
+package dsp
+
+import (
+	"math"
+	"math/cmplx"
+	"math/rand"
+	"testing"
+)
+
+func randomIQ(n int) []complex128 {
+	iq := make([]complex128, n)
+	for idx := range iq {
+		iq[idx] = complex(rand.Float64()*2-1, rand.Float64()*2-1)
+	}
+	return iq
+}
+
+// The phase selection fast path must produce exactly what the general
+// filter-then-resample path does for integer ratios, across block
+// boundaries.
+func TestDecimatorPhaseSelection(t *testing.T) {
+	const (
+		factor    = 8
+		blockSize = 512
+		blocks    = 6
+	)
+
+	fast := NewIntegerDecimator(factor)
+	general := NewIntegerDecimator(factor)
+
+	fastOut := make([]complex128, blockSize/factor)
+	generalOut := make([]complex128, blockSize/factor)
+
+	for block := 0; block < blocks; block++ {
+		in := randomIQ(blockSize)
+
+		if n := fast.Execute(in, fastOut); n != blockSize/factor {
+			t.Fatalf("fast path wrote %d samples, expected %d", n, blockSize/factor)
+		}
+		if n := general.resample(in, generalOut); n != blockSize/factor {
+			t.Fatalf("general path wrote %d samples, expected %d", n, blockSize/factor)
+		}
+
+		for idx := range fastOut {
+			if cmplx.Abs(fastOut[idx]-generalOut[idx]) > 1e-12 {
+				t.Fatalf("block %d sample %d: fast %v, general %v", block, idx, fastOut[idx], generalOut[idx])
+			}
+		}
+	}
+}
+
+// A tone inside the passband survives decimation and one above the output's
+// Nyquist rate is rejected.
+func TestDecimatorAntiAlias(t *testing.T) {
+	const (
+		factor    = 8
+		blockSize = 4096
+	)
+
+	power := func(freq float64) float64 {
+		d := NewIntegerDecimator(factor)
+
+		in := make([]complex128, blockSize)
+		for idx := range in {
+			in[idx] = cmplx.Exp(complex(0, 2*math.Pi*freq*float64(idx)))
+		}
+
+		out := make([]complex128, blockSize/factor)
+		d.Execute(in, out)
+
+		// Skip the filter's transient.
+		var sum float64
+		for _, s := range out[16:] {
+			sum += real(s)*real(s) + imag(s)*imag(s)
+		}
+		return sum / float64(len(out)-16)
+	}
+
+	if p := power(0.1 / factor); p < 0.9 {
+		t.Errorf("passband tone attenuated: power %f", p)
+	}
+	if p := power(0.8 / factor); p > 1e-3 {
+		t.Errorf("stopband tone not rejected: power %f", p)
+	}
+}
+
+func TestDecimatorFractional(t *testing.T) {
+	d := NewDecimator(2.5, LowpassTaps(17, 0.18))
+
+	out := make([]complex128, 256)
+	total := 0
+	for block := 0; block < 4; block++ {
+		total += d.Execute(randomIQ(100), out)
+	}
+
+	if total != 160 {
+		t.Fatalf("expected 160 samples from 400 at ratio 2.5, got %d", total)
+	}
+}
+
+func BenchmarkDecimatorPhaseSelection(b *testing.B) {
+	d := NewIntegerDecimator(8)
+	in := randomIQ(4096)
+	out := make([]complex128, 512)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		d.Execute(in, out)
+	}
+}
+
+func BenchmarkDecimatorGeneral(b *testing.B) {
+	d := NewIntegerDecimator(8)
+	in := randomIQ(4096)
+	out := make([]complex128, 512)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		d.resample(in, out)
+	}
+}
+
+func TestDemodulateDecimated(t *testing.T) {
+	cfg := NewPacketConfig(19200, 14, 16, 80, "1100101110001001")
+	cfg.SetDecimation(8)
+
+	if cfg.DeviceSampleRate != 8*cfg.SampleRate {
+		t.Fatalf("device sample rate: got %d, want %d", cfg.DeviceSampleRate, 8*cfg.SampleRate)
+	}
+
+	d := NewDemodulator(&cfg)
+	block := make([]byte, cfg.DeviceBlockSize2)
+	for idx := range block {
+		block[idx] = byte(rand.Intn(256))
+	}
+
+	for n := 0; n < 4; n++ {
+		d.Demodulate(block)
+	}
+}
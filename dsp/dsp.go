@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"log"
 	"math"
+	"sort"
 )
 
 type ByteToCmplxLUT [256]float64
@@ -43,6 +44,14 @@ func (l *ByteToCmplxLUT) Execute(in []byte, out []complex128) {
 	}
 }
 
+// Conjugate mirrors a block of samples' spectrum in place, undoing a tuner
+// that delivers it inverted.
+func Conjugate(iq []complex128) {
+	for idx, s := range iq {
+		iq[idx] = complex(real(s), -imag(s))
+	}
+}
+
 func RotateFs4(in, out []complex128) {
 	for idx := 0; idx < len(out); idx += 4 {
 		inAt := in[idx:]
@@ -102,6 +111,32 @@ func Quantize(input []float64, output []byte) {
 	return
 }
 
+// QuantizeHysteresis quantizes like Quantize but only changes its decision
+// once a sample crosses the band (-threshold, threshold), samples inside the
+// band repeat the previous decision. State is the decision preceding input,
+// the final decision is returned so the next block can continue from it.
+func QuantizeHysteresis(input []float64, output []byte, threshold float64, state byte) byte {
+	for idx, val := range input {
+		if val < -threshold {
+			state = 1
+		} else if val > threshold {
+			state = 0
+		}
+		output[idx] = state
+	}
+
+	return state
+}
+
+// Swing returns the mean magnitude of the discriminator's output, which for a
+// block containing a packet is the typical frequency deviation of a symbol.
+func Swing(input []float64) (swing float64) {
+	for _, val := range input {
+		swing += math.Abs(val)
+	}
+	return swing / float64(len(input))
+}
+
 func (d *Demodulator) Pack(input []byte) {
 	for symbolOffset, slice := range d.slices {
 		for symbolIdx := range slice {
@@ -112,7 +147,20 @@ func (d *Demodulator) Pack(input []byte) {
 	return
 }
 
+// Search returns the index of every preamble match in the buffer, in stream
+// order. The whole block is scanned, a block large enough to hold several
+// packets yields matches for each of them.
 func (d *Demodulator) Search() (indexes []int) {
+	if d.Cfg.PreambleFinder == nil {
+		for symbolOffset, slice := range d.slices {
+			for _, idx := range correlate(slice, d.Cfg.Template, d.Cfg.Threshold) {
+				indexes = append(indexes, idx*d.Cfg.SymbolLength+symbolOffset)
+			}
+		}
+		sort.Ints(indexes)
+		return indexes
+	}
+
 	for symbolOffset, slice := range d.slices {
 		offset := 0
 		idx := 0
@@ -127,12 +175,51 @@ func (d *Demodulator) Search() (indexes []int) {
 		}
 	}
 
+	// Matches are found per symbol offset, order them so packets are
+	// returned in the order they were received.
+	sort.Ints(indexes)
 	return indexes
 }
 
+// duplicate reports whether an identical packet was found within
+// Cfg.GuardSymbols of pos, a position in the stream. The preamble matches at
+// several neighboring sample offsets, which may fall either side of a block
+// boundary and so be found in consecutive blocks.
+func (d *Demodulator) duplicate(pkt string, pos int64) bool {
+	guard := int64(d.Cfg.GuardSymbols * d.Cfg.SymbolLength)
+
+	// Forget packets too old to be duplicated.
+	recent := d.recent[:0]
+	for _, r := range d.recent {
+		if pos-r.pos <= guard {
+			recent = append(recent, r)
+		}
+	}
+	d.recent = recent
+
+	for _, r := range d.recent {
+		if r.pkt == pkt {
+			return true
+		}
+	}
+
+	if guard > 0 {
+		d.recent = append(d.recent, recentPacket{pkt, pos})
+	}
+	return false
+}
+
+type recentPacket struct {
+	pkt string
+	pos int64
+}
+
 type Packet struct {
 	Idx  int
 	Data []byte
+
+	// Discriminator values of the packet's symbols.
+	Eye Eye
 }
 
 func (d *Demodulator) Slice(indices []int) (pkts []Packet) {
@@ -140,27 +227,37 @@ func (d *Demodulator) Slice(indices []int) (pkts []Packet) {
 	// track of unique instances.
 	seen := make(map[string]bool)
 
-	// For each of the indices the preamble exists at.
+	// Position in the stream of the first quantized sample.
+	base := d.samples - int64(d.Cfg.BufferLength)
+
+	// For each of the indices the preamble exists at. Matches overlapping an
+	// earlier packet are framed too: most are the sync word turning up in its
+	// data and fail the CRC, but skipping them would lose a real packet
+	// following a false match.
 	for _, qIdx := range indices {
 		// Check that we're still within the first sample block. We'll catch
 		// the message on the next sample block otherwise.
-		if qIdx > d.Cfg.BlockSize {
+		if qIdx >= d.Cfg.BlockSize {
 			continue
 		}
 
 		// Packet is 1 bit per byte, pack to 8-bits per byte.
-		for pIdx := 0; pIdx < d.Cfg.PacketSymbols; pIdx++ {
+		d.sample(qIdx)
+		for pIdx, bit := range d.symbols {
 			d.pkt[pIdx>>3] <<= 1
-			d.pkt[pIdx>>3] |= d.Quantized[qIdx+(pIdx*d.Cfg.SymbolLength)]
+			d.pkt[pIdx>>3] |= bit
 		}
 
 		// Store the packet in the seen map and append to the packet list.
 		pktStr := fmt.Sprintf("%02X", d.pkt)
-		if !seen[pktStr] {
+		if !seen[pktStr] && !d.duplicate(pktStr, base+int64(qIdx)) {
 			seen[pktStr] = true
 
-			pkt := Packet{qIdx, make([]byte, len(d.pkt))}
+			pkt := Packet{Idx: qIdx, Data: make([]byte, len(d.pkt))}
 			copy(pkt.Data, d.pkt)
+			for pIdx, bit := range d.symbols {
+				pkt.Eye.Add(d.decisions[pIdx], bit)
+			}
 			pkts = append(pkts, pkt)
 		}
 	}
@@ -168,13 +265,39 @@ func (d *Demodulator) Slice(indices []int) (pkts []Packet) {
 	return
 }
 
+// sample decides the symbols of a packet whose preamble matched at qIdx, and
+// records the discriminator's mean over each, see Eye.
+func (d *Demodulator) sample(qIdx int) {
+	if d.Cfg.Timing == TimingEarlyLate {
+		earlyLate(d.Discriminated, qIdx, d.Cfg.SymbolLength, d.symbols, d.decisions)
+		return
+	}
+
+	for pIdx := range d.symbols {
+		sIdx := qIdx + (pIdx * d.Cfg.SymbolLength)
+		d.symbols[pIdx] = d.Quantized[sIdx]
+		d.decisions[pIdx] = integrate(d.Discriminated, float64(sIdx), d.Cfg.SymbolLength) / float64(d.Cfg.SymbolLength)
+	}
+}
+
 // PacketConfig specifies packet-specific radio configuration.
 type PacketConfig struct {
 	BitRate                        int
 	SymbolLength                   int
 	PreambleSymbols, PacketSymbols int
 
-	Preamble       string
+	// Preamble is the bit string Template was built from, if any.
+	Preamble string
+
+	// Template is the expected preamble as a correlation template, one
+	// weight per symbol: positive expects a one, negative a zero, zero
+	// doesn't care. A window of symbols matches if its correlation with the
+	// template is at least Threshold times the largest possible.
+	Template  []float64
+	Threshold float64
+
+	// PreambleFinder finds templates that only match one bit string, nil
+	// otherwise.
 	PreambleBytes  []byte
 	PreambleFinder *byteFinder
 
@@ -182,46 +305,225 @@ type PacketConfig struct {
 	BlockSize, BlockSize2        int
 	PreambleLength, PacketLength int
 	BufferLength                 int
+
+	// Decimation is the integer ratio between the device's sample rate and
+	// SampleRate. Each block read from the device is DeviceBlockSize2 bytes.
+	Decimation       int
+	DeviceSampleRate int
+	DeviceBlockSize2 int
+
+	// Hysteresis is the half-width of the quantizer's dead band around zero,
+	// as a fraction of the discriminator's swing over each block. Zero uses a
+	// hard zero-crossing decision.
+	Hysteresis float64
+
+	// DCBlock selects how the DC spike is removed from each block.
+	DCBlock DCBlock
+
+	// GuardSymbols is how close, in symbols, two detections of an identical
+	// packet must be to count as one. Zero disables the guard.
+	GuardSymbols int
+
+	// Timing selects how symbols are sampled, see Timing.
+	Timing Timing
+
+	// SpectrumInverted conjugates samples before demodulation, for tuners
+	// that deliver the spectrum mirrored. Signals then appear on the wrong
+	// side of the tuned frequency with ones and zeros swapped.
+	SpectrumInverted bool
 }
 
+// NewPacketConfig builds a configuration searching for an exact preamble bit
+// string of preambleSymbols symbols, see ParseTemplate. Panics if the
+// preamble isn't valid.
 func NewPacketConfig(bitRate, symbolLength, preambleSymbols, packetSymbols int, preamble string) PacketConfig {
+	template, err := ParseTemplate(preamble)
+	if err != nil {
+		panic(err)
+	}
+	if len(template) != preambleSymbols {
+		panic(fmt.Errorf("dsp: preamble %q isn't %d symbols", preamble, preambleSymbols))
+	}
+
+	cfg := NewTemplatePacketConfig(bitRate, symbolLength, packetSymbols, template, 1)
+	cfg.Preamble = preamble
+	return cfg
+}
+
+// NewTemplatePacketConfig builds a configuration searching for a preamble
+// by correlating with a template, see PacketConfig.Template.
+func NewTemplatePacketConfig(bitRate, symbolLength, packetSymbols int, template []float64, threshold float64) PacketConfig {
 	var cfg PacketConfig
 
 	cfg.BitRate = bitRate
 	cfg.SymbolLength = symbolLength
 
-	cfg.PreambleSymbols = preambleSymbols
+	cfg.PreambleSymbols = len(template)
 	cfg.PacketSymbols = packetSymbols
 
 	cfg.PreambleLength = cfg.PreambleSymbols * cfg.SymbolLength
 	cfg.PacketLength = cfg.PacketSymbols * cfg.SymbolLength
 
-	// Pre-calculate a byte-slice version of the preamble for searching.
-	cfg.Preamble = preamble
-	cfg.PreambleBytes = make([]byte, len(cfg.Preamble))
-	for idx := range cfg.Preamble {
-		if cfg.Preamble[idx] == '1' {
-			cfg.PreambleBytes[idx] = 1
-		}
+	cfg.setTemplate(template, threshold)
+
+	cfg.SampleRate = cfg.BitRate * cfg.SymbolLength
+
+	cfg.Decimation = 1
+	cfg.setBlockSize(DefaultBlockSize)
+
+	cfg.GuardSymbols = DefaultGuardSymbols
+
+	return cfg
+}
+
+// SetTemplate replaces the preamble's correlation template, which must be as
+// long as the current one.
+func (cfg *PacketConfig) SetTemplate(template []float64, threshold float64) error {
+	if len(template) != cfg.PreambleSymbols {
+		return fmt.Errorf("dsp: template has %d symbols, expected %d", len(template), cfg.PreambleSymbols)
 	}
+	if threshold <= 0 || threshold > 1 {
+		return fmt.Errorf("dsp: template threshold must be in (0, 1]: %v", threshold)
+	}
+
+	cfg.Preamble = ""
+	cfg.setTemplate(template, threshold)
+	return nil
+}
 
-	cfg.PreambleFinder = makeByteFinder(cfg.PreambleBytes)
+func (cfg *PacketConfig) setTemplate(template []float64, threshold float64) {
+	cfg.Template = template
+	cfg.Threshold = threshold
 
-	cfg.SampleRate = cfg.BitRate * cfg.SymbolLength
+	// Templates matching a single bit string are searched for directly.
+	cfg.PreambleBytes, cfg.PreambleFinder = nil, nil
+	if pattern, ok := hardTemplate(template, threshold); ok {
+		cfg.PreambleBytes = pattern
+		cfg.PreambleFinder = makeByteFinder(cfg.PreambleBytes)
+	}
+}
 
-	cfg.BlockSize = 512
+// DefaultGuardSymbols covers the spread of sample offsets a preamble matches
+// at.
+const DefaultGuardSymbols = 4
+
+// DefaultBlockSize is the number of samples demodulated at a time unless
+// configured otherwise.
+const DefaultBlockSize = 504
+
+// SetBlockSize sets the number of samples demodulated at a time.
+//
+// Each block costs a fixed amount of work on top of the per-sample work, so
+// larger blocks use less CPU per sample. Packets are only found once the
+// block they end in has been read, so larger blocks also add up to a block's
+// worth of latency and make processing burstier. At 268.8 kHz the default of
+// 504 samples is about 1.9 ms.
+//
+// Blocks must be a whole number of symbols so the preamble search lines up
+// across blocks, and a multiple of 4 samples for the Fs/4 rotation.
+func (cfg *PacketConfig) SetBlockSize(blockSize int) error {
+	if blockSize <= 0 {
+		return fmt.Errorf("dsp: block size must be positive: %d", blockSize)
+	}
+	if blockSize%cfg.SymbolLength != 0 || blockSize%4 != 0 {
+		return fmt.Errorf("dsp: block size %d must be a multiple of the symbol length (%d) and 4",
+			blockSize, cfg.SymbolLength,
+		)
+	}
+
+	cfg.setBlockSize(blockSize)
+	return nil
+}
+
+func (cfg *PacketConfig) setBlockSize(blockSize int) {
+	cfg.BlockSize = blockSize
 	cfg.BlockSize2 = cfg.BlockSize << 1
 
+	// Keep enough blocks for a packet starting anywhere in the oldest block
+	// to be complete in the buffer.
 	cfg.BufferLength = (cfg.PacketLength/cfg.BlockSize + 2) * cfg.BlockSize
 
-	return cfg
+	cfg.SetDecimation(cfg.Decimation)
+}
+
+// SetSymbolLength sets the number of samples per symbol, and so SampleRate.
+// The block size is scaled to cover about the same time, rounded to the
+// nearest size SetBlockSize accepts.
+func (cfg *PacketConfig) SetSymbolLength(symbolLength int) error {
+	if symbolLength < 2 {
+		return fmt.Errorf("dsp: symbol length must be at least 2: %d", symbolLength)
+	}
+
+	align := symbolLength
+	for align%4 != 0 {
+		align += symbolLength
+	}
+	blockSize := (cfg.BlockSize*symbolLength/cfg.SymbolLength + align/2) / align * align
+	if blockSize < align {
+		blockSize = align
+	}
+
+	cfg.SymbolLength = symbolLength
+	cfg.SampleRate = cfg.BitRate * cfg.SymbolLength
+	cfg.PreambleLength = cfg.PreambleSymbols * cfg.SymbolLength
+	cfg.PacketLength = cfg.PacketSymbols * cfg.SymbolLength
+	cfg.setBlockSize(blockSize)
+
+	return nil
+}
+
+// SetPacketSymbols sets the number of symbols framed from the start of each
+// preamble match, which must include the preamble.
+func (cfg *PacketConfig) SetPacketSymbols(packetSymbols int) error {
+	if packetSymbols <= cfg.PreambleSymbols {
+		return fmt.Errorf("dsp: packet of %d symbols can't hold the %d symbol preamble", packetSymbols, cfg.PreambleSymbols)
+	}
+
+	cfg.PacketSymbols = packetSymbols
+	cfg.PacketLength = cfg.PacketSymbols * cfg.SymbolLength
+	cfg.setBlockSize(cfg.BlockSize)
+
+	return nil
+}
+
+// SetDecimation configures the device to sample at factor times SampleRate.
+// Blocks are low-pass filtered and decimated back down to SampleRate before
+// demodulation. A factor of 1 disables decimation.
+func (cfg *PacketConfig) SetDecimation(factor int) {
+	if factor < 1 {
+		factor = 1
+	}
+
+	cfg.Decimation = factor
+	cfg.DeviceSampleRate = cfg.SampleRate * factor
+	cfg.DeviceBlockSize2 = cfg.BlockSize2 * factor
 }
 
 func (cfg PacketConfig) Log() {
 	log.Println("BitRate:", cfg.BitRate)
 	log.Println("SymbolLength:", cfg.SymbolLength)
 	log.Println("SampleRate:", cfg.SampleRate)
-	log.Println("Preamble:", cfg.Preamble)
+	if cfg.Decimation > 1 {
+		log.Println("Decimation:", cfg.Decimation)
+		log.Println("DeviceSampleRate:", cfg.DeviceSampleRate)
+	}
+	if cfg.DCBlock != DCBlockNone {
+		log.Println("DCBlock:", cfg.DCBlock)
+	}
+	if cfg.Hysteresis > 0 {
+		log.Println("Hysteresis:", cfg.Hysteresis)
+	}
+	if cfg.Timing != TimingFixed {
+		log.Println("Timing:", cfg.Timing)
+	}
+	if cfg.SpectrumInverted {
+		log.Println("SpectrumInverted:", cfg.SpectrumInverted)
+	}
+	if cfg.Preamble != "" {
+		log.Println("Preamble:", cfg.Preamble)
+	} else {
+		log.Println("Template:", cfg.Template, "Threshold:", cfg.Threshold)
+	}
 	log.Println("PreambleSymbols:", cfg.PreambleSymbols)
 	log.Println("PreambleLength:", cfg.PreambleLength)
 	log.Println("PacketSymbols:", cfg.PacketSymbols)
@@ -242,7 +544,23 @@ type Demodulator struct {
 	slices [][]byte
 	pkt    []byte
 
+	// Symbols of the packet being framed, one per byte, and their
+	// discriminator values.
+	symbols   []byte
+	decisions []float64
+
 	lut ByteToCmplxLUT
+
+	// Full rate samples and decimator, only used if Cfg.Decimation > 1.
+	wide      []complex128
+	decimator *Decimator
+
+	dcBlocker *DCBlocker
+
+	// Samples demodulated so far and packets recently found, to suppress
+	// duplicates across blocks.
+	samples int64
+	recent  []recentPacket
 }
 
 func NewDemodulator(cfg *PacketConfig) (d Demodulator) {
@@ -251,7 +569,9 @@ func NewDemodulator(cfg *PacketConfig) (d Demodulator) {
 	d.Raw = make([]byte, d.Cfg.BufferLength<<1)
 	d.IQ = make([]complex128, d.Cfg.BlockSize+9)
 	d.Filtered = make([]complex128, d.Cfg.BlockSize+1)
-	d.Discriminated = make([]float64, d.Cfg.BlockSize*2)
+	// Discriminated is kept aligned with Quantized so a packet's index
+	// addresses both.
+	d.Discriminated = make([]float64, d.Cfg.BufferLength)
 	d.Quantized = make([]byte, d.Cfg.BufferLength)
 
 	d.slices = make([][]byte, d.Cfg.SymbolLength)
@@ -265,32 +585,70 @@ func NewDemodulator(cfg *PacketConfig) (d Demodulator) {
 	}
 
 	d.pkt = make([]byte, (d.Cfg.PacketSymbols+7)>>3)
+	d.symbols = make([]byte, d.Cfg.PacketSymbols)
+	d.decisions = make([]float64, d.Cfg.PacketSymbols)
 
 	d.lut = NewByteToCmplxLUT()
+	d.dcBlocker = NewDCBlocker(DefaultDCPole)
+
+	if d.Cfg.Decimation > 1 {
+		d.wide = make([]complex128, d.Cfg.DeviceBlockSize2>>1)
+		d.decimator = NewIntegerDecimator(d.Cfg.Decimation)
+	}
 
 	return d
 }
 
+// Demodulate processes a block of Cfg.DeviceBlockSize2 bytes of interleaved
+// IQ samples and returns any packets found.
 func (d *Demodulator) Demodulate(input []byte) []Packet {
-	copy(d.Raw, d.Raw[d.Cfg.BlockSize2:])
 	// Only need the last filter-length worth of samples.
 	// d.IQ is BlockSize + 9 for our case.
 	copy(d.IQ, d.IQ[d.Cfg.BlockSize:])
 	d.Filtered[0] = d.Filtered[len(d.Filtered)-1]
 	copy(d.Discriminated, d.Discriminated[d.Cfg.BlockSize:])
 	copy(d.Quantized, d.Quantized[d.Cfg.BlockSize:])
+	d.samples += int64(d.Cfg.BlockSize)
+
+	if d.decimator != nil {
+		d.lut.Execute(input, d.wide)
+		d.decimator.Execute(d.wide, d.IQ[9:])
+	} else {
+		copy(d.Raw, d.Raw[d.Cfg.BlockSize2:])
+		copy(d.Raw[d.Cfg.BufferLength<<1-d.Cfg.BlockSize2:], input)
+		d.lut.Execute(d.Raw[d.Cfg.BufferLength<<1-d.Cfg.BlockSize2:], d.IQ[9:])
+	}
+
+	if d.Cfg.SpectrumInverted {
+		Conjugate(d.IQ[9:])
+	}
 
-	copy(d.Raw[d.Cfg.BufferLength<<1-d.Cfg.BlockSize2:], input)
+	switch d.Cfg.DCBlock {
+	case DCBlockMean:
+		RemoveMean(d.IQ[9:])
+	case DCBlockIIR:
+		d.dcBlocker.Execute(d.IQ[9:], d.IQ[9:])
+	}
 
-	d.lut.Execute(d.Raw[d.Cfg.BufferLength<<1-d.Cfg.BlockSize2:], d.IQ[9:])
 	RotateFs4(d.IQ[9:], d.IQ[9:])
 	FIR9(d.IQ, d.Filtered[1:])
-	Discriminate(d.Filtered, d.Discriminated[d.Cfg.BlockSize:])
-	Quantize(d.Discriminated[d.Cfg.BlockSize:], d.Quantized[d.Cfg.BufferLength-d.Cfg.BlockSize:])
+	Discriminate(d.Filtered, d.Discriminated[d.Cfg.BufferLength-d.Cfg.BlockSize:])
+	d.quantize(d.Discriminated[d.Cfg.BufferLength-d.Cfg.BlockSize:], d.Quantized[d.Cfg.BufferLength-d.Cfg.BlockSize:])
 	d.Pack(d.Quantized)
 	return d.Slice(d.Search())
 }
 
+func (d *Demodulator) quantize(input []float64, output []byte) {
+	if d.Cfg.Hysteresis <= 0 {
+		Quantize(input, output)
+		return
+	}
+
+	// Continue from the last decision of the previous block.
+	state := d.Quantized[d.Cfg.BufferLength-d.Cfg.BlockSize-1]
+	QuantizeHysteresis(input, output, d.Cfg.Hysteresis*Swing(input), state)
+}
+
 func (d *Demodulator) Reset() {
 	for idx := range d.Raw {
 		d.Raw[idx] = 0
@@ -307,4 +665,9 @@ func (d *Demodulator) Reset() {
 	for idx := range d.Quantized {
 		d.Quantized[idx] = 0
 	}
+	if d.decimator != nil {
+		d.decimator.Reset()
+	}
+	d.dcBlocker.Reset()
+	d.recent = d.recent[:0]
 }
@@ -2,6 +2,7 @@ package dsp
 
 import (
 	"math"
+	"math/rand"
 	"testing"
 )
 
@@ -61,10 +62,7 @@ func TestFullPipeline(t *testing.T) {
 	}
 
 	// 4. Run the demodulator
-	demod := NewDemodulator(&cfg)
-
 	// We need to feed the samples in chunks of BlockSize
-	var packets []Packet
 	for i := 0; i < len(samples); i += cfg.BlockSize {
 		end := i + cfg.BlockSize
 		if end > len(samples) {
@@ -90,12 +88,12 @@ func TestFullPipeline(t *testing.T) {
 		filtered := make([]complex128, len(chunk))
 		FIR9(chunk, filtered)
 
-		// Discriminate
-		discriminated := make([]float64, len(chunk))
+		// Discriminate, which looks one sample ahead.
+		discriminated := make([]float64, len(chunk)-1)
 		Discriminate(filtered, discriminated)
 
 		// Quantize
-		quantized := make([]byte, len(chunk))
+		quantized := make([]byte, len(discriminated))
 		Quantize(discriminated, quantized)
 
 		// Pack
@@ -107,3 +105,348 @@ func TestFullPipeline(t *testing.T) {
 		// If these run without panic, the core math is likely okay.
 	}
 }
+
+// A symbol stream whose transitions ramp slowly through zero, as the
+// discriminator's output does after filtering, with noise large enough to
+// cross zero repeatedly near each transition.
+func noisySymbols(symbols []byte, symbolLength int, noise float64) (noisy []float64) {
+	r := rand.New(rand.NewSource(1))
+
+	level := func(bit byte) float64 {
+		if bit == 1 {
+			return -1
+		}
+		return 1
+	}
+
+	for idx, bit := range symbols {
+		prev := level(bit)
+		if idx > 0 {
+			prev = level(symbols[idx-1])
+		}
+		for s := 0; s < symbolLength; s++ {
+			// Ramp over the first half of the symbol.
+			frac := math.Min(float64(s)/float64(symbolLength/2), 1)
+			val := prev + (level(bit)-prev)*frac
+			noisy = append(noisy, val+r.NormFloat64()*noise)
+		}
+	}
+
+	return noisy
+}
+
+// Count decision changes, each symbol transition should produce exactly one.
+func transitions(bits []byte) (n int) {
+	for idx := 1; idx < len(bits); idx++ {
+		if bits[idx] != bits[idx-1] {
+			n++
+		}
+	}
+	return n
+}
+
+func TestQuantizeHysteresis(t *testing.T) {
+	r := rand.New(rand.NewSource(0))
+	symbols := make([]byte, 400)
+	for idx := range symbols {
+		symbols[idx] = byte(r.Intn(2))
+	}
+
+	noisy := noisySymbols(symbols, 14, 0.3)
+
+	hard := make([]byte, len(noisy))
+	Quantize(noisy, hard)
+
+	soft := make([]byte, len(noisy))
+	QuantizeHysteresis(noisy, soft, 0.5*Swing(noisy), hard[0])
+
+	// Spurious flips are decision changes beyond those of the symbols.
+	want := transitions(symbols)
+	hardFlips := transitions(hard) - want
+	softFlips := transitions(soft) - want
+	t.Logf("spurious flips: hard %d, hysteresis %d", hardFlips, softFlips)
+
+	if hardFlips == 0 {
+		t.Fatal("noise should cause spurious flips without hysteresis")
+	}
+	if softFlips >= hardFlips {
+		t.Fatalf("hysteresis should reduce spurious flips: got %d, hard decision %d", softFlips, hardFlips)
+	}
+
+	// Decisions at symbol centers must still be correct.
+	for idx, bit := range symbols {
+		if center := idx*14 + 10; soft[center] != bit {
+			t.Fatalf("symbol %d: got %d, want %d", idx, soft[center], bit)
+		}
+	}
+}
+
+// With no band the hysteresis quantizer must match the hard decision.
+func TestQuantizeHysteresisZero(t *testing.T) {
+	noisy := noisySymbols([]byte{0, 1, 1, 0, 1, 0, 0, 1}, 14, 0.3)
+
+	hard := make([]byte, len(noisy))
+	Quantize(noisy, hard)
+
+	soft := make([]byte, len(noisy))
+	QuantizeHysteresis(noisy, soft, 0, 0)
+
+	for idx := range hard {
+		if hard[idx] != soft[idx] {
+			t.Fatalf("sample %d: got %d, want %d", idx, soft[idx], hard[idx])
+		}
+	}
+}
+
+// The last decision carries across blocks.
+func TestQuantizeHysteresisState(t *testing.T) {
+	out := make([]byte, 3)
+
+	state := QuantizeHysteresis([]float64{-1, -0.1, 0.1}, out, 0.5, 0)
+	if state != 1 || out[1] != 1 || out[2] != 1 {
+		t.Fatalf("got %v, state %d", out, state)
+	}
+
+	state = QuantizeHysteresis([]float64{0.2, 0.6, 0.2}, out, 0.5, state)
+	if state != 0 || out[0] != 1 || out[1] != 0 || out[2] != 0 {
+		t.Fatalf("got %v, state %d", out, state)
+	}
+}
+
+func TestSetBlockSize(t *testing.T) {
+	cfg := NewPacketConfig(19200, 14, 16, 80, "1100101110001001")
+	if err := cfg.SetBlockSize(DefaultBlockSize); err != nil {
+		t.Fatalf("default block size: %v", err)
+	}
+
+	for _, blockSize := range []int{0, -28, 14, 30, 500} {
+		cfg := NewPacketConfig(19200, 14, 16, 80, "1100101110001001")
+		if err := cfg.SetBlockSize(blockSize); err == nil {
+			t.Errorf("block size %d: expected error", blockSize)
+		}
+		if cfg.BlockSize != DefaultBlockSize {
+			t.Errorf("block size %d: rejected size was applied", blockSize)
+		}
+	}
+
+	// Any accepted size must demodulate without running off its buffers.
+	for _, blockSize := range []int{28, 504, 1120, 4200} {
+		cfg := NewPacketConfig(19200, 14, 16, 80, "1100101110001001")
+		cfg.SetDecimation(2)
+		if err := cfg.SetBlockSize(blockSize); err != nil {
+			t.Fatalf("block size %d: %v", blockSize, err)
+		}
+
+		if cfg.BufferLength < cfg.BlockSize+cfg.PacketLength {
+			t.Fatalf("block size %d: buffer %d can't hold a packet", blockSize, cfg.BufferLength)
+		}
+		if cfg.DeviceBlockSize2 != 2*2*blockSize {
+			t.Fatalf("block size %d: device block size %d", blockSize, cfg.DeviceBlockSize2)
+		}
+
+		d := NewDemodulator(&cfg)
+		block := make([]byte, cfg.DeviceBlockSize2)
+		for n := 0; n < 8; n++ {
+			rand.Read(block)
+			d.Demodulate(block)
+		}
+	}
+}
+
+func TestSetSymbolLength(t *testing.T) {
+	cfg := NewPacketConfig(19200, 14, 16, 80, "1100101110001001")
+	if err := cfg.SetSymbolLength(1); err == nil {
+		t.Fatal("expected error for symbol length 1")
+	}
+
+	if err := cfg.SetSymbolLength(12); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.SampleRate != 230400 || cfg.PacketLength != 80*12 || cfg.DeviceSampleRate != 230400 {
+		t.Fatalf("unexpected config: %+v", cfg)
+	}
+
+	// 504 samples at 14 per symbol is 432 at 12, a multiple of 12 and 4.
+	if cfg.BlockSize != 432 {
+		t.Fatalf("expected block size 432, got %d", cfg.BlockSize)
+	}
+
+	pkts := demodulateAt(&cfg, 5*cfg.BlockSize+cfg.BlockSize/2)
+	if len(pkts) != 1 {
+		t.Fatalf("expected one packet, got %d", len(pkts))
+	}
+	if want := []byte{0xCB, 0x89}; pkts[0].Data[0] != want[0] || pkts[0].Data[1] != want[1] {
+		t.Fatalf("unexpected packet %02X", pkts[0].Data)
+	}
+}
+
+// Symbols of a Davis packet: a short preamble, the sync word and data sent
+// least significant bit first.
+func packetSymbols(data ...byte) (bits []byte) {
+	for idx := 0; idx < 32; idx++ {
+		bits = append(bits, byte(idx&1))
+	}
+	for _, c := range "1100101110001001" {
+		bits = append(bits, byte(c-'0'))
+	}
+	for _, b := range data {
+		for bit := uint(0); bit < 8; bit++ {
+			bits = append(bits, b>>bit&1)
+		}
+	}
+	return bits
+}
+
+// Demodulate a capture whose sync word starts at the given sample, followed
+// by enough silence to flush it, and return the packets found.
+func demodulateAt(cfg *PacketConfig, sample int) (pkts []Packet) {
+	silence := func(samples int) []byte {
+		s := make([]byte, 2*samples)
+		for idx := range s {
+			s[idx] = 127
+		}
+		return s
+	}
+
+	capture := silence(sample - 32*cfg.SymbolLength)
+	capture = append(capture, Modulate(*cfg, packetSymbols(0x80, 0x05, 0x60, 0x2E, 0xE0, 0x00, 0x12, 0x34), 9600)...)
+	capture = append(capture, silence(cfg.BufferLength+cfg.BlockSize)...)
+
+	d := NewDemodulator(cfg)
+	for idx := 0; idx+cfg.BlockSize2 <= len(capture); idx += cfg.BlockSize2 {
+		pkts = append(pkts, d.Demodulate(capture[idx:idx+cfg.BlockSize2])...)
+	}
+	return pkts
+}
+
+// The preamble matches at several neighboring sample offsets. When those
+// fall either side of a block boundary the same packet is found in two
+// consecutive blocks, the guard must count it once.
+func TestDuplicateGuard(t *testing.T) {
+	cfg := NewPacketConfig(19200, 14, 16, 80, "1100101110001001")
+
+	// Sync word just before a block boundary.
+	sample := 5*cfg.BlockSize - 14
+
+	cfg.GuardSymbols = 0
+	if pkts := demodulateAt(&cfg, sample); len(pkts) != 2 {
+		t.Fatalf("without guard: expected the packet twice, got %d", len(pkts))
+	}
+
+	cfg.GuardSymbols = DefaultGuardSymbols
+	pkts := demodulateAt(&cfg, sample)
+	if len(pkts) != 1 {
+		t.Fatalf("with guard: expected one packet, got %d", len(pkts))
+	}
+	if want := []byte{0xCB, 0x89}; pkts[0].Data[0] != want[0] || pkts[0].Data[1] != want[1] {
+		t.Fatalf("unexpected packet %02X", pkts[0].Data)
+	}
+
+	// Packets away from a boundary are unaffected.
+	if pkts := demodulateAt(&cfg, 5*cfg.BlockSize+cfg.BlockSize/2); len(pkts) != 1 {
+		t.Fatalf("mid-block: expected one packet, got %d", len(pkts))
+	}
+}
+
+// A block large enough to hold two packets must return both, in order.
+func TestSearchMultiplePackets(t *testing.T) {
+	cfg := NewPacketConfig(19200, 14, 16, 80, "1100101110001001")
+	if err := cfg.SetBlockSize(14 * 300); err != nil {
+		t.Fatal(err)
+	}
+
+	silence := func(samples int) []byte {
+		s := make([]byte, 2*samples)
+		for idx := range s {
+			s[idx] = 127
+		}
+		return s
+	}
+
+	first := []byte{0x80, 0x05, 0x60, 0x2E, 0xE0, 0x00, 0x12, 0x34}
+	second := []byte{0xA1, 0x07, 0x42, 0x01, 0x99, 0x00, 0x56, 0x78}
+
+	// Both sync words fall within the second block.
+	capture := silence(cfg.BlockSize + 100)
+	capture = append(capture, Modulate(cfg, packetSymbols(first...), 9600)...)
+	capture = append(capture, Modulate(cfg, packetSymbols(second...), 9600)...)
+	capture = append(capture, silence(cfg.BufferLength+cfg.BlockSize)...)
+
+	var pkts []Packet
+	d := NewDemodulator(&cfg)
+	for idx := 0; idx+cfg.BlockSize2 <= len(capture); idx += cfg.BlockSize2 {
+		found := d.Demodulate(capture[idx : idx+cfg.BlockSize2])
+		if len(found) > 0 && len(pkts) > 0 {
+			t.Fatalf("packets found in separate blocks: %v, %v", pkts, found)
+		}
+		pkts = append(pkts, found...)
+	}
+
+	if len(pkts) != 2 {
+		t.Fatalf("expected two packets from one block, got %d", len(pkts))
+	}
+	for idx, data := range [][]byte{first, second} {
+		for bIdx, b := range data {
+			if got := pkts[idx].Data[2+bIdx]; got != reverse(b) {
+				t.Fatalf("packet %d: got %02X", idx, pkts[idx].Data)
+			}
+		}
+	}
+	if pkts[0].Idx >= pkts[1].Idx {
+		t.Fatalf("packets out of order: %d, %d", pkts[0].Idx, pkts[1].Idx)
+	}
+}
+
+// Bit order of a byte reversed, as framed from symbols sent least
+// significant bit first.
+func reverse(b byte) (r byte) {
+	for bit := uint(0); bit < 8; bit++ {
+		r = r<<1 | b>>bit&1
+	}
+	return r
+}
+
+// A tuner delivering the spectrum mirrored only decodes once conjugated.
+func TestSpectrumInverted(t *testing.T) {
+	cfg := NewPacketConfig(19200, 14, 16, 80, "1100101110001001")
+
+	capture := make([]byte, 4*cfg.BlockSize2)
+	for idx := range capture {
+		capture[idx] = 127
+	}
+	capture = append(capture, Modulate(cfg, packetSymbols(0x80, 0x05, 0x60, 0x2E, 0xE0, 0x00, 0x12, 0x34), 9600)...)
+	for len(capture) < 12*cfg.BlockSize2 {
+		capture = append(capture, 127)
+	}
+
+	// Negate Q, 127.4 is the center of the sample range. Without noise the
+	// little of the mirrored signal FIR9 passes would still decode.
+	noise := rand.New(rand.NewSource(1))
+	for idx := range capture {
+		v := float64(capture[idx]) + 8*noise.NormFloat64()
+		if idx&1 == 1 {
+			v = 254.8 - v
+		}
+		capture[idx] = byte(math.Max(0, math.Min(255, math.Round(v))))
+	}
+
+	found := func() (n int) {
+		d := NewDemodulator(&cfg)
+		for idx := 0; idx+cfg.BlockSize2 <= len(capture); idx += cfg.BlockSize2 {
+			for _, pkt := range d.Demodulate(capture[idx : idx+cfg.BlockSize2]) {
+				if pkt.Data[2] == reverse(0x80) && pkt.Data[3] == reverse(0x05) {
+					n++
+				}
+			}
+		}
+		return n
+	}
+
+	if n := found(); n != 0 {
+		t.Fatalf("inverted capture decoded without correction: %d packets", n)
+	}
+	cfg.SpectrumInverted = true
+	if n := found(); n != 1 {
+		t.Fatalf("corrected: got %d packets, want 1", n)
+	}
+}
@@ -0,0 +1,86 @@
+package dsp
+
+import (
+	"fmt"
+	"math"
+)
+
+// Eye summarizes the discriminator's values at symbol decisions, split by
+// the symbol decided: what an eye diagram shows at its center. Strong
+// reception gives two tight clusters far apart, noise and interference
+// spread them until they overlap and decisions start going wrong, well
+// before it shows in the CRC failure rate.
+//
+// Each value is the discriminator's mean over a symbol, from the sample fixed
+// timing decides it from or from where early-late timing places it, in the
+// discriminator's units of roughly radians per sample. A fixed decision
+// rests on the single sample, but the first sample a preamble matches at is
+// usually near a symbol's edge, where it says more about timing than about
+// the signal. Eyes from several packets can be merged.
+type Eye struct {
+	// Decisions per symbol, 0 and 1.
+	Count [2]int
+
+	sum, sumSquares [2]float64
+}
+
+// Add records a decision of bit from value v.
+func (e *Eye) Add(v float64, bit byte) {
+	bit &= 1
+	e.Count[bit]++
+	e.sum[bit] += v
+	e.sumSquares[bit] += v * v
+}
+
+// Merge adds o's decisions to e's.
+func (e *Eye) Merge(o Eye) {
+	for bit := range e.Count {
+		e.Count[bit] += o.Count[bit]
+		e.sum[bit] += o.sum[bit]
+		e.sumSquares[bit] += o.sumSquares[bit]
+	}
+}
+
+// Mean returns the mean value of decisions of bit.
+func (e Eye) Mean(bit byte) float64 {
+	bit &= 1
+	if e.Count[bit] == 0 {
+		return 0
+	}
+	return e.sum[bit] / float64(e.Count[bit])
+}
+
+// StdDev returns the standard deviation of decisions of bit.
+func (e Eye) StdDev(bit byte) float64 {
+	bit &= 1
+	if e.Count[bit] == 0 {
+		return 0
+	}
+	mean := e.Mean(bit)
+	variance := e.sumSquares[bit]/float64(e.Count[bit]) - mean*mean
+	if variance < 0 {
+		return 0
+	}
+	return math.Sqrt(variance)
+}
+
+// Opening returns the fraction of the distance between the clusters' means
+// left clear by three standard deviations either side of them. It's 1 for a
+// noiseless signal and 0 or below once the clusters overlap, the eye is
+// closed. Zero without decisions of both symbols.
+func (e Eye) Opening() float64 {
+	if e.Count[0] == 0 || e.Count[1] == 0 {
+		return 0
+	}
+	distance := math.Abs(e.Mean(0) - e.Mean(1))
+	if distance == 0 {
+		return 0
+	}
+	return 1 - 3*(e.StdDev(0)+e.StdDev(1))/distance
+}
+
+func (e Eye) String() string {
+	return fmt.Sprintf("{Zeros:%d Mean:%.3f StdDev:%.3f Ones:%d Mean:%.3f StdDev:%.3f Opening:%.2f}",
+		e.Count[0], e.Mean(0), e.StdDev(0), e.Count[1], e.Mean(1), e.StdDev(1), e.Opening(),
+	)
+}
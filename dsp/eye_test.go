@@ -0,0 +1,86 @@
+package dsp
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestEye(t *testing.T) {
+	var e Eye
+	for _, v := range []float64{0.4, 0.6} {
+		e.Add(v, 0)
+	}
+	var o Eye
+	for _, v := range []float64{-0.5, -0.5, -0.5} {
+		o.Add(v, 1)
+	}
+	e.Merge(o)
+
+	if e.Count != [2]int{2, 3} {
+		t.Fatalf("counts: got %v", e.Count)
+	}
+	if math.Abs(e.Mean(0)-0.5) > 1e-9 || math.Abs(e.StdDev(0)-0.1) > 1e-9 {
+		t.Fatalf("zeros: got mean %v, std dev %v", e.Mean(0), e.StdDev(0))
+	}
+	if math.Abs(e.Mean(1)+0.5) > 1e-9 || e.StdDev(1) > 1e-9 {
+		t.Fatalf("ones: got mean %v, std dev %v", e.Mean(1), e.StdDev(1))
+	}
+	if got := e.Opening(); math.Abs(got-0.7) > 1e-6 {
+		t.Fatalf("opening: got %v, want 0.7", got)
+	}
+
+	if got := (Eye{Count: [2]int{5, 0}}).Opening(); got != 0 {
+		t.Fatalf("opening of one cluster: got %v", got)
+	}
+}
+
+// The eye of a packet closes as noise is added.
+func TestPacketEye(t *testing.T) {
+	eye := func(noise float64, timing Timing) Eye {
+		cfg := NewPacketConfig(19200, 14, 16, 80, "1100101110001001")
+		cfg.Timing = timing
+
+		capture := make([]byte, 4*cfg.BlockSize2)
+		for idx := range capture {
+			capture[idx] = 127
+		}
+		capture = append(capture, Modulate(cfg, packetSymbols(0x80, 0x05, 0x60, 0x2E, 0xE0, 0x00, 0x12, 0x34), 9600)...)
+		for len(capture) < 12*cfg.BlockSize2 {
+			capture = append(capture, 127)
+		}
+
+		r := rand.New(rand.NewSource(1))
+		for idx := range capture {
+			v := float64(capture[idx]) + noise*r.NormFloat64()
+			capture[idx] = byte(math.Max(0, math.Min(255, math.Round(v))))
+		}
+
+		// Noise can make a neighbouring preamble offset decode to different
+		// bytes, so only the first decode is measured.
+		d := NewDemodulator(&cfg)
+		for idx := 0; idx+cfg.BlockSize2 <= len(capture); idx += cfg.BlockSize2 {
+			for _, pkt := range d.Demodulate(capture[idx : idx+cfg.BlockSize2]) {
+				if pkt.Data[2] == reverse(0x80) {
+					return pkt.Eye
+				}
+			}
+		}
+		return Eye{}
+	}
+
+	for _, timing := range []Timing{TimingFixed, TimingEarlyLate} {
+		clean, noisy := eye(0, timing), eye(30, timing)
+		if clean.Count[0]+clean.Count[1] != 80 || noisy.Count[0]+noisy.Count[1] != 80 {
+			t.Fatalf("%s: packet not decoded: %v, %v", timing, clean, noisy)
+		}
+
+		// Ones are negative frequency.
+		if clean.Mean(1) >= 0 || clean.Mean(0) <= 0 {
+			t.Fatalf("%s: cluster means: %v", timing, clean)
+		}
+		if clean.Opening() <= noisy.Opening() || clean.Opening() < 0.5 {
+			t.Fatalf("%s: eye didn't close with noise: clean %v, noisy %v", timing, clean, noisy)
+		}
+	}
+}
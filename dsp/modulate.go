@@ -0,0 +1,38 @@
+package dsp
+
+import "math"
+
+// Modulate returns 8-bit interleaved IQ samples, as read from the device, of
+// a phase continuous FSK signal carrying one symbol per element of bits at
+// cfg's bit rate and device sample rate. Ones are sent deviation Hz above
+// the channel's center and zeros below it. The channel is centered a quarter
+// of the sample rate below the tuned frequency, where RotateFs4 expects it.
+//
+// Useful for testing the demodulator and for producing captures.
+func Modulate(cfg PacketConfig, bits []byte, deviation float64) []byte {
+	fs := float64(cfg.DeviceSampleRate)
+	symbolLength := cfg.SymbolLength * cfg.Decimation
+
+	out := make([]byte, 0, len(bits)*symbolLength*2)
+
+	var phase float64
+	for _, bit := range bits {
+		freq := -fs/4 - deviation
+		if bit == 1 {
+			freq = -fs/4 + deviation
+		}
+
+		step := 2 * math.Pi * freq / fs
+		for n := 0; n < symbolLength; n++ {
+			out = append(out, sampleByte(0.8*math.Cos(phase)), sampleByte(0.8*math.Sin(phase)))
+			phase = math.Mod(phase+step, 2*math.Pi)
+		}
+	}
+
+	return out
+}
+
+// Inverse of ByteToCmplxLUT.
+func sampleByte(v float64) byte {
+	return byte(math.Max(0, math.Min(255, math.Round(v*127.6+127.4))))
+}
@@ -0,0 +1,74 @@
+package dsp
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// ParseTemplate builds a correlation template from a preamble bit string:
+// '1' expects a one, '0' a zero and 'x' matches either.
+func ParseTemplate(preamble string) ([]float64, error) {
+	template := make([]float64, len(preamble))
+	for idx, c := range strings.ToLower(preamble) {
+		switch c {
+		case '1':
+			template[idx] = 1
+		case '0':
+			template[idx] = -1
+		case 'x':
+		default:
+			return nil, fmt.Errorf("dsp: invalid preamble symbol %q", c)
+		}
+	}
+	return template, nil
+}
+
+// hardTemplate reports whether a template and threshold only match the exact
+// bit string the template expects, returning it. These can use the much
+// faster byte finder.
+func hardTemplate(template []float64, threshold float64) (pattern []byte, ok bool) {
+	if threshold < 1 {
+		return nil, false
+	}
+
+	pattern = make([]byte, len(template))
+	for idx, weight := range template {
+		switch weight {
+		case 1:
+			pattern[idx] = 1
+		case -1:
+		default:
+			return nil, false
+		}
+	}
+	return pattern, true
+}
+
+// correlate returns the index of every window of symbols matching the
+// template. Each symbol adds its weight to the window's score if it's a one
+// and subtracts it if it's a zero, windows match if their score is at least
+// threshold times the largest possible score.
+func correlate(symbols []byte, template []float64, threshold float64) (indexes []int) {
+	var max float64
+	for _, weight := range template {
+		max += math.Abs(weight)
+	}
+	min := threshold*max - 1e-9
+
+	for idx := 0; idx+len(template) <= len(symbols); idx++ {
+		var score float64
+		for tIdx, weight := range template {
+			if symbols[idx+tIdx] == 1 {
+				score += weight
+			} else {
+				score -= weight
+			}
+		}
+		if score >= min {
+			indexes = append(indexes, idx)
+		}
+	}
+
+	return indexes
+}
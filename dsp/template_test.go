@@ -0,0 +1,108 @@
+package dsp
+
+import "testing"
+
+func TestParseTemplate(t *testing.T) {
+	template, err := ParseTemplate("10x1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []float64{1, -1, 0, 1}; len(template) != len(want) || template[0] != want[0] ||
+		template[1] != want[1] || template[2] != want[2] || template[3] != want[3] {
+		t.Fatalf("got %v, want %v", template, want)
+	}
+
+	if _, err := ParseTemplate("1021"); err == nil {
+		t.Fatal("expected error for invalid symbol")
+	}
+}
+
+func TestCorrelate(t *testing.T) {
+	symbols := []byte{0, 1, 0, 1, 1, 0, 1, 0, 1}
+
+	// Exact matches of 1011 and 1x11.
+	if got := correlate(symbols, []float64{1, -1, 1, 1}, 1); len(got) != 1 || got[0] != 1 {
+		t.Fatalf("exact: got %v", got)
+	}
+	if got := correlate(symbols, []float64{1, 0, 1, 1}, 1); len(got) != 1 || got[0] != 1 {
+		t.Fatalf("don't care: got %v", got)
+	}
+
+	// Allowing one of four symbols wrong, worth 6 of 8.
+	if got := correlate(symbols, []float64{1, -1, 1, 1}, 0.5); len(got) != 2 || got[0] != 1 || got[1] != 4 {
+		t.Fatalf("one error: got %v", got)
+	}
+
+	// A heavily weighted symbol must match.
+	if got := correlate(symbols, []float64{4, -1, 1, 1}, 0.5); len(got) != 2 || got[0] != 1 || got[1] != 4 {
+		t.Fatalf("weighted: got %v", got)
+	}
+	if got := correlate(symbols, []float64{-4, -1, 1, 1}, 0.5); len(got) != 0 {
+		t.Fatalf("weighted: got %v", got)
+	}
+}
+
+// A preamble with a symbol in error is missed by the exact search but found
+// by a template tolerating it.
+func TestTemplateSearch(t *testing.T) {
+	exact := NewPacketConfig(19200, 14, 16, 80, "1100101110001001")
+	template, _ := ParseTemplate("1100101110001001")
+	tolerant := NewTemplatePacketConfig(19200, 14, 80, template, 0.75)
+
+	if exact.PreambleFinder == nil || tolerant.PreambleFinder != nil {
+		t.Fatal("only exact templates should use the byte finder")
+	}
+
+	symbols := packetSymbols(0x80, 0x05, 0x60, 0x2E, 0xE0, 0x00, 0x12, 0x34)
+	symbols[32+5] ^= 1
+
+	for _, tc := range []struct {
+		cfg   PacketConfig
+		found bool
+	}{
+		{exact, false},
+		{tolerant, true},
+	} {
+		cfg := tc.cfg
+		capture := make([]byte, 2*(5*cfg.BlockSize+cfg.BlockSize/2-32*cfg.SymbolLength))
+		capture = append(capture, Modulate(cfg, symbols, 9600)...)
+		capture = append(capture, make([]byte, 2*(cfg.BufferLength+cfg.BlockSize))...)
+		for idx := range capture {
+			if capture[idx] == 0 {
+				capture[idx] = 127
+			}
+		}
+
+		var pkts []Packet
+		d := NewDemodulator(&cfg)
+		for idx := 0; idx+cfg.BlockSize2 <= len(capture); idx += cfg.BlockSize2 {
+			pkts = append(pkts, d.Demodulate(capture[idx:idx+cfg.BlockSize2])...)
+		}
+
+		if found := len(pkts) > 0; found != tc.found {
+			t.Fatalf("threshold %v: found %d packets", cfg.Threshold, len(pkts))
+		}
+		if tc.found && pkts[0].Data[2] != 0x01 {
+			t.Fatalf("unexpected packet %02X", pkts[0].Data)
+		}
+	}
+}
+
+func TestSetTemplate(t *testing.T) {
+	cfg := NewPacketConfig(19200, 14, 16, 80, "1100101110001001")
+
+	if err := cfg.SetTemplate([]float64{1, -1}, 1); err == nil {
+		t.Fatal("expected error for a template of the wrong length")
+	}
+
+	template, _ := ParseTemplate("xxxx101110001001")
+	if err := cfg.SetTemplate(template, 0); err == nil {
+		t.Fatal("expected error for a threshold of zero")
+	}
+	if err := cfg.SetTemplate(template, 1); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.PreambleFinder != nil || cfg.Preamble != "" {
+		t.Fatal("soft template still searched for exactly")
+	}
+}
@@ -0,0 +1,89 @@
+package dsp
+
+import (
+	"fmt"
+	"math"
+)
+
+// Timing selects how symbols are sampled when framing a packet.
+//
+// The transmitter's and receiver's clocks never agree exactly, so symbols
+// don't stay SymbolLength samples apart: a rate error of half a percent
+// drifts by half a symbol over a packet, which is enough to fail its CRC.
+type Timing int
+
+const (
+	// TimingFixed samples the quantizer's output every SymbolLength samples
+	// from the preamble match.
+	TimingFixed Timing = iota
+
+	// TimingEarlyLate tracks symbol boundaries with an early-late gate on
+	// the discriminator's output, see earlyLate, and decides each symbol
+	// from its integral. Hysteresis doesn't apply.
+	TimingEarlyLate
+)
+
+func ParseTiming(s string) (Timing, error) {
+	switch s {
+	case "fixed", "":
+		return TimingFixed, nil
+	case "early-late":
+		return TimingEarlyLate, nil
+	default:
+		return TimingFixed, fmt.Errorf("unknown symbol timing: %q", s)
+	}
+}
+
+func (t Timing) String() string {
+	switch t {
+	case TimingFixed:
+		return "fixed"
+	case TimingEarlyLate:
+		return "early-late"
+	default:
+		return fmt.Sprintf("Timing(%d)", int(t))
+	}
+}
+
+// Fraction of the early-late gate's spacing the symbol clock is moved by per
+// symbol at the largest timing error. Large enough to track a couple of
+// percent of rate error, beyond which the sync word itself no longer matches,
+// small enough that noise doesn't throw the clock off.
+const earlyLateGain = 0.5
+
+// earlyLate decides symbols from discriminated samples, the first starting
+// near sample start, writes them to bits as Quantize would and writes their
+// mean values to values. Each symbol's integral is compared with integrals a
+// quarter symbol early and late: near a transition the side straddling it is
+// smaller, and the clock is moved towards the other. Between transitions
+// both are equal and the clock free runs at symbolLength samples per symbol.
+func earlyLate(disc []float64, start, symbolLength int, bits []byte, values []float64) {
+	length := float64(symbolLength)
+	spacing := length / 4
+
+	t := float64(start)
+	for idx := range bits {
+		integral := integrate(disc, t, symbolLength)
+		bits[idx] = byte(math.Float64bits(integral) >> 63)
+		values[idx] = integral / length
+
+		early := math.Abs(integrate(disc, t-spacing, symbolLength))
+		late := math.Abs(integrate(disc, t+spacing, symbolLength))
+		if sum := early + late; sum > 0 {
+			t += earlyLateGain * spacing * (late - early) / sum
+		}
+		t += length
+	}
+}
+
+// integrate sums n samples from the one nearest t, treating samples outside
+// the input as zero.
+func integrate(samples []float64, t float64, n int) (sum float64) {
+	start := int(math.Round(t))
+	for idx := start; idx < start+n; idx++ {
+		if idx >= 0 && idx < len(samples) {
+			sum += samples[idx]
+		}
+	}
+	return sum
+}
@@ -0,0 +1,95 @@
+package dsp
+
+import (
+	"math"
+	"testing"
+)
+
+// Like Modulate, but at a symbol length that needn't be a whole number of
+// samples, as sent by a transmitter whose clock disagrees with ours.
+func modulateRate(cfg PacketConfig, bits []byte, deviation, symbolLength float64) []byte {
+	fs := float64(cfg.DeviceSampleRate)
+	n := int(float64(len(bits)) * symbolLength)
+
+	out := make([]byte, 0, 2*n)
+
+	var phase float64
+	for idx := 0; idx < n; idx++ {
+		freq := -fs/4 - deviation
+		if bits[int(float64(idx)/symbolLength)] == 1 {
+			freq = -fs/4 + deviation
+		}
+
+		out = append(out, sampleByte(0.8*math.Cos(phase)), sampleByte(0.8*math.Sin(phase)))
+		phase = math.Mod(phase+2*math.Pi*freq/fs, 2*math.Pi)
+	}
+
+	return out
+}
+
+// Demodulate a packet sent at the given symbol length and report whether its
+// data came through intact.
+func decodesAt(timing Timing, symbolLength float64) bool {
+	cfg := NewPacketConfig(19200, 14, 16, 80, "1100101110001001")
+	cfg.Timing = timing
+
+	data := []byte{0x80, 0x05, 0x60, 0x2E, 0xE0, 0x00, 0x12, 0x34}
+
+	silence := func(samples int) []byte {
+		s := make([]byte, 2*samples)
+		for idx := range s {
+			s[idx] = 127
+		}
+		return s
+	}
+
+	capture := silence(3 * cfg.BlockSize)
+	capture = append(capture, modulateRate(cfg, packetSymbols(data...), 9600, symbolLength)...)
+	capture = append(capture, silence(cfg.BufferLength+cfg.BlockSize)...)
+
+	d := NewDemodulator(&cfg)
+	for idx := 0; idx+cfg.BlockSize2 <= len(capture); idx += cfg.BlockSize2 {
+		for _, pkt := range d.Demodulate(capture[idx : idx+cfg.BlockSize2]) {
+			intact := true
+			for bIdx, b := range data {
+				if pkt.Data[2+bIdx] != reverse(b) {
+					intact = false
+				}
+			}
+			if intact {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func TestParseTiming(t *testing.T) {
+	for _, timing := range []Timing{TimingFixed, TimingEarlyLate} {
+		if parsed, err := ParseTiming(timing.String()); err != nil || parsed != timing {
+			t.Errorf("%s: got %s, %v", timing, parsed, err)
+		}
+	}
+	if _, err := ParseTiming("gardner"); err == nil {
+		t.Error("expected unknown timing to be rejected")
+	}
+}
+
+func TestEarlyLate(t *testing.T) {
+	for _, timing := range []Timing{TimingFixed, TimingEarlyLate} {
+		if !decodesAt(timing, 14) {
+			t.Errorf("%s: expected a packet at the nominal rate", timing)
+		}
+	}
+
+	// 1.5% slow and fast, drifting about a symbol and a quarter over the
+	// packet.
+	for _, symbolLength := range []float64{14 * 1.015, 14 / 1.015} {
+		if decodesAt(TimingFixed, symbolLength) {
+			t.Errorf("symbol length %.2f: fixed timing unexpectedly decoded", symbolLength)
+		}
+		if !decodesAt(TimingEarlyLate, symbolLength) {
+			t.Errorf("symbol length %.2f: early-late timing didn't decode", symbolLength)
+		}
+	}
+}
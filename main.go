@@ -18,22 +18,95 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
+	"math"
 	"math/rand"
+	"net"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
+	"unicode"
 
+	"github.com/bemasher/rtldavis/dsp"
 	"github.com/bemasher/rtldavis/protocol"
-	"github.com/jpoirier/gortlsdr"
+	"github.com/bemasher/rtldavis/receiver"
+	"github.com/bemasher/rtldavis/sink"
 )
 
 var (
-	id      *int
-	verbose *bool
+	id         *int
+	idList     *string
+	discovery  *time.Duration
+	verbose    *bool
+	decimation *int
+	hysteresis *float64
+	blockSize  *int
+	dcBlock    dsp.DCBlock
+	timing     dsp.Timing
+	invert     *bool
+	autoInvert *bool
+	correct    *int
+
+	template          []float64
+	templateThreshold *float64
+
+	includeInvalid *bool
+
+	sampleFilename *string
+	realtime       *bool
+	recordFilename *string
+	replayFilename *string
+	format         *string
+	units          protocol.Units
+	decoder        protocol.Decoder
+
+	continuity        *bool
+	rejectOffSchedule *bool
+
+	stateExpiry *time.Duration
+	stateLimit  protocol.StateLimit
+
+	statsInterval *time.Duration
+
+	downgrade *bool
+
+	settle     *time.Duration
+	autoSettle *bool
+
+	gain              int
+	gainOffsets       receiver.GainOffsets
+	calibrate         *bool
+	calibrateDuration *time.Duration
+
+	trackTiming *bool
+	verifyHops  *bool
+
+	scan         *bool
+	scanDuration *time.Duration
+
+	crcDetect *bool
+
+	driverName *string
+	deviceList *string
+	regionList *string
+	reconnect  *time.Duration
+
+	ids     []int
+	idsFile *string
+	sources []string
+	regions []protocol.Region
+	out     sink.Sink
+	loop    *sink.Loop
+	loopIDs []int
+	udp     *sink.UDP
 
 	verboseLogger *log.Logger
 )
@@ -42,136 +115,713 @@ func init() {
 	log.SetFlags(log.Lmicroseconds)
 	rand.Seed(time.Now().UnixNano())
 
-	id = flag.Int("id", 0, "id of the station to listen for")
+	id = flag.Int("id", 0, "id of the station to listen for, -1 discovers transmitters at startup")
+	idList = flag.String("ids", "", "comma separated ids of the stations to listen for, overrides -id")
+	idsFile = flag.String("ids-file", "", "read the ids of the stations to listen for from this file, overrides -ids, and reread it on SIGHUP")
+	discovery = flag.Duration("discovery", receiver.DefaultDiscoveryTime, "how long to discover transmitters for with -id -1")
+	driverName = flag.String("driver", "rtlsdr", "device driver: rtlsdr, rtltcp, or soapy if built with -tags soapy")
+	deviceList = flag.String("device", "0", "comma separated devices to use: rtl-sdr indexes or serials, rtl_tcp host:port, or soapy device arguments with pairs separated by ';', e.g. driver=airspy;serial=123")
+	reconnect = flag.Duration("reconnect", receiver.DefaultTCPMaxBackoff, "longest wait between attempts to reconnect to an rtl_tcp server, 0 exits when the connection is lost")
+	regionList = flag.String("region", "us", "comma separated regions of the stations to listen for, one per device: us or eu")
 	verbose = flag.Bool("v", false, "log extra information to /dev/stderr")
+	decimation = flag.Int("decimation", 1, "sample the device at this multiple of the demodulator's sample rate")
+	blockSize = flag.Int("blocksize", dsp.DefaultBlockSize, "samples demodulated at a time, larger uses less cpu but adds latency")
+	hysteresis = flag.Float64("hysteresis", 0, "quantizer dead band around zero as a fraction of the discriminator's swing")
+	templateDesc := flag.String("template", "", "preamble correlation template: a bit string with x for don't care, or comma separated weights, 16 symbols")
+	templateThreshold = flag.Float64("template-threshold", 1, "fraction of the template's largest correlation a preamble must reach")
+
+	sampleFilename = flag.String("file", "", "read samples captured with rtl_sdr instead of a device, - reads stdin")
+	realtime = flag.Bool("realtime", false, "play -file back at its sample rate instead of as fast as possible")
+	correct = flag.Int("correct", 0, "repair packets failing their crc by up to this many bits using the last valid packet, flagged as corrected")
+	includeInvalid = flag.Bool("include-invalid", false, "also output packets failing their crc, flagged as invalid, for debugging")
+	dcBlockName := flag.String("dc-block", "none", "remove the dc spike: none, mean (per block) or iir")
+	invert = flag.Bool("invert", false, "conjugate samples, for dongles delivering the spectrum inverted")
+	autoInvert = flag.Bool("auto-invert", false, "detect an inverted spectrum by alternating -invert until a valid packet is received")
+	timingName := flag.String("timing", "fixed", "symbol timing: fixed, or early-late to track transmitters whose clock is off")
+	recordFilename = flag.String("record", "", "append received packets to a binary log")
+	replayFilename = flag.String("replay", "", "decode packets from a binary log and exit")
+	format = flag.String("format", "log", "output format: log, json or csv")
+	udpAddr := flag.String("udp", "", "also send each reading as a json datagram to this host:port, e.g. 192.168.1.10:5555")
+	loopAddr := flag.String("loop", "", "emulate a Davis console's LOOP command for clients connecting to this address, e.g. :22222")
+	loopIDList := flag.String("loop-ids", "", "comma separated ids of the transmitters -loop reports, wind from the first, default those followed")
+	flag.Float64Var(&decoder.DirectionOffset, "direction-offset", 0, "degrees added to the wind direction for json and csv output")
+	validate := flag.Bool("validate", false, "flag readings outside their sensor's plausible range in json and csv output")
+	rangeList := flag.String("ranges", "", "override plausible ranges, implies -validate: name=min:max,... e.g. temperature=-40:140,wind=0:150")
+	flag.BoolVar(&decoder.Reject, "reject-out-of-range", false, "omit the value of readings flagged by -validate")
+	unitSystem := flag.String("units", "imperial", "unit system for json and csv output: imperial or metric")
+	smooth := flag.Duration("smooth", 0, "add an exponential moving average of temperature, humidity, uv, solar and supercap values with this time constant to json and csv output, lagging the raw value by about as long, 0 disables")
+	trend := flag.Bool("trend", false, "add whether temperature is rising, falling or steady over -trend-interval to json and csv output")
+	trendInterval := flag.Duration("trend-interval", protocol.DefaultTrendInterval, "interval -trend compares values over")
+
+	continuity = flag.Bool("continuity", false, "log messages arriving off their transmitter's schedule")
+	rejectOffSchedule = flag.Bool("reject-off-schedule", false, "drop messages arriving off their transmitter's schedule, implies -continuity")
+
+	stateExpiry = flag.Duration("state-expiry", 0, "forget a transmitter's continuity, rain, smoothing, trend and correction state once it hasn't been heard for this long, 0 never")
+
+	statsInterval = flag.Duration("stats", 0, "log receiver statistics at this interval, 0 disables")
+
+	downgrade = flag.Bool("downgrade", true, "lower the sample rate if the host can't keep up instead of dropping samples")
+
+	settle = flag.Duration("settle", -1, "discard samples for this long after each retune, negative uses the tuner's default")
+	autoSettle = flag.Bool("auto-settle", false, "measure the settle time from when signal power stabilizes after each retune, starting from -settle")
+
+	gainDB := flag.Float64("gain", 0, "fix the tuner gain at this many dB instead of leaving it automatic, 0 automatic")
+	gainOffsetsFile := flag.String("gain-offsets", "", "adjust -gain per channel from a file of channel indexes and offsets in dB, as printed by -calibrate")
+	calibrate = flag.Bool("calibrate", false, "measure each channel's noise floor at -gain, print gain offsets that flatten reception across the band and exit")
+	calibrateDuration = flag.Duration("calibrate-duration", receiver.DefaultCalibrationTime, "how long to measure with -calibrate")
+
+	trackTiming = flag.Bool("track-timing", false, "refine each transmitter's hop timing from its packets' arrival times instead of assuming the nominal dwell time")
+	verifyHops = flag.Bool("verify-hops", true, "log if the channels packets arrive on don't follow the region's hop pattern")
+
+	scan = flag.Bool("scan", false, "report the transmitters heard on any id and exit")
+	scanDuration = flag.Duration("scan-duration", 5*time.Minute, "how long to listen with -scan")
+
+	crcDetect = flag.Bool("crc-detect", false, "report how many packets in the capture given by -file pass each candidate crc configuration and exit")
 
 	flag.Parse()
 
+	// A capture may also be given as the only argument: rtl_sdr - | rtldavis -
+	if *sampleFilename == "" && flag.NArg() == 1 {
+		*sampleFilename = flag.Arg(0)
+	}
+
 	verboseLogger = log.New(ioutil.Discard, "", log.Lshortfile|log.Lmicroseconds)
 	if *verbose {
 		verboseLogger.SetOutput(os.Stderr)
 	}
-}
 
-func main() {
-	p := protocol.NewParser(14, *id)
-	p.Cfg.Log()
+	var err error
+	if ids, err = parseIDs(*idList, *id); err != nil {
+		log.Fatal(err)
+	}
+	if *idsFile != "" {
+		if ids, err = readIDs(*idsFile); err != nil {
+			log.Fatal(err)
+		}
+	}
 
-	fs := p.Cfg.SampleRate
+	gain = int(math.Round(*gainDB * 10))
+	if *gainOffsetsFile != "" {
+		if gainOffsets, err = readGainOffsets(*gainOffsetsFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if gain <= 0 && (*gainOffsetsFile != "" || *calibrate) {
+		log.Fatal("-gain-offsets and -calibrate need a fixed -gain")
+	}
 
-	dev, err := rtlsdr.Open(0)
-	if err != nil {
+	if sources, regions, err = parseSources(*deviceList, *regionList); err != nil {
 		log.Fatal(err)
 	}
 
-	hop := p.RandHop()
-	verboseLogger.Println(hop)
-	if err := dev.SetCenterFreq(hop.ChannelFreq); err != nil {
-		log.Fatal(err)
+	if *templateDesc != "" {
+		if template, err = parseTemplate(*templateDesc); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *sampleFilename != "" {
+		if len(sources) > 1 {
+			log.Fatal("-file reads a single capture, give a single device and region")
+		}
+		sources[0] = *sampleFilename
 	}
 
-	if err := dev.SetSampleRate(fs); err != nil {
+	if dcBlock, err = dsp.ParseDCBlock(*dcBlockName); err != nil {
 		log.Fatal(err)
 	}
-
-	if err := dev.SetTunerGainMode(false); err != nil {
+	if timing, err = dsp.ParseTiming(*timingName); err != nil {
 		log.Fatal(err)
 	}
 
-	if err := dev.ResetBuffer(); err != nil {
+	// Only keep state for the transmitters being followed.
+	stateLimit.Expiry = *stateExpiry
+	if !*scan {
+		stateLimit.IDs = ids
+	}
+
+	decoder.Rain = protocol.NewRainAccumulator()
+	decoder.Rain.SetLimit(stateLimit)
+	if *smooth > 0 {
+		decoder.Smooth = protocol.NewSmoother(*smooth)
+		decoder.Smooth.SetLimit(stateLimit)
+	}
+	if *trend {
+		decoder.Trends = protocol.NewTrends(*trendInterval)
+		decoder.Trends.SetLimit(stateLimit)
+	}
+	if *validate || *rangeList != "" || decoder.Reject {
+		decoder.Ranges = protocol.DefaultRanges()
+		if err := decoder.Ranges.Set(*rangeList); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if units, err = protocol.ParseUnits(*unitSystem); err != nil {
 		log.Fatal(err)
 	}
 
-	in, out := io.Pipe()
+	switch *format {
+	case "log":
+	case "json":
+		out = sink.NewJSON(os.Stdout, units)
+	case "csv":
+		out = sink.NewCSV(os.Stdout, units)
+	default:
+		log.Fatalf("unknown output format: %q", *format)
+	}
+
+	if *udpAddr != "" {
+		if udp, err = sink.DialUDP(*udpAddr, units); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *loopAddr != "" {
+		ln, err := net.Listen("tcp", *loopAddr)
+		if err != nil {
+			log.Fatal(err)
+		}
 
-	go dev.ReadAsync(func(buf []byte) {
-		out.Write(buf)
-	}, nil, 1, p.Cfg.BlockSize2)
+		if loopIDs, err = parseIDs(*loopIDList, -1); err != nil {
+			log.Fatal(err)
+		}
+		limit := stateLimit
+		if len(loopIDs) > 0 {
+			limit.IDs = loopIDs
+		}
 
-	// Handle frequency hops concurrently since the callback will stall if we
-	// stop reading to hop.
-	nextHop := make(chan protocol.Hop, 1)
-	go func() {
-		for hop := range nextHop {
-			verboseLogger.Printf("Hop: %s\n", hop)
-			if err := dev.SetCenterFreq(hop.ChannelFreq + hop.FreqError); err != nil {
+		loop = sink.NewLoop()
+		loop.SetLimit(limit)
+		go func() {
+			log.Fatal(loop.Serve(ln))
+		}()
+	}
+}
+
+// Parse the ids given by -ids, falling back to -id. An empty list means
+// transmitters should be discovered.
+func parseIDs(list string, id int) (ids []int, err error) {
+	if list == "" {
+		if id < 0 {
+			return nil, nil
+		}
+		return []int{id}, nil
+	}
+
+	for _, field := range strings.Split(list, ",") {
+		id, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || id < 0 || id > 7 {
+			return nil, fmt.Errorf("invalid transmitter id: %q", field)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+// Read the ids given by -ids-file, separated by commas or whitespace.
+func readIDs(filename string) ([]int, error) {
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := strings.FieldsFunc(string(buf), func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("%s: no transmitter ids", filename)
+	}
+	return parseIDs(strings.Join(fields, ","), -1)
+}
+
+// Reread -ids-file on SIGHUP and follow the ids it gives. Transmitters that
+// remain keep their hop sync, state for those removed is forgotten.
+func reloadIDs(ctx context.Context, receivers []*receiver.Receiver) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	limit := stateLimit
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+		}
+
+		newIDs, err := readIDs(*idsFile)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+
+		limit.IDs = newIDs
+		decoder.Rain.SetLimit(limit)
+		if decoder.Smooth != nil {
+			decoder.Smooth.SetLimit(limit)
+		}
+		if decoder.Trends != nil {
+			decoder.Trends.SetLimit(limit)
+		}
+		if loop != nil && len(loopIDs) == 0 {
+			loop.SetLimit(limit)
+		}
+		for _, r := range receivers {
+			if err := r.UpdateTransmitters(newIDs); err != nil {
 				log.Fatal(err)
 			}
 		}
+		log.Printf("Reloaded %s, following transmitters %v\n", *idsFile, newIDs)
+	}
+}
+
+// Parse a template given as a bit string or as comma separated weights.
+func parseTemplate(desc string) ([]float64, error) {
+	if !strings.Contains(desc, ",") {
+		return dsp.ParseTemplate(desc)
+	}
+
+	var template []float64
+	for _, field := range strings.Split(desc, ",") {
+		weight, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template weight: %w", err)
+		}
+		template = append(template, weight)
+	}
+	return template, nil
+}
+
+// Build a parser for the given region configured from flags.
+func newParser(region protocol.Region) *protocol.Parser {
+	firstID := 0
+	if len(ids) > 0 {
+		firstID = ids[0]
+	}
+
+	p := protocol.NewParser(14, firstID)
+	p.SetRegion(region)
+	p.SetDecimation(*decimation)
+	if err := p.SetBlockSize(*blockSize); err != nil {
+		log.Fatal(err)
+	}
+	p.Cfg.Hysteresis = *hysteresis
+	if template != nil {
+		if err := p.Cfg.SetTemplate(template, *templateThreshold); err != nil {
+			log.Fatal(err)
+		}
+	}
+	p.Cfg.DCBlock = dcBlock
+	p.Cfg.Timing = timing
+	p.SetSpectrumInverted(*invert)
+	p.SetStateLimit(stateLimit)
+	p.EnableCorrection(*correct)
+	p.IncludeInvalid = *includeInvalid
+
+	return &p
+}
+
+func main() {
+	if *replayFilename != "" {
+		replay(newParser(regions[0]), *replayFilename)
+		return
+	}
+	if *crcDetect {
+		detectCRC(newParser(regions[0]))
+		return
+	}
+
+	var recordFile *os.File
+	if *recordFilename != "" {
+		var err error
+		recordFile, err = os.OpenFile(*recordFilename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, os.Kill)
+	go func() {
+		<-sig
+		cancel()
 	}()
 
+	if *calibrate {
+		calibrateGain(ctx)
+		return
+	}
+
+	if *scan {
+		ctx, cancel = context.WithTimeout(ctx, *scanDuration)
+		defer cancel()
+		log.Printf("Scanning for %s\n", *scanDuration)
+	}
+
+	// One receiver per device, each with its own parser and region.
+	var receivers []*receiver.Receiver
+	var closers []io.Closer
+
 	defer func() {
-		in.Close()
-		out.Close()
-		dev.CancelAsync()
-		dev.Close()
+		for _, closer := range closers {
+			closer.Close()
+		}
+		if recordFile != nil {
+			recordFile.Close()
+		}
 		os.Exit(0)
 	}()
 
-	sig := make(chan os.Signal, 1)
-	signal.Notify(sig, os.Interrupt, os.Kill)
+	for idx, source := range sources {
+		p := newParser(regions[idx])
+		if idx == 0 {
+			p.Cfg.Log()
+		}
 
-	block := make([]byte, p.Cfg.BlockSize2)
+		dev, closer, err := openDevice(p.Cfg, source)
+		if err != nil {
+			log.Fatal(err)
+		}
+		closers = append(closers, closer)
+		interruptOnDone(ctx, dev, closer)
+
+		// When scanning, discover transmitters for the whole scan and
+		// report what was heard.
+		cfg := receiver.Config{
+			Source:        source,
+			IDs:           ids,
+			DiscoveryTime: *discovery,
+			Downgrade:     *downgrade,
+			SettleTime:    settleTime(dev),
+			AutoSettle:    *autoSettle,
+			Gain:          gain,
+			GainOffsets:   gainOffsets,
+			TrackTiming:   *trackTiming,
+			VerifyHops:    *verifyHops,
+			AutoInvert:    *autoInvert,
+			Log:           verboseLogger,
+		}
+		if *continuity || *rejectOffSchedule {
+			cfg.Continuity = p.NewContinuity(protocol.DefaultTolerance)
+			cfg.Continuity.SetLimit(stateLimit)
+			cfg.RejectOffSchedule = *rejectOffSchedule
+		}
+		if *scan {
+			cfg.IDs = nil
+			cfg.DiscoveryTime = *scanDuration
+		}
+
+		receivers = append(receivers, receiver.New(p, dev, cfg))
+	}
 
-	// Set the dwellTimer for one full rotation of the pattern + 1. Some channels
-	// may have enough frequency error that they won't receive until we've
-	// seen at least one message and set the frequency correction.
-	dwellTimer := time.After(52 * p.DwellTime)
-	// We set missCount to 3 so that we immediately pick another random
-	// channel and wait on that channel instead of hopping like we missed one.
-	missCount := 3
+	done := make(chan error, len(receivers))
+	for _, r := range receivers {
+		go func(r *receiver.Receiver) {
+			done <- r.Run(ctx)
+		}(r)
+	}
+
+	if *statsInterval > 0 {
+		go logStats(ctx, receivers, *statsInterval)
+	}
+	if *idsFile != "" && !*scan {
+		go reloadIDs(ctx, receivers)
+	}
+
+	for msg := range receiver.Merge(receivers...) {
+		if *scan {
+			verboseLogger.Println(msg)
+			continue
+		}
+
+		output(msg)
+
+		// Only log what was received intact, corrected messages are a guess.
+		if recordFile != nil && msg.CRCValid {
+			if err := protocol.WriteRecord(recordFile, protocol.NewRecord(msg)); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+
+	for range receivers {
+		switch err := <-done; err {
+		case nil, context.Canceled, context.DeadlineExceeded:
+		case io.EOF, io.ErrUnexpectedEOF:
+			// End of a capture.
+		default:
+			log.Fatal(err)
+		}
+	}
+
+	if *scan {
+		for idx, r := range receivers {
+			results := r.Survey()
+			if len(receivers) > 1 {
+				log.Printf("%s (%s):\n", sources[idx], regions[idx])
+			}
+			if len(results) == 0 {
+				log.Println("No transmitters found")
+			}
+			for _, t := range results {
+				log.Println(t)
+			}
+		}
+	}
+}
+
+// Measure each device's channels and print the gain offsets suggested, in
+// the format -gain-offsets reads.
+func calibrateGain(ctx context.Context) {
+	for idx, source := range sources {
+		p := newParser(regions[idx])
+		dev, closer, err := openDevice(p.Cfg, source)
+		if err != nil {
+			log.Fatal(err)
+		}
+		interruptOnDone(ctx, dev, closer)
+
+		log.Printf("Calibrating %s for %s\n", source, *calibrateDuration)
+		c, err := receiver.Calibrate(ctx, p, dev, receiver.CalibrationConfig{
+			Gain:       gain,
+			SettleTime: settleTime(dev),
+			Duration:   *calibrateDuration,
+		})
+		closer.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if len(sources) > 1 {
+			fmt.Printf("# %s (%s)\n", source, regions[idx])
+		}
+		fmt.Print(c)
+	}
+}
+
+// Read the table given by -gain-offsets.
+func readGainOffsets(filename string) (receiver.GainOffsets, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return receiver.ReadGainOffsets(f)
+}
+
+// Log each receiver's stats periodically.
+func logStats(ctx context.Context, receivers []*receiver.Receiver, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
 
 	for {
 		select {
-		case <-sig:
+		case <-ctx.Done():
 			return
-		case <-dwellTimer:
-			// If the dwellTimer has expired one of two things has happened:
-			//     1: We've missed a message.
-			//     2: We've waited for sync and nothing has happened for a
-			//        full cycle of the pattern.
-
-			// Reset the timer and incrmeent the missed packet counter.
-			dwellTimer = time.After(p.DwellTime)
-			missCount++
-
-			if missCount >= 3 {
-				// We've missed three packets in a row, hop to a random
-				// channel and wait for a full hopping cycle.
-				nextHop <- p.RandHop()
-				dwellTimer = time.After(52 * p.DwellTime)
-			} else {
-				// We've missed fewer than three packets in a row, hop to the
-				// next channel in the pattern.
-				nextHop <- p.NextHop()
+		case <-ticker.C:
+			for idx, r := range receivers {
+				log.Printf("%s: %s\n", sources[idx], r.Stats())
 			}
-		default:
-			in.Read(block)
+		}
+	}
+}
 
-			recvPacket := false
-			for _, msg := range p.Parse(p.Demodulate(block)) {
-				if int(msg.ID) != *id {
-					continue
-				}
+// The settle time given by -settle, or the device's default. Captures need
+// none, they play back whatever was recorded.
+func settleTime(dev receiver.Device) time.Duration {
+	if *settle >= 0 {
+		return *settle
+	}
+	if st, ok := dev.(settleTimer); ok {
+		return st.SettleTime()
+	}
+	return 0
+}
 
-				recvPacket = true
-				log.Printf("%02X\n", msg.Data)
-			}
+// A remote device's Read blocks for as long as its server is unreachable,
+// close it once ctx is done so the receiver reading it returns. Local devices
+// return from Read on their own and can't be closed while being read.
+func interruptOnDone(ctx context.Context, dev receiver.Device, closer io.Closer) {
+	if _, ok := dev.(receiver.ConnectionReporter); !ok {
+		return
+	}
+	go func() {
+		<-ctx.Done()
+		closer.Close()
+	}()
+}
 
-			if recvPacket {
-				// Reset the missed packet counter.
-				missCount = 0
+// Open the capture given by -file, or the device given by source using the
+// selected driver.
+func openDevice(cfg dsp.PacketConfig, source string) (receiver.Device, io.Closer, error) {
+	if *sampleFilename == "" {
+		return openDriver(*driverName, cfg, source)
+	}
 
-				// Set the dwell timer to 1.5 * dwell time. If this timer
-				// expires before we've received a packet then the missed
-				// packet hopping logic will reset the timer to exactly the
-				// dwell time and we then expect packets to arrive half-way
-				// through the timer.
-				dwellTimer = time.After(p.DwellTime + p.DwellTime>>1)
+	f := os.Stdin
+	if *sampleFilename != "-" {
+		var err error
+		if f, err = os.Open(*sampleFilename); err != nil {
+			return nil, nil, err
+		}
+	}
 
-				// Hop to the next channel.
-				nextHop <- p.NextHop()
+	src := receiver.NewFileSource(f)
+	if *realtime {
+		src.SetRealtime(cfg.DeviceSampleRate)
+	}
+
+	return src, f, nil
+}
+
+// Pair each device with a region. A single region applies to every device.
+func parseSources(deviceList, regionList string) (sources []string, regions []protocol.Region, err error) {
+	for _, name := range strings.Split(regionList, ",") {
+		region, err := protocol.ParseRegion(strings.TrimSpace(name))
+		if err != nil {
+			return nil, nil, err
+		}
+		regions = append(regions, region)
+	}
+
+	for _, source := range strings.Split(deviceList, ",") {
+		sources = append(sources, strings.TrimSpace(source))
+	}
+
+	if len(regions) == 1 {
+		for len(regions) < len(sources) {
+			regions = append(regions, regions[0])
+		}
+	}
+	if len(regions) != len(sources) {
+		return nil, nil, fmt.Errorf("%d regions given for %d devices", len(regions), len(sources))
+	}
+
+	return sources, regions, nil
+}
+
+// Demodulate the capture given by -file, framing everything transmitted
+// after the preamble, and report how many packets pass each candidate CRC
+// configuration.
+func detectCRC(p *protocol.Parser) {
+	if *sampleFilename == "" {
+		log.Fatal("-crc-detect needs a capture given by -file")
+	}
+	if err := p.SetFrameLength(protocol.TransmittedLength); err != nil {
+		log.Fatal(err)
+	}
+
+	dev, closer, err := openDevice(p.Cfg, *sampleFilename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer closer.Close()
+
+	detector := protocol.NewCRCDetector()
+	block := make([]byte, p.Cfg.DeviceBlockSize2)
+	for {
+		n, err := io.ReadFull(dev, block)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			// Push the partial block and everything buffered through with
+			// silence.
+			for idx := n &^ 1; idx < len(block); idx++ {
+				block[idx] = 127
 			}
+			for idx := 0; idx <= p.Cfg.BufferLength/p.Cfg.BlockSize; idx++ {
+				detector.Add(p.Demodulate(block))
+				for idx := range block {
+					block[idx] = 127
+				}
+			}
+			break
+		} else if err != nil {
+			log.Fatal(err)
 		}
+
+		detector.Add(p.Demodulate(block))
+	}
+
+	results := detector.Results()
+	for _, r := range results {
+		log.Printf("%-44s %d of %d packets\n", r.Hypothesis, r.Valid, detector.Packets)
+	}
+
+	best := results[0]
+	switch {
+	case best.Valid == 0:
+		log.Println("No packet passed any configuration, check the capture's frequency and sample rate")
+	case best.Hypothesis.Builtin():
+		log.Printf("Best: %s, as built in\n", best.Hypothesis)
+	default:
+		log.Printf("Best: %s, differs from the built-in %s\n", best.Hypothesis, protocol.CRCHypotheses[0])
+	}
+}
+
+// Decode a binary log written with -record.
+func replay(p *protocol.Parser, filename string) {
+	f, err := os.Open(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	err = p.Replay(f, func(rec protocol.Record, msg protocol.Message) {
+		if !following(int(msg.ID)) {
+			return
+		}
+		verboseLogger.Println(rec)
+		output(msg)
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Reports whether messages from id should be output, any id is accepted if
+// none were given.
+func following(id int) bool {
+	if len(ids) == 0 {
+		return true
+	}
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Write a message in the selected output format.
+func output(msg protocol.Message) {
+	r := decoder.Decode(msg)
+	if loop != nil {
+		if err := loop.Write(r); err != nil {
+			log.Fatal(err)
+		}
+	}
+	// Datagrams are fire and forget, an unreachable collector isn't fatal.
+	if udp != nil {
+		if err := udp.Write(r); err != nil {
+			verboseLogger.Println(err)
+		}
+	}
+
+	if out == nil {
+		if msg.Corrected {
+			log.Printf("%02X corrected\n", msg.Data)
+		} else if !msg.CRCValid {
+			log.Printf("%02X invalid\n", msg.Data)
+		} else {
+			log.Printf("%02X\n", msg.Data)
+		}
+		return
+	}
+
+	if err := out.Write(r); err != nil {
+		log.Fatal(err)
 	}
 }
@@ -0,0 +1,61 @@
+package protocol
+
+import "math"
+
+// Weighting of the signals Confidence combines.
+const (
+	// Factor for a message repaired by the corrector rather than received
+	// intact, its contents are a best guess.
+	CorrectedConfidence = 0.5
+
+	// Factor for a reading whose value is implausible, see Ranges. It
+	// passed its CRC so the value is what was sent, but a sensor reporting
+	// it is likely faulty.
+	OutOfRangeConfidence = 0.25
+
+	// Share of the score that depends on signal quality. The rest is given
+	// to any message that arrived intact, however marginal the signal.
+	SignalConfidence = 0.4
+)
+
+// Confidence scores how trustworthy a reading is from 0 to 1, for consumers
+// that only want to threshold on a single number. It's the product of:
+//
+//   - Integrity: 1 for a message that passed its CRC, CorrectedConfidence
+//     for a corrected one and 0 for one that failed.
+//   - Signal: 1 - SignalConfidence + SignalConfidence * o, where o is the
+//     opening of the packet's eye, see dsp.Eye, clamped to [0, 1]. Messages
+//     without symbol decisions, e.g. replayed ones, aren't penalized.
+//   - Plausibility: OutOfRangeConfidence if the reading is out of range, 1
+//     otherwise. Only set if the decoder validates ranges.
+//
+// A clean packet scores above 0.9, one that only just passed its CRC 0.6.
+// Corrected and out of range readings never score above 0.5, so a threshold
+// of 0.7 keeps intact readings received with a reasonably open eye.
+//
+// Signal strength and preamble correlation aren't measured per packet, the
+// eye's opening reflects both: a weak or interfered signal spreads it, and a
+// preamble only matches if its symbols are decided correctly. The individual
+// signals remain available from the reading.
+func Confidence(r Reading) float64 {
+	integrity := 0.0
+	switch {
+	case r.CRCValid:
+		integrity = 1
+	case r.Corrected:
+		integrity = CorrectedConfidence
+	}
+
+	signal := 1.0
+	if r.Eye.Count[0] > 0 && r.Eye.Count[1] > 0 {
+		opening := math.Max(0, math.Min(1, r.Eye.Opening()))
+		signal = 1 - SignalConfidence + SignalConfidence*opening
+	}
+
+	plausibility := 1.0
+	if r.OutOfRange {
+		plausibility = OutOfRangeConfidence
+	}
+
+	return integrity * signal * plausibility
+}
@@ -0,0 +1,72 @@
+package protocol
+
+import (
+	"math"
+	"testing"
+
+	"github.com/bemasher/rtldavis/dsp"
+)
+
+// An eye with the given opening: ones at 1 and zeros spread around -1.
+func testEye(opening float64) (e dsp.Eye) {
+	for idx := 0; idx < 2; idx++ {
+		e.Add(1, 1)
+	}
+	// Zeros at -1±d have a standard deviation of d, 2 from the ones.
+	d := (1 - opening) * 2 / 3
+	e.Add(-1-d, 0)
+	e.Add(-1+d, 0)
+	return e
+}
+
+func TestConfidence(t *testing.T) {
+	valid := newTestMessage(0x80, 0, 0, 0x2E, 0xE0, 0)
+	valid.CRCValid = true
+
+	for _, tc := range []struct {
+		name string
+		r    func() Reading
+		want float64
+	}{
+		{"no eye", func() Reading { return Reading{Message: valid} }, 1},
+		{"open eye", func() Reading {
+			r := Reading{Message: valid}
+			r.Eye = testEye(1)
+			return r
+		}, 1},
+		{"closed eye", func() Reading {
+			r := Reading{Message: valid}
+			r.Eye = testEye(-0.5)
+			return r
+		}, 0.6},
+		{"half open eye", func() Reading {
+			r := Reading{Message: valid}
+			r.Eye = testEye(0.5)
+			return r
+		}, 0.8},
+		{"corrected", func() Reading {
+			r := Reading{Message: valid}
+			r.CRCValid, r.Corrected = false, true
+			return r
+		}, 0.5},
+		{"failed", func() Reading {
+			r := Reading{Message: valid}
+			r.CRCValid = false
+			return r
+		}, 0},
+		{"out of range", func() Reading {
+			r := Reading{Message: valid, OutOfRange: true}
+			r.Eye = testEye(1)
+			return r
+		}, 0.25},
+	} {
+		r := tc.r()
+		if got := Confidence(r); math.Abs(got-tc.want) > 1e-9 {
+			t.Errorf("%s: got %v, want %v (eye %s)", tc.name, got, tc.want, r.Eye)
+		}
+	}
+
+	if r := Decode(valid); r.Confidence != 1 {
+		t.Errorf("decoded: got %v, want 1", r.Confidence)
+	}
+}
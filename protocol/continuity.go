@@ -0,0 +1,159 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrOffSchedule is returned for a message that arrived at a time its
+	// transmitter couldn't have sent it.
+	ErrOffSchedule = errors.New("protocol: message off schedule")
+
+	// ErrWrongChannel is returned for a message that arrived on a channel its
+	// transmitter wouldn't have hopped to.
+	ErrWrongChannel = errors.New("protocol: message on wrong channel")
+)
+
+// DefaultTolerance allows for the jitter in when blocks are read from the
+// device.
+const DefaultTolerance = 50 * time.Millisecond
+
+// Continuity checks that consecutive messages from each transmitter arrive
+// the expected number of dwell times apart and on the channel the hop
+// pattern puts them on. A CRC-passing message that arrives off schedule is
+// most likely a false decode or a second transmitter using the same id.
+//
+// Continuity is safe for concurrent use.
+type Continuity struct {
+	tolerance time.Duration
+	pattern   []int
+
+	// Pattern index of each channel.
+	patternIdx map[int]int
+
+	mu           sync.Mutex
+	transmitters *stateTable
+}
+
+type continuity struct {
+	last        Message
+	anomalies   int
+	consecutive int
+}
+
+// Number of consecutive anomalies after which we assume the reference
+// message was the false one and start over from the latest.
+const anomalyLimit = 3
+
+// NewContinuity returns a checker for the parser's hop pattern. Messages
+// arriving more than tolerance from their expected time are anomalous.
+func (p *Parser) NewContinuity(tolerance time.Duration) *Continuity {
+	c := &Continuity{
+		tolerance:    tolerance,
+		pattern:      p.hopPattern,
+		patternIdx:   make(map[int]int),
+		transmitters: newStateTable(),
+	}
+	for idx, channel := range p.hopPattern {
+		c.patternIdx[channel] = idx
+	}
+	return c
+}
+
+// Check compares msg, which must have its Time set, against the previous
+// message from the same transmitter. Anomalous messages are counted and
+// returned as an error wrapping ErrOffSchedule or ErrWrongChannel, and
+// aren't used as the reference for later messages.
+func (c *Continuity) Check(msg Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	id := int(msg.ID)
+	v, exists := c.transmitters.lookup(id, msg.Time)
+	if !exists {
+		c.transmitters.put(id, id, msg.Time, &continuity{last: msg})
+		return nil
+	}
+	t := v.(*continuity)
+	c.transmitters.put(id, id, msg.Time, t)
+
+	err := c.check(t.last, msg)
+	if err == nil || t.consecutive+1 >= anomalyLimit {
+		t.last = msg
+		t.consecutive = 0
+	} else {
+		t.consecutive++
+	}
+	if err != nil {
+		t.anomalies++
+	}
+
+	return err
+}
+
+func (c *Continuity) check(last, msg Message) error {
+	dwell := DwellTime(int(msg.ID))
+	gap := msg.Time.Sub(last.Time)
+
+	// The transmitter may have sent any number of messages we missed, but
+	// timing errors accumulate so only judge gaps of up to a full rotation of
+	// the pattern.
+	hops := int((gap + dwell/2) / dwell)
+	if gap < 0 || hops > len(c.pattern) {
+		return nil
+	}
+
+	if hops == 0 {
+		return fmt.Errorf("%w: id %d, %s after previous message", ErrOffSchedule, msg.ID, gap)
+	}
+
+	offset := gap - time.Duration(hops)*dwell
+	if offset < -c.tolerance || offset > c.tolerance {
+		return fmt.Errorf("%w: id %d, %s from expected time", ErrOffSchedule, msg.ID, offset)
+	}
+
+	if last.ChannelIdx < 0 || msg.ChannelIdx < 0 {
+		return nil
+	}
+
+	lastIdx, ok := c.patternIdx[last.ChannelIdx]
+	if !ok {
+		return nil
+	}
+
+	expected := c.pattern[(lastIdx+hops)%len(c.pattern)]
+	if msg.ChannelIdx != expected {
+		return fmt.Errorf("%w: id %d, channel %d, expected %d", ErrWrongChannel, msg.ID, msg.ChannelIdx, expected)
+	}
+
+	return nil
+}
+
+// Anomalies returns the number of anomalous messages seen from id.
+func (c *Continuity) Anomalies(id int) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if t, exists := c.transmitters.get(id); exists {
+		return t.(*continuity).anomalies
+	}
+	return 0
+}
+
+// SetLimit bounds the transmitters checked, see StateLimit. Messages from
+// transmitters the limit excludes always pass.
+func (c *Continuity) SetLimit(limit StateLimit) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.transmitters.setLimit(limit, identity)
+}
+
+// Limit returns the limit set by SetLimit.
+func (c *Continuity) Limit() StateLimit {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.transmitters.limit
+}
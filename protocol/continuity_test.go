@@ -0,0 +1,85 @@
+package protocol
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestContinuity(t *testing.T) {
+	p := NewParser(14, 0)
+	c := p.NewContinuity(DefaultTolerance)
+
+	start := time.Unix(1500000000, 0)
+	dwell := DwellTime(2)
+
+	// Message from transmitter 2 sent after the given number of hops from the
+	// first, offset from its nominal time.
+	at := func(hops int, offset time.Duration) Message {
+		msg := newTestMessage(0x82, 0x05, 0x60, 0x02, 0xF1, 0x00)
+		msg.Time = start.Add(time.Duration(hops)*dwell + offset)
+		msg.ChannelIdx = p.hopPattern[(10+hops)%len(p.hopPattern)]
+		return msg
+	}
+
+	for _, tc := range []struct {
+		name string
+		msg  Message
+		err  error
+	}{
+		{"first", at(0, 0), nil},
+		{"next", at(1, 10*time.Millisecond), nil},
+		{"missed two", at(4, -20*time.Millisecond), nil},
+		{"too soon", at(4, 500*time.Millisecond), ErrOffSchedule},
+		{"early", at(5, -200*time.Millisecond), ErrOffSchedule},
+		{"resumed", at(6, 0), nil},
+	} {
+		if err := c.Check(tc.msg); !errors.Is(err, tc.err) {
+			t.Errorf("%s: got %v, want %v", tc.name, err, tc.err)
+		}
+	}
+
+	wrong := at(7, 0)
+	wrong.ChannelIdx = p.hopPattern[0]
+	if err := c.Check(wrong); !errors.Is(err, ErrWrongChannel) {
+		t.Errorf("wrong channel: got %v, want %v", err, ErrWrongChannel)
+	}
+
+	if got := c.Anomalies(2); got != 3 {
+		t.Errorf("anomalies: got %d, want 3", got)
+	}
+	if got := c.Anomalies(0); got != 0 {
+		t.Errorf("anomalies for unheard id: got %d, want 0", got)
+	}
+}
+
+// After repeated anomalies the reference moves to the latest message, in case
+// the reference itself was a false decode.
+func TestContinuityResync(t *testing.T) {
+	p := NewParser(14, 0)
+	c := p.NewContinuity(DefaultTolerance)
+
+	msg := newTestMessage(0x80, 0x05, 0x60, 0x02, 0xF1, 0x00)
+	msg.Time = time.Unix(1500000000, 0)
+
+	// A false decode establishes the reference, the real transmitter's
+	// messages are all off schedule relative to it.
+	c.Check(msg)
+	offset := time.Second
+	for n := 1; n <= anomalyLimit; n++ {
+		msg.Time = msg.Time.Add(DwellTime(0))
+		if err := c.Check(withOffset(msg, offset)); err == nil {
+			t.Fatalf("message %d: expected anomaly", n)
+		}
+	}
+
+	msg.Time = msg.Time.Add(DwellTime(0))
+	if err := c.Check(withOffset(msg, offset)); err != nil {
+		t.Fatalf("expected resync: %v", err)
+	}
+}
+
+func withOffset(msg Message, offset time.Duration) Message {
+	msg.Time = msg.Time.Add(offset)
+	return msg
+}
@@ -0,0 +1,142 @@
+package protocol
+
+import (
+	"math/bits"
+	"time"
+
+	"github.com/bemasher/rtldavis/crc"
+)
+
+// Davis messages carry no forward error correction, but a transmitter's
+// sensor value usually doesn't change between one pass through its message
+// rotation and the next. A corrector remembers the last valid message for
+// each header (sensor type and id) and uses it to repair packets that fail
+// their CRC:
+//
+//  1. If flipping exactly one bit makes the CRC pass, and the result's
+//     header has been seen before, that bit was wrong. CCITT-16 has a
+//     minimum distance of 4 over a message this short, so a single bit
+//     error can only be corrected one way. A flip that would change the
+//     transmitter id isn't trusted: a packet is never repaired into
+//     another transmitter's message.
+//  2. Otherwise, if the sensor bytes differ from the last valid message with
+//     the same header by at most maxBits bits, and substituting that
+//     message's bytes makes the CRC pass, the sensor value is assumed
+//     unchanged.
+//
+// Either way the result is a guess, corrected messages are flagged so they
+// can be treated as low confidence.
+type corrector struct {
+	crc.CRC
+	maxBits int
+
+	// Last valid message data by header byte.
+	refs *stateTable
+}
+
+// Bytes replaced from the reference message: the sensor value. Wind speed
+// and direction change between messages and the CRC is kept.
+var correctedBytes = []int{3, 4, 5}
+
+func newCorrector(c crc.CRC, maxBits int) *corrector {
+	return &corrector{
+		CRC:     c,
+		maxBits: maxBits,
+		refs:    newStateTable(),
+	}
+}
+
+// The transmitter id in a header byte.
+func headerID(header int) int { return header & 0xF }
+
+// observe records a valid message's data, received at now.
+func (c *corrector) observe(data []byte, now time.Time) {
+	ref := make([]byte, len(data))
+	copy(ref, data)
+	c.refs.put(int(data[0]), headerID(int(data[0])), now, ref)
+}
+
+// correct returns a repaired copy of data, which failed its CRC and was
+// received at now, or false if it couldn't be repaired. Data too short to
+// hold a message can't be.
+func (c *corrector) correct(data []byte, now time.Time) ([]byte, bool) {
+	if len(data) < MessageLength {
+		return nil, false
+	}
+
+	c.refs.expire(now)
+	fixed := make([]byte, len(data))
+
+	var found []byte
+	for bit := 0; bit < MessageLength*8; bit++ {
+		copy(fixed, data)
+		fixed[bit>>3] ^= 0x80 >> uint(bit&7)
+
+		if c.Checksum(fixed[:MessageLength]) != 0 {
+			continue
+		}
+		if headerID(int(fixed[0])) != headerID(int(data[0])) {
+			continue
+		}
+		if _, known := c.refs.get(int(fixed[0])); !known {
+			continue
+		}
+		if found != nil {
+			return nil, false
+		}
+		found = append([]byte(nil), fixed...)
+	}
+	if found != nil {
+		return found, true
+	}
+
+	v, known := c.refs.get(int(data[0]))
+	if !known {
+		return nil, false
+	}
+	ref := v.([]byte)
+	distance := 0
+	for _, idx := range correctedBytes {
+		distance += bits.OnesCount8(data[idx] ^ ref[idx])
+	}
+	if distance == 0 || distance > c.maxBits {
+		return nil, false
+	}
+
+	copy(fixed, data)
+	for _, idx := range correctedBytes {
+		fixed[idx] = ref[idx]
+	}
+	if c.Checksum(fixed[:MessageLength]) != 0 {
+		return nil, false
+	}
+	return fixed, true
+}
+
+// EnableCorrection makes the parser attempt to repair packets that fail their
+// CRC using the last valid message of the same type from the same
+// transmitter, see corrector. Repaired messages have Corrected set. A maxBits
+// of zero disables correction.
+func (p *Parser) EnableCorrection(maxBits int) {
+	if maxBits <= 0 {
+		p.corrector = nil
+		return
+	}
+	p.corrector = newCorrector(p.CRC, maxBits)
+	p.corrector.refs.setLimit(p.stateLimit, headerID)
+}
+
+// SetStateLimit bounds the transmitters whose messages are remembered for
+// correction, see StateLimit. Packets from other transmitters aren't
+// corrected.
+func (p *Parser) SetStateLimit(limit StateLimit) {
+	p.stateLimit = limit
+	if p.corrector != nil {
+		p.corrector.refs.setLimit(limit, headerID)
+	}
+}
+
+// StateLimit returns the limit set by SetStateLimit.
+func (p *Parser) StateLimit() StateLimit {
+	return p.stateLimit
+}
@@ -0,0 +1,117 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/bemasher/rtldavis/dsp"
+)
+
+// Packet as demodulated from the air: sync word followed by the message,
+// bit order reversed.
+func airPacket(msg Message) dsp.Packet {
+	frame := append([]byte{0xCB, 0x89}, msg.Data...)
+	for idx, b := range frame {
+		frame[idx] = SwapBitOrder(b)
+	}
+	return dsp.Packet{Data: frame}
+}
+
+func TestCorrectSingleBit(t *testing.T) {
+	p := NewParser(14, 0)
+	p.EnableCorrection(4)
+
+	valid := newTestMessage(0x80, 0x05, 0x60, 0x02, 0xF1, 0x00)
+	if msgs := p.Parse([]dsp.Packet{airPacket(valid)}); len(msgs) != 1 || msgs[0].Corrected {
+		t.Fatalf("valid message: got %+v", msgs)
+	}
+
+	// A later message with a different value and one bit flipped.
+	next := newTestMessage(0x80, 0x07, 0x61, 0x02, 0xF5, 0x00)
+	corrupt := newTestMessage(0x80, 0x07, 0x61, 0x02, 0xF5, 0x00)
+	corrupt.Data[4] ^= 0x08
+
+	msgs := p.Parse([]dsp.Packet{airPacket(corrupt)})
+	if len(msgs) != 1 || !msgs[0].Corrected {
+		t.Fatalf("expected corrected message, got %+v", msgs)
+	}
+	if string(msgs[0].Data) != string(next.Data) {
+		t.Fatalf("got %02X, want %02X", msgs[0].Data, next.Data)
+	}
+	if p.CRCFailures != 1 {
+		t.Fatalf("crc failures: got %d, want 1", p.CRCFailures)
+	}
+}
+
+func TestCorrectFromReference(t *testing.T) {
+	p := NewParser(14, 0)
+	p.EnableCorrection(4)
+
+	// Temperature hasn't changed since the last message, wind has.
+	p.Parse([]dsp.Packet{airPacket(newTestMessage(0x80, 0x05, 0x60, 0x2E, 0xE0, 0x00))})
+	want := newTestMessage(0x80, 0x09, 0x72, 0x2E, 0xE0, 0x00)
+
+	corrupt := newTestMessage(0x80, 0x09, 0x72, 0x2E, 0xE0, 0x00)
+	corrupt.Data[3] ^= 0x41
+
+	msgs := p.Parse([]dsp.Packet{airPacket(corrupt)})
+	if len(msgs) != 1 || !msgs[0].Corrected {
+		t.Fatalf("expected corrected message, got %+v", msgs)
+	}
+	if string(msgs[0].Data) != string(want.Data) {
+		t.Fatalf("got %02X, want %02X", msgs[0].Data, want.Data)
+	}
+}
+
+// A neighbour's message is never substituted, whether a packet's id bits
+// or those of its sensor value are corrupted.
+func TestCorrectOwnTransmitter(t *testing.T) {
+	p := NewParser(14, 0)
+	p.EnableCorrection(4)
+	p.Parse([]dsp.Packet{airPacket(newTestMessage(0x81, 0x05, 0x60, 0x2E, 0xE0, 0x00))})
+
+	// One bit away from the neighbour's message.
+	single := newTestMessage(0x81, 0x09, 0x72, 0x2E, 0xE0, 0x00)
+	single.Data[0] = 0x80
+
+	// Within maxBits of the neighbour's temperature.
+	multi := newTestMessage(0x81, 0x09, 0x72, 0x2E, 0xE0, 0x00)
+	multi.Data[0] = 0x80
+	multi.Data[3] ^= 0x41
+
+	for _, corrupt := range []Message{single, multi} {
+		if msgs := p.Parse([]dsp.Packet{airPacket(corrupt)}); len(msgs) != 0 {
+			t.Fatalf("%02X corrected into %+v", corrupt.Data, msgs)
+		}
+	}
+}
+
+func TestCorrectRejects(t *testing.T) {
+	corrupt := newTestMessage(0x80, 0x05, 0x60, 0x02, 0xF1, 0x00)
+	corrupt.Data[4] ^= 0x08
+
+	// Correction is opt-in.
+	p := NewParser(14, 0)
+	if msgs := p.Parse([]dsp.Packet{airPacket(corrupt)}); len(msgs) != 0 {
+		t.Fatalf("correction should be disabled by default: %+v", msgs)
+	}
+
+	// Without a reference for the header, even a single bit error isn't
+	// trusted.
+	p.EnableCorrection(4)
+	if msgs := p.Parse([]dsp.Packet{airPacket(corrupt)}); len(msgs) != 0 {
+		t.Fatalf("corrected without reference: %+v", msgs)
+	}
+
+	// Too many bits from the reference.
+	p.Parse([]dsp.Packet{airPacket(newTestMessage(0x80, 0x05, 0x60, 0x2E, 0xE0, 0x00))})
+	far := newTestMessage(0x80, 0x09, 0x72, 0x2E, 0xE0, 0x00)
+	far.Data[3] ^= 0xFF
+	if msgs := p.Parse([]dsp.Packet{airPacket(far)}); len(msgs) != 0 {
+		t.Fatalf("corrected beyond max bits: %+v", msgs)
+	}
+
+	// Too short to hold a message.
+	if _, ok := p.corrector.correct(corrupt.Data[:MessageLength-2], p.now()); ok {
+		t.Fatal("corrected a truncated message")
+	}
+}
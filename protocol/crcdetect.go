@@ -0,0 +1,114 @@
+package protocol
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/bemasher/rtldavis/crc"
+	"github.com/bemasher/rtldavis/dsp"
+)
+
+// CRCHypothesis is a candidate CRC configuration: CCITT-16 with an initial
+// value, covering the given number of bytes following the sync word and
+// stored in the two bytes after them.
+type CRCHypothesis struct {
+	Init    uint16
+	Covered int
+}
+
+func (h CRCHypothesis) String() string {
+	return fmt.Sprintf("init 0x%04X over %d bytes, crc in bytes %d-%d", h.Init, h.Covered, h.Covered, h.Covered+CRCLength-1)
+}
+
+// Builtin reports whether h is the configuration the parser uses.
+func (h CRCHypothesis) Builtin() bool {
+	return h.Init == 0 && h.Covered == PayloadLength
+}
+
+// CRCHypotheses are the configurations a CRCDetector tries by default: the
+// payload with and without the two bytes that follow it, with the initial
+// values CCITT-16 is commonly used with.
+var CRCHypotheses = []CRCHypothesis{
+	{0x0000, 6}, {0x0000, 8},
+	{0xFFFF, 6}, {0xFFFF, 8},
+	{0x1D0F, 6}, {0x1D0F, 8},
+}
+
+// CRCResult is the number of packets that passed a hypothesis' CRC.
+type CRCResult struct {
+	Hypothesis CRCHypothesis
+	Valid      int
+}
+
+// CRCDetector counts how many demodulated packets pass each of a set of CRC
+// hypotheses, to settle empirically which one a station uses. Every
+// hypothesis sees the same packets, so noise fails them all alike and the
+// right one stands out. The demodulator must frame enough bytes for the
+// longest hypothesis, see Parser.SetFrameLength.
+type CRCDetector struct {
+	// Distinct packets seen, whether or not any hypothesis passed.
+	Packets int
+
+	hypotheses []CRCHypothesis
+	crcs       []crc.CRC
+	valid      []int
+}
+
+// NewCRCDetector returns a detector trying the given hypotheses, or
+// CRCHypotheses if none are given.
+func NewCRCDetector(hypotheses ...CRCHypothesis) *CRCDetector {
+	if len(hypotheses) == 0 {
+		hypotheses = CRCHypotheses
+	}
+
+	d := &CRCDetector{
+		hypotheses: hypotheses,
+		crcs:       make([]crc.CRC, len(hypotheses)),
+		valid:      make([]int, len(hypotheses)),
+	}
+	for idx, h := range hypotheses {
+		d.crcs[idx] = crc.NewCRC("CCITT-16", h.Init, 0x1021, 0)
+	}
+	return d
+}
+
+// Add checks packets as returned by the demodulator against every
+// hypothesis. Duplicates within a call are counted once, as by Parse.
+// Hypotheses covering more bytes than a packet holds fail it.
+func (d *CRCDetector) Add(pkts []dsp.Packet) {
+	seen := make(map[string]bool)
+
+	for _, pkt := range pkts {
+		data := make([]byte, len(pkt.Data))
+		for idx, b := range pkt.Data {
+			data[idx] = SwapBitOrder(b)
+		}
+
+		if seen[string(data)] {
+			continue
+		}
+		seen[string(data)] = true
+		d.Packets++
+
+		data = data[SyncLength:]
+		for idx, h := range d.hypotheses {
+			end := h.Covered + CRCLength
+			if end <= len(data) && d.crcs[idx].Checksum(data[:end]) == 0 {
+				d.valid[idx]++
+			}
+		}
+	}
+}
+
+// Results returns the count for each hypothesis, most valid packets first.
+// Ties keep the order hypotheses were given in.
+func (d *CRCDetector) Results() []CRCResult {
+	results := make([]CRCResult, len(d.hypotheses))
+	for idx, h := range d.hypotheses {
+		results[idx] = CRCResult{h, d.valid[idx]}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Valid > results[j].Valid
+	})
+	return results
+}
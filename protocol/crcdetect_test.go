@@ -0,0 +1,76 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/bemasher/rtldavis/dsp"
+)
+
+func TestCRCDetector(t *testing.T) {
+	d := NewCRCDetector()
+
+	var pkts []dsp.Packet
+	for _, data := range [][]byte{
+		{0x80, 0x05, 0x60, 0x02, 0xF1, 0x00},
+		{0xA0, 0x04, 0x58, 0x2D, 0x31, 0x00},
+		{0x20, 0x03, 0x61, 0x01, 0x9C, 0x00},
+	} {
+		msg := newTestMessage(data...)
+		msg.Data = append(msg.Data, 0xFF, 0xFF)
+		pkts = append(pkts, airPacket(msg))
+	}
+
+	// Noise fails everything, and a duplicate is counted once.
+	noise := newTestMessage(0x80, 0x05, 0x60, 0x02, 0xF1, 0x00)
+	noise.Data = append(noise.Data, 0xFF, 0xFF)
+	noise.Data[4] ^= 0x40
+	pkts = append(pkts, airPacket(noise), pkts[0])
+
+	d.Add(pkts)
+	if d.Packets != 4 {
+		t.Fatalf("packets: got %d, want 4", d.Packets)
+	}
+
+	results := d.Results()
+	if best := results[0]; !best.Hypothesis.Builtin() || best.Valid != 3 {
+		t.Fatalf("best: got %+v, want the built-in configuration with 3 packets", best)
+	}
+	for _, r := range results[1:] {
+		if r.Valid != 0 {
+			t.Errorf("%s: got %d valid packets, want 0", r.Hypothesis, r.Valid)
+		}
+	}
+}
+
+func TestCRCDetectorCovered(t *testing.T) {
+	// A station whose CRC also covers the two bytes after the payload.
+	data := []byte{0x80, 0x05, 0x60, 0x02, 0xF1, 0x00, 0x12, 0x34}
+	msg := newTestMessage(data...)
+
+	d := NewCRCDetector(CRCHypothesis{0, 6}, CRCHypothesis{0, 8})
+	d.Add([]dsp.Packet{airPacket(msg)})
+
+	if results := d.Results(); results[0].Hypothesis.Covered != 8 || results[0].Valid != 1 || results[1].Valid != 0 {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+
+	// Too short for packets framed with only the built-in length.
+	d = NewCRCDetector(CRCHypothesis{0, 8})
+	d.Add([]dsp.Packet{airPacket(newTestMessage(data[:6]...))})
+	if results := d.Results(); results[0].Valid != 0 {
+		t.Fatalf("short packet passed: %+v", results)
+	}
+}
+
+func TestSetFrameLength(t *testing.T) {
+	p := NewParser(14, 0)
+	if err := p.SetFrameLength(TransmittedLength); err != nil {
+		t.Fatal(err)
+	}
+	if p.Cfg.PacketSymbols != TransmittedLength*8 || p.Cfg.BufferLength < p.Cfg.BlockSize+p.Cfg.PacketLength {
+		t.Fatalf("unexpected config: %+v", p.Cfg)
+	}
+	if err := p.SetFrameLength(1); err == nil {
+		t.Fatal("expected a frame shorter than the sync word to be rejected")
+	}
+}
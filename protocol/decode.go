@@ -0,0 +1,266 @@
+package protocol
+
+import "math"
+
+// Decoders for the sensor values carried by each message type, see
+// https://github.com/dekay/DavisRFM69/wiki/Message-Protocol
+//
+// Every message carries wind speed and direction in bytes 1 and 2, the upper
+// nibble of byte 0 identifies which sensor's value is in bytes 3 through 5.
+// Values are returned in the units the station transmits: degrees Fahrenheit,
+// miles per hour and inches. Messages whose data is shorter than
+// PayloadLength, which can only come from a file or a caller, not the
+// demodulator, have no values.
+
+// ParseWind returns the wind speed in mph and direction in degrees. Offset is
+// added to the direction, which is wrapped to [0, 360), to correct for how
+// the anemometer is mounted or for magnetic declination. The transmitted,
+// uncorrected direction is still available from Message.WindDirection.
+func ParseWind(m Message, offset float64) (speed, direction float64, ok bool) {
+	if !m.hasPayload() {
+		return 0, 0, false
+	}
+
+	// Direction has 9 bits of resolution, the least significant bit is bit 1
+	// of byte 4.
+	raw := int(m.Data[2])<<1 | int(m.Data[4]&2)>>1
+
+	direction = math.Mod(float64(raw)*360/512+offset, 360)
+	if direction < 0 {
+		direction += 360
+	}
+
+	return float64(m.Data[1]), direction, true
+}
+
+// ParseWindGust returns the highest wind speed in the last 10 minutes in mph.
+func ParseWindGust(m Message) (speed float64, ok bool) {
+	if m.Sensor != WindGustSpeed || !m.hasPayload() {
+		return 0, false
+	}
+	return float64(m.Data[3]), true
+}
+
+// ParseTemperature returns the outside temperature in degrees Fahrenheit.
+//
+// Bytes 3 and 4 hold a big-endian 16-bit two's complement integer, not offset
+// binary, whose upper 12 bits are the temperature in tenths of a degree and
+// whose lower 4 bits are zero, i.e. sixteenths of a tenth. Below 0°F the
+// value is negative: -0.1°F is 0xFFF0 and -40°F is 0xE700. The bytes must be
+// combined as a signed 16-bit value before scaling, widening them to a larger
+// unsigned type first turns every sub-zero reading into one above 400°F.
+func ParseTemperature(m Message) (temp float64, ok bool) {
+	if m.Sensor != Temperature || !m.hasPayload() {
+		return 0, false
+	}
+
+	raw := int16(uint16(m.Data[3])<<8 | uint16(m.Data[4]))
+	return float64(raw) / 160, true
+}
+
+// ParseHumidity returns the relative humidity in percent.
+func ParseHumidity(m Message) (humidity float64, ok bool) {
+	if m.Sensor != Humidity || !m.hasPayload() {
+		return 0, false
+	}
+
+	raw := int(m.Data[4]>>4)<<8 | int(m.Data[3])
+	return float64(raw) / 10, true
+}
+
+// ParseRain returns the rain bucket's tip counter, which wraps at 128.
+func ParseRain(m Message) (tips int, ok bool) {
+	if m.Sensor != Rain || !m.hasPayload() {
+		return 0, false
+	}
+	return int(m.Data[3] & 0x7F), true
+}
+
+// ParseRainRate returns the rain rate in inches per hour, derived from the
+// time between bucket tips.
+func ParseRainRate(m Message) (rate float64, ok bool) {
+	if m.Sensor != RainRate || !m.hasPayload() {
+		return 0, false
+	}
+
+	// No rain.
+	if m.Data[3] == 0xFF {
+		return 0, true
+	}
+
+	raw := int(m.Data[4]&0x30)<<4 | int(m.Data[3])
+	if raw == 0 {
+		return 0, true
+	}
+
+	// Seconds between tips, in 1/16ths during heavy rain.
+	seconds := float64(raw)
+	if m.Data[4]&0x40 != 0 {
+		seconds /= 16
+	}
+
+	// Each tip is 0.01".
+	return 36 / seconds, true
+}
+
+// ParseUV returns the UV index.
+func ParseUV(m Message) (index float64, ok bool) {
+	if m.Sensor != UVIndex || !m.hasPayload() || m.Data[3] == 0xFF {
+		return 0, false
+	}
+
+	raw := (int(m.Data[3])<<8 | int(m.Data[4])) >> 6
+	return float64(raw) / 50, true
+}
+
+// ParseSolarRadiation returns solar radiation in W/m².
+func ParseSolarRadiation(m Message) (radiation float64, ok bool) {
+	if m.Sensor != SolarRadiation || !m.hasPayload() || m.Data[3] == 0xFF {
+		return 0, false
+	}
+
+	raw := (int(m.Data[3])<<8 | int(m.Data[4])) >> 4
+	if raw <= 4 {
+		return 0, true
+	}
+	return float64(raw-4) / 2.27, true
+}
+
+// ParseSuperCap returns the transmitter's supercapacitor voltage.
+func ParseSuperCap(m Message) (voltage float64, ok bool) {
+	if m.Sensor != SuperCapVoltage || !m.hasPayload() {
+		return 0, false
+	}
+
+	raw := int(m.Data[3])<<2 | int(m.Data[4]&0xC0)>>6
+	return float64(raw) / 100, true
+}
+
+// ParseLight returns the raw reading of the transmitter's solar cell.
+func ParseLight(m Message) (light float64, ok bool) {
+	if m.Sensor != Light || !m.hasPayload() {
+		return 0, false
+	}
+
+	raw := int(m.Data[3])<<2 | int(m.Data[4]&0xC0)>>6
+	return float64(raw), true
+}
+
+// Reading holds the values decoded from a single message.
+type Reading struct {
+	Message
+
+	// Wind speed in mph and direction in degrees, present in every message.
+	Speed, Direction float64
+
+	// Value of the sensor identified by Message.Sensor. Valid is false if
+	// the sensor type is unknown or reports no sensor present.
+	Value float64
+	Valid bool
+
+	// OutOfRange is set if the decoder validates ranges and the wind speed
+	// or value is implausible. The values are kept so they can be audited.
+	OutOfRange bool
+
+	// Rainfall in inches from the transmitter since the decoder started,
+	// set on rain messages if the decoder accumulates rain.
+	RainTotal      float64
+	RainTotalValid bool
+
+	// Exponential moving average of the sensor's value, set if the decoder
+	// smooths values and the sensor is smoothed, see Smoother.
+	Smoothed      float64
+	SmoothedValid bool
+
+	// Direction the value has moved in over the decoder's trend interval,
+	// see Trends.
+	Trend Trend
+
+	// Trustworthiness of the reading from 0 to 1, see Confidence.
+	Confidence float64
+}
+
+// Decoder holds the calibration and validation applied when decoding
+// messages.
+type Decoder struct {
+	// Degrees added to the wind direction, see ParseWind.
+	DirectionOffset float64
+
+	// Ranges, if set, flags readings with implausible values. If Reject is
+	// also set, flagged readings have Valid cleared so their value isn't
+	// reported, the raw data is still available.
+	Ranges *Ranges
+	Reject bool
+
+	// Rain, if set, accumulates rain counters into each reading's
+	// RainTotal.
+	Rain *RainAccumulator
+
+	// Smooth, if set, averages slowly varying sensor values into each
+	// reading's Smoothed.
+	Smooth *Smoother
+
+	// Trends, if set, sets each reading's Trend.
+	Trends *Trends
+}
+
+// Decode decodes the wind and sensor values carried by a message without
+// calibration.
+func Decode(m Message) Reading {
+	return Decoder{}.Decode(m)
+}
+
+// Decode decodes the wind and sensor values carried by a message.
+func (d Decoder) Decode(m Message) (r Reading) {
+	r.Message = m
+	r.Speed, r.Direction, _ = ParseWind(m, d.DirectionOffset)
+
+	switch m.Sensor {
+	case SuperCapVoltage:
+		r.Value, r.Valid = ParseSuperCap(m)
+	case UVIndex:
+		r.Value, r.Valid = ParseUV(m)
+	case RainRate:
+		r.Value, r.Valid = ParseRainRate(m)
+	case SolarRadiation:
+		r.Value, r.Valid = ParseSolarRadiation(m)
+	case Light:
+		r.Value, r.Valid = ParseLight(m)
+	case Temperature:
+		r.Value, r.Valid = ParseTemperature(m)
+	case WindGustSpeed:
+		r.Value, r.Valid = ParseWindGust(m)
+	case Humidity:
+		r.Value, r.Valid = ParseHumidity(m)
+	case Rain:
+		var tips int
+		tips, r.Valid = ParseRain(m)
+		r.Value = float64(tips)
+	}
+
+	if d.Ranges != nil {
+		r.OutOfRange = !d.Ranges.Check(r)
+		if r.OutOfRange && d.Reject {
+			r.Valid = false
+		}
+	}
+
+	r.Confidence = Confidence(r)
+
+	if d.Rain != nil && r.Valid {
+		var tips int
+		if tips, r.RainTotalValid = d.Rain.Add(m); r.RainTotalValid {
+			r.RainTotal = float64(tips) * RainPerTip
+		}
+	}
+
+	if d.Smooth != nil {
+		r.Smoothed, r.SmoothedValid = d.Smooth.Add(r)
+	}
+
+	if d.Trends != nil {
+		r.Trend = d.Trends.Add(r)
+	}
+
+	return r
+}
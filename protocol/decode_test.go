@@ -0,0 +1,120 @@
+package protocol
+
+import (
+	"math"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	testCases := []struct {
+		name  string
+		data  []byte
+		value float64
+		valid bool
+	}{
+		{"temperature", []byte{0x80, 0, 0, 0x2E, 0xE0, 0}, 75, true},
+		{"humidity", []byte{0xA0, 0, 0, 0x1A, 0x20, 0}, 53.8, true},
+		{"gust", []byte{0x90, 0, 0, 0x17, 0, 0}, 23, true},
+		{"rain", []byte{0xE0, 0, 0, 0x85, 0, 0}, 5, true},
+		{"no rain rate", []byte{0x50, 0, 0, 0xFF, 0, 0}, 0, true},
+		{"rain rate", []byte{0x50, 0, 0, 0x48, 0, 0}, 0.5, true},
+		{"no uv sensor", []byte{0x40, 0, 0, 0xFF, 0, 0}, 0, false},
+		{"uv", []byte{0x40, 0, 0, 0x0C, 0x80, 0}, 1, true},
+		{"supercap", []byte{0x20, 0, 0, 0x50, 0x40, 0}, 3.21, true},
+		{"unknown", []byte{0x10, 0, 0, 0x12, 0x34, 0}, 0, false},
+	}
+
+	for _, tc := range testCases {
+		r := Decode(newTestMessage(tc.data...))
+		if r.Valid != tc.valid || math.Abs(r.Value-tc.value) > 1e-9 {
+			t.Errorf("%s: got %v (valid %t), want %v (valid %t)", tc.name, r.Value, r.Valid, tc.value, tc.valid)
+		}
+	}
+}
+
+func TestParseWind(t *testing.T) {
+	speed, dir, _ := ParseWind(newTestMessage(0x80, 12, 0x80, 0, 0x02, 0), 0)
+	if speed != 12 {
+		t.Errorf("speed: got %v, want 12", speed)
+	}
+	if want := float64(0x101) * 360 / 512; dir != want {
+		t.Errorf("direction: got %v, want %v", dir, want)
+	}
+}
+
+func TestParseWindOffset(t *testing.T) {
+	// Raw direction of 0x100, 180°.
+	msg := newTestMessage(0x80, 12, 0x80, 0, 0, 0)
+
+	for _, tc := range []struct {
+		offset, want float64
+	}{
+		{0, 180},
+		{10.5, 190.5},
+		{180, 0},
+		{270, 90},
+		{-200, 340},
+		{720, 180},
+	} {
+		if _, dir, _ := ParseWind(msg, tc.offset); dir != tc.want {
+			t.Errorf("offset %v: got %v, want %v", tc.offset, dir, tc.want)
+		}
+	}
+
+	r := Decoder{DirectionOffset: -190}.Decode(msg)
+	if r.Direction != 350 || r.WindDirection != 0x80 {
+		t.Errorf("decoder: got %v (raw %#x), want 350 (raw 0x80)", r.Direction, r.WindDirection)
+	}
+}
+
+func TestUnits(t *testing.T) {
+	if v, unit := Metric.Value(Temperature, 212); v != 100 || unit != "°C" {
+		t.Errorf("got %v %s, want 100 °C", v, unit)
+	}
+	if v, unit := Imperial.Value(Temperature, 212); v != 212 || unit != "°F" {
+		t.Errorf("got %v %s, want 212 °F", v, unit)
+	}
+	if v := WindMs(10); math.Abs(v-4.4704) > 1e-9 {
+		t.Errorf("WindMs: got %v", v)
+	}
+	if v, unit := Metric.Speed(10); math.Abs(v-16.09344) > 1e-9 || unit != "km/h" {
+		t.Errorf("got %v %s, want 16.09344 km/h", v, unit)
+	}
+	if v := RainMm(0.01); math.Abs(v-0.254) > 1e-9 {
+		t.Errorf("RainMm: got %v", v)
+	}
+}
+
+func TestParseTemperatureSigned(t *testing.T) {
+	for _, tc := range []struct {
+		hi, lo byte
+		want   float64
+	}{
+		{0x00, 0x00, 0},
+		{0x00, 0x10, 0.1},
+		{0xFF, 0xF0, -0.1},
+		{0x01, 0x40, 2},
+		{0xFE, 0xC0, -2},
+		{0xEC, 0x00, -32},
+		{0xE7, 0x00, -40},
+		{0xDA, 0x80, -60},
+		{0x7F, 0xF0, 204.7},
+		{0x80, 0x00, -204.8},
+	} {
+		got, ok := ParseTemperature(newTestMessage(0x80, 0, 0, tc.hi, tc.lo, 0))
+		if !ok || math.Abs(got-tc.want) > 1e-9 {
+			t.Errorf("%02X%02X: got %v, want %v", tc.hi, tc.lo, got, tc.want)
+		}
+	}
+
+	// -40 is where the scales meet.
+	if v, _ := Metric.Value(Temperature, -40); math.Abs(v+40) > 1e-9 {
+		t.Errorf("-40°F: got %v°C", v)
+	}
+
+	// Sub-zero readings are plausible.
+	r := Decoder{Ranges: DefaultRanges(), Reject: true}.Decode(newTestMessage(0x80, 0, 0, 0xE7, 0x00, 0))
+	if !r.Valid || r.OutOfRange || r.Value != -40 {
+		t.Errorf("-40°F rejected: %+v", r)
+	}
+}
@@ -0,0 +1,21 @@
+package protocol
+
+// MinSymbolLength is the fewest samples per symbol Downgrade goes to. Below
+// this the sample rate falls under the lowest an rtl-sdr supports.
+const MinSymbolLength = 12
+
+// Downgrade lowers the device's sample rate one step for a host that can't
+// keep up: first by disabling decimation, then by demodulating two fewer
+// samples per symbol. Returns false if the rate can't go any lower. The
+// device must be set to the new DeviceSampleRate and retuned.
+func (p *Parser) Downgrade() bool {
+	if p.Cfg.Decimation > 1 {
+		p.SetDecimation(1)
+		return true
+	}
+
+	if p.Cfg.SymbolLength-2 < MinSymbolLength {
+		return false
+	}
+	return p.SetSymbolLength(p.Cfg.SymbolLength-2) == nil
+}
@@ -0,0 +1,26 @@
+package protocol
+
+import "testing"
+
+func TestDowngrade(t *testing.T) {
+	p := NewParser(14, 0)
+	p.SetDecimation(4)
+	before := p.SetHop(0).ChannelFreq
+
+	var rates []int
+	for p.Downgrade() {
+		rates = append(rates, p.Cfg.DeviceSampleRate)
+	}
+	if len(rates) != 2 || rates[0] != 268800 || rates[1] != 230400 {
+		t.Fatalf("unexpected downgrades: %v", rates)
+	}
+	if p.Cfg.SymbolLength != MinSymbolLength || len(p.Demodulator.Quantized) != p.Cfg.BufferLength {
+		t.Fatalf("demodulator not rebuilt: %+v", p.Cfg)
+	}
+
+	// The signal stays a quarter of the sample rate below the tuned
+	// frequency.
+	if after := p.SetHop(0).ChannelFreq; before-after != (268800-230400)/4 {
+		t.Fatalf("channel moved from %d to %d", before, after)
+	}
+}
@@ -0,0 +1,34 @@
+package protocol
+
+// Framing of a Davis packet after its preamble, in bytes as transmitted
+// (each sent least significant bit first):
+//
+//	Offset  Size  Field
+//	     0     2  Sync word, 0xCB89
+//	     2     6  Payload: header, wind speed, wind direction, sensor value
+//	     8     2  CRC-CCITT over the payload, big-endian
+//
+// Message.Data starts after the sync word, so the payload is Data[:6] and
+// the CRC Data[6:8]. Stations transmit TrailerLength more bytes after the
+// CRC, which this framing doesn't include and nothing here depends on.
+const (
+	SyncLength    = 2
+	PayloadLength = 6
+	CRCOffset     = PayloadLength
+	CRCLength     = 2
+
+	// Bytes of a message checked by its CRC, including the CRC itself.
+	MessageLength = PayloadLength + CRCLength
+
+	// Bytes framed by the demodulator.
+	FrameLength = SyncLength + MessageLength
+
+	// Bytes following the CRC, and the length of everything transmitted
+	// after the preamble.
+	TrailerLength     = 2
+	TransmittedLength = FrameLength + TrailerLength
+)
+
+// SyncWord is the preamble the demodulator searches for, in order of
+// transmission.
+const SyncWord = "1100101110001001"
@@ -0,0 +1,49 @@
+package protocol
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/bemasher/rtldavis/dsp"
+)
+
+func TestFraming(t *testing.T) {
+	cfg := NewPacketConfig(14)
+	if cfg.PacketSymbols != FrameLength*8 {
+		t.Fatalf("packet symbols: got %d, want %d", cfg.PacketSymbols, FrameLength*8)
+	}
+	if cfg.PreambleSymbols != len(SyncWord) || len(SyncWord) != SyncLength*8 {
+		t.Fatalf("preamble symbols: got %d, want %d", cfg.PreambleSymbols, SyncLength*8)
+	}
+
+	// The demodulator frames exactly the sync word, payload and CRC.
+	msg := newTestMessage(0x80, 0x05, 0x60, 0x02, 0xF1, 0x00)
+	pkt := airPacket(msg)
+	if len(pkt.Data) != cfg.PacketSymbols/8 {
+		t.Fatalf("frame length: got %d, want %d", len(pkt.Data), cfg.PacketSymbols/8)
+	}
+
+	p := NewParser(14, 0)
+	crc := msg.Data[CRCOffset : CRCOffset+CRCLength]
+	if sum := p.Checksum(msg.Data[:PayloadLength]); sum != uint16(crc[0])<<8|uint16(crc[1]) {
+		t.Fatalf("crc: got %02X, want %04X", crc, sum)
+	}
+}
+
+func TestVerifyRange(t *testing.T) {
+	p := NewParser(14, 0)
+	msg := newTestMessage(0x80, 0x05, 0x60, 0x02, 0xF1, 0x00)
+
+	// Bytes trailing the CRC aren't covered by it.
+	long := append(append([]byte{}, msg.Data...), 0xAA, 0x55)
+	if err := p.Verify(long); err != nil {
+		t.Fatalf("trailing bytes: %v", err)
+	}
+	if msgs := p.Parse([]dsp.Packet{airPacket(NewMessage(dsp.Packet{Data: append([]byte{0, 0}, long...)}))}); len(msgs) != 1 {
+		t.Fatalf("trailing bytes: got %d messages, want 1", len(msgs))
+	}
+
+	if err := p.Verify(msg.Data[:MessageLength-1]); !errors.Is(err, ErrShortMessage) {
+		t.Fatalf("short message: got %v, want %v", err, ErrShortMessage)
+	}
+}
@@ -0,0 +1,121 @@
+//go:build go1.18
+// +build go1.18
+
+package protocol
+
+import (
+	"math"
+	"testing"
+
+	"github.com/bemasher/rtldavis/dsp"
+)
+
+// Seeds for the fuzzers: packets of each sensor type as framed by the
+// demodulator, sync word included, and some malformed ones.
+func fuzzSeeds(f *testing.F) {
+	for _, data := range [][]byte{
+		{0x80, 0x05, 0x60, 0x2E, 0xE0, 0x00},
+		{0xA0, 0x00, 0x00, 0x1A, 0x20, 0x00},
+		{0x50, 0x00, 0x00, 0x48, 0x40, 0x00},
+		{0x40, 0x00, 0x00, 0xFF, 0x00, 0x00},
+		{0xE0, 0x00, 0x00, 0x85, 0x00, 0x00},
+	} {
+		f.Add(newTestMessage(data...).Data)
+	}
+	f.Add([]byte{})
+	f.Add([]byte{0xCB})
+	f.Add([]byte{0xCB, 0x89, 0x80})
+	f.Add([]byte{0xCB, 0x89, 0x80, 0x05, 0x60, 0x2E})
+}
+
+// A message from arbitrary data following the sync word.
+func fuzzMessage(data []byte) Message {
+	return NewMessage(dsp.Packet{Data: append([]byte{0xCB, 0x89}, data...)})
+}
+
+func finite(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
+
+func FuzzParsers(f *testing.F) {
+	fuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		m := fuzzMessage(data)
+		short := len(data) < PayloadLength
+
+		if _, _, ok := ParseWind(m, 0); ok == short {
+			t.Fatalf("ParseWind: ok %t for %d bytes", ok, len(data))
+		}
+
+		for name, parse := range map[string]func(Message) (float64, bool){
+			"ParseWindGust":       ParseWindGust,
+			"ParseTemperature":    ParseTemperature,
+			"ParseHumidity":       ParseHumidity,
+			"ParseRainRate":       ParseRainRate,
+			"ParseUV":             ParseUV,
+			"ParseSolarRadiation": ParseSolarRadiation,
+			"ParseSuperCap":       ParseSuperCap,
+			"ParseLight":          ParseLight,
+		} {
+			v, ok := parse(m)
+			if ok && (short || !finite(v)) {
+				t.Fatalf("%s: got %v for % X", name, v, data)
+			}
+		}
+		if _, ok := ParseRain(m); ok && short {
+			t.Fatalf("ParseRain: ok for % X", data)
+		}
+
+		// Messages built by a caller may claim any sensor.
+		for s := Sensor(0); s < 16; s++ {
+			m := Message{Sensor: s}
+			m.Data = data
+			if r := Decode(m); r.Valid && (short || !finite(r.Value)) {
+				t.Fatalf("Decode %s: got %+v", s, r)
+			}
+		}
+	})
+}
+
+func FuzzDecode(f *testing.F) {
+	fuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		m := fuzzMessage(data)
+		m.CRCValid = true
+
+		d := Decoder{
+			Ranges: DefaultRanges(),
+			Rain:   NewRainAccumulator(),
+			Smooth: NewSmoother(DefaultTrendInterval),
+			Trends: NewTrends(DefaultTrendInterval),
+		}
+		r := d.Decode(m)
+		if r.Valid && (len(data) < PayloadLength || !finite(r.Value)) {
+			t.Fatalf("got %+v for % X", r, data)
+		}
+		if !finite(r.Speed) || !finite(r.Direction) || r.Confidence < 0 || r.Confidence > 1 {
+			t.Fatalf("got %+v for % X", r, data)
+		}
+	})
+}
+
+func FuzzParse(f *testing.F) {
+	fuzzSeeds(f)
+	p := NewParser(14, 0)
+	p.EnableCorrection(4)
+	p.IncludeInvalid = true
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		// Parse expects the packet's bits in air order.
+		frame := append([]byte{0xCB, 0x89}, data...)
+		for idx, b := range frame {
+			frame[idx] = SwapBitOrder(b)
+		}
+
+		for _, msg := range p.Parse([]dsp.Packet{{Data: frame}}) {
+			if (msg.CRCValid || msg.Corrected) && len(msg.Data) < MessageLength {
+				t.Fatalf("short message passed: %+v", msg)
+			}
+		}
+	})
+}
@@ -0,0 +1,182 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrHopMismatch is returned when received messages don't follow the
+// configured region's hop pattern.
+var ErrHopMismatch = errors.New("protocol: observed channels don't match the hop pattern")
+
+const (
+	// Consecutive message pairs needed before judging a hop pattern.
+	MinHopPairs = 8
+
+	// Fraction of pairs a pattern must predict to be considered right. A
+	// right pattern predicts nearly all of them, a wrong one about as many
+	// as chance.
+	hopMatchFraction = 0.5
+)
+
+// HopVerifier checks that the channels messages are received on follow the
+// configured region's hop pattern. For each pair of messages from a
+// transmitter it works out how many hops apart they were sent from the time
+// between them, and whether the pattern puts the second message on the
+// channel it was received on. Every known region sharing the band is scored
+// the same way, by channel frequency, so a mismatch can suggest a better one.
+//
+// A wrong hop table still catches the odd message on channels it happens to
+// share with the right one, which shows up only as a poor capture rate.
+//
+// HopVerifier is safe for concurrent use.
+type HopVerifier struct {
+	region     Region
+	candidates []hopCandidate
+
+	mu    sync.Mutex
+	last  map[byte]Message
+	pairs int
+}
+
+type hopCandidate struct {
+	region Region
+
+	// Pattern index of each channel.
+	patternIdx map[int]int
+	matches    int
+}
+
+// NewHopVerifier returns a verifier for region, scoring it against Regions.
+func NewHopVerifier(region Region) *HopVerifier {
+	v := &HopVerifier{region: region, last: make(map[byte]Message)}
+
+	v.addCandidate(region)
+	for _, r := range Regions {
+		if r.Name != region.Name {
+			v.addCandidate(r)
+		}
+	}
+	return v
+}
+
+func (v *HopVerifier) addCandidate(region Region) {
+	c := hopCandidate{region: region, patternIdx: make(map[int]int)}
+	for idx, channel := range region.HopPattern {
+		c.patternIdx[channel] = idx
+	}
+	v.candidates = append(v.candidates, c)
+}
+
+// Add scores a message, which must have its Time and ChannelFreq set,
+// against the previous valid message from its transmitter. Messages failing
+// their CRC are ignored.
+func (v *HopVerifier) Add(msg Message) {
+	if !msg.CRCValid && !msg.Corrected {
+		return
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	last, exists := v.last[msg.ID]
+	v.last[msg.ID] = msg
+	if !exists {
+		return
+	}
+
+	dwell := DwellTime(int(msg.ID))
+	gap := msg.Time.Sub(last.Time)
+	hops := int((gap + dwell/2) / dwell)
+
+	// Timing errors accumulate, only judge gaps of up to a full rotation of
+	// the pattern and that land close to a whole number of hops.
+	offset := gap - time.Duration(hops)*dwell
+	if hops <= 0 || hops > len(v.region.HopPattern) || offset < -DefaultTolerance || offset > DefaultTolerance {
+		return
+	}
+
+	v.pairs++
+	for idx := range v.candidates {
+		c := &v.candidates[idx]
+		if c.predicts(last.ChannelFreq, msg.ChannelFreq, hops) {
+			c.matches++
+		}
+	}
+}
+
+// predicts reports whether the region's pattern puts a message hops after
+// one on lastFreq on freq.
+func (c *hopCandidate) predicts(lastFreq, freq, hops int) bool {
+	lastChannel, ok := c.region.channel(lastFreq)
+	if !ok {
+		return false
+	}
+	channel, ok := c.region.channel(freq)
+	if !ok {
+		return false
+	}
+
+	lastIdx, ok := c.patternIdx[lastChannel]
+	if !ok {
+		return false
+	}
+	pattern := c.region.HopPattern
+	return pattern[(lastIdx+hops)%len(pattern)] == channel
+}
+
+// channel returns the index of the region's channel closest to freq, if
+// freq is within half a channel spacing of it.
+func (r Region) channel(freq int) (int, bool) {
+	if len(r.Channels) < 2 {
+		return 0, false
+	}
+	tolerance := (r.Channels[1] - r.Channels[0]) / 2
+
+	for idx, ch := range r.Channels {
+		if d := freq - ch; d > -tolerance && d < tolerance {
+			return idx, true
+		}
+	}
+	return 0, false
+}
+
+// Pairs returns the number of message pairs scored.
+func (v *HopVerifier) Pairs() int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.pairs
+}
+
+// Check returns an error wrapping ErrHopMismatch if, after at least
+// MinHopPairs pairs, the configured region's pattern predicts too few of
+// them. The error names any other region that predicts them better.
+func (v *HopVerifier) Check() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.pairs < MinHopPairs {
+		return nil
+	}
+	configured := v.candidates[0]
+	if float64(configured.matches) >= hopMatchFraction*float64(v.pairs) {
+		return nil
+	}
+
+	err := fmt.Errorf("%w: region %s predicts the channel of %d of %d consecutive messages, the hop table looks wrong for this station",
+		ErrHopMismatch, v.region, configured.matches, v.pairs,
+	)
+
+	best := configured
+	for _, c := range v.candidates[1:] {
+		if c.matches > best.matches {
+			best = c
+		}
+	}
+	if best.region.Name != v.region.Name && float64(best.matches) >= hopMatchFraction*float64(v.pairs) {
+		err = fmt.Errorf("%w, region %s predicts %d", err, best.region, best.matches)
+	}
+	return err
+}
@@ -0,0 +1,81 @@
+package protocol
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Messages from transmitter 3 following region's hop pattern, skipping some.
+func hoppingMessages(region Region, n int) (msgs []Message) {
+	start := time.Unix(1500000000, 0)
+	dwell := DwellTime(3)
+
+	hop := 0
+	for idx := 0; idx < n; idx++ {
+		hop += 1 + idx%3
+		msg := newTestMessage(0x83, 0x05, 0x60, 0x02, 0xF1, 0x00)
+		msg.CRCValid = true
+		msg.Time = start.Add(time.Duration(hop)*dwell + time.Duration(idx%5)*time.Millisecond)
+		msg.ChannelIdx = region.HopPattern[hop%len(region.HopPattern)]
+		msg.ChannelFreq = region.Channels[msg.ChannelIdx]
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
+
+func TestHopVerifier(t *testing.T) {
+	v := NewHopVerifier(US)
+	for _, msg := range hoppingMessages(US, 20) {
+		v.Add(msg)
+	}
+	if v.Pairs() != 19 {
+		t.Fatalf("pairs: got %d, want 19", v.Pairs())
+	}
+	if err := v.Check(); err != nil {
+		t.Fatalf("right pattern: %v", err)
+	}
+
+	// A made up region with the same channels but the pattern reversed.
+	wrong := Region{Name: "reversed", Channels: US.Channels}
+	for idx := len(US.HopPattern) - 1; idx >= 0; idx-- {
+		wrong.HopPattern = append(wrong.HopPattern, US.HopPattern[idx])
+	}
+
+	v = NewHopVerifier(wrong)
+	msgs := hoppingMessages(US, MinHopPairs)
+	for _, msg := range msgs {
+		v.Add(msg)
+	}
+	if err := v.Check(); err != nil {
+		t.Fatalf("too few pairs to judge: %v", err)
+	}
+
+	v.Add(hoppingMessages(US, MinHopPairs+1)[MinHopPairs])
+	err := v.Check()
+	if !errors.Is(err, ErrHopMismatch) {
+		t.Fatalf("wrong pattern: got %v, want %v", err, ErrHopMismatch)
+	}
+	if !strings.Contains(err.Error(), "region us predicts") {
+		t.Fatalf("expected the right region to be suggested: %v", err)
+	}
+}
+
+func TestHopVerifierIgnored(t *testing.T) {
+	v := NewHopVerifier(US)
+	msgs := hoppingMessages(US, 3)
+
+	// Invalid messages, and pairs too far off a whole number of hops.
+	invalid := msgs[1]
+	invalid.CRCValid = false
+	late := msgs[2]
+	late.Time = late.Time.Add(DwellTime(3) / 4)
+
+	for _, msg := range []Message{msgs[0], invalid, late} {
+		v.Add(msg)
+	}
+	if v.Pairs() != 0 {
+		t.Fatalf("pairs: got %d, want 0", v.Pairs())
+	}
+}
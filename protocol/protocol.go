@@ -18,11 +18,13 @@
 package protocol
 
 import (
+	"errors"
 	"fmt"
 	"math"
 	"math/rand"
 	"time"
 
+	"github.com/bemasher/rtldavis/clock"
 	"github.com/bemasher/rtldavis/crc"
 	"github.com/bemasher/rtldavis/dsp"
 )
@@ -30,10 +32,10 @@ import (
 func NewPacketConfig(symbolLength int) (cfg dsp.PacketConfig) {
 	return dsp.NewPacketConfig(
 		19200,
-		14,
-		16,
-		80,
-		"1100101110001001",
+		symbolLength,
+		len(SyncWord),
+		FrameLength*8,
+		SyncWord,
 	)
 }
 
@@ -46,6 +48,24 @@ type Parser struct {
 	ID        int
 	DwellTime time.Duration
 
+	// Number of packets whose checksum failed, including those corrected.
+	CRCFailures int
+
+	// Repairs packets failing their checksum if enabled.
+	corrector  *corrector
+	stateLimit StateLimit
+
+	// Clock times the messages remembered for correction, see
+	// SetStateLimit. Defaults to the system clock.
+	Clock clock.Clock
+
+	// IncludeInvalid returns packets failing their checksum that couldn't be
+	// corrected from Parse, with CRCValid unset, for debugging. Their
+	// frequency error isn't measured or used.
+	IncludeInvalid bool
+
+	Region Region
+
 	channelCount int
 	channels     []int
 
@@ -61,33 +81,72 @@ func NewParser(symbolLength, id int) (p Parser) {
 	p.Demodulator = dsp.NewDemodulator(&p.Cfg)
 	p.CRC = crc.NewCRC("CCITT-16", 0, 0x1021, 0)
 
-	p.channels = []int{
-		902355835, 902857585, 903359336, 903861086, 904362837, 904864587,
-		905366338, 905868088, 906369839, 906871589, 907373340, 907875090,
-		908376841, 908878591, 909380342, 909882092, 910383843, 910885593,
-		911387344, 911889094, 912390845, 912892595, 913394346, 913896096,
-		914397847, 914899597, 915401347, 915903098, 916404848, 916906599,
-		917408349, 917910100, 918411850, 918913601, 919415351, 919917102,
-		920418852, 920920603, 921422353, 921924104, 922425854, 922927605,
-		923429355, 923931106, 924432856, 924934607, 925436357, 925938108,
-		926439858, 926941609, 927443359,
-	}
+	p.SetRegion(US)
+
+	p.ID = id
+	p.DwellTime = DwellTime(p.ID)
+
+	return
+}
+
+// SetRegion switches the parser to a region's channels and hop pattern. Any
+// frequency error measured so far is discarded.
+func (p *Parser) SetRegion(region Region) {
+	p.Region = region
+	p.channels = region.Channels
 	p.channelCount = len(p.channels)
+	p.hopPattern = region.HopPattern
 
 	p.hopIdx = rand.Intn(p.channelCount)
-	p.hopPattern = []int{
-		0, 19, 41, 25, 8, 47, 32, 13, 36, 22, 3, 29, 44, 16, 5, 27, 38, 10,
-		49, 21, 2, 30, 42, 14, 48, 7, 24, 34, 45, 1, 17, 39, 26, 9, 31, 50,
-		37, 12, 20, 33, 4, 43, 28, 15, 35, 6, 40, 11, 23, 46, 18,
-	}
-
+	p.currentFreqErr = 0
 	p.channelFreqErr = make(map[int]int)
+}
 
-	p.ID = id
-	p.DwellTime = 2562500 * time.Microsecond
-	p.DwellTime += time.Duration(p.ID) * 62500 * time.Microsecond
+// SetDecimation configures the parser for a device sampling at factor times
+// the demodulator's sample rate and rebuilds the demodulator to match.
+func (p *Parser) SetDecimation(factor int) {
+	p.Cfg.SetDecimation(factor)
+	p.Demodulator = dsp.NewDemodulator(&p.Cfg)
+}
 
-	return
+// SetSpectrumInverted sets whether samples are conjugated before
+// demodulation, see dsp.PacketConfig.SpectrumInverted, and rebuilds the
+// demodulator to match.
+func (p *Parser) SetSpectrumInverted(inverted bool) {
+	p.Cfg.SpectrumInverted = inverted
+	p.Demodulator = dsp.NewDemodulator(&p.Cfg)
+}
+
+// SetFrameLength sets the number of bytes framed after each preamble,
+// including the sync word, and rebuilds the demodulator to match. Only
+// diagnostics need more than FrameLength, see CRCDetector.
+func (p *Parser) SetFrameLength(length int) error {
+	if err := p.Cfg.SetPacketSymbols(length * 8); err != nil {
+		return err
+	}
+	p.Demodulator = dsp.NewDemodulator(&p.Cfg)
+	return nil
+}
+
+// SetSymbolLength sets the number of samples per symbol and rebuilds the
+// demodulator to match, see dsp.PacketConfig.SetSymbolLength. Hops account
+// for the change in sample rate.
+func (p *Parser) SetSymbolLength(symbolLength int) error {
+	if err := p.Cfg.SetSymbolLength(symbolLength); err != nil {
+		return err
+	}
+	p.Demodulator = dsp.NewDemodulator(&p.Cfg)
+	return nil
+}
+
+// SetBlockSize sets the number of samples demodulated at a time and rebuilds
+// the demodulator to match, see dsp.PacketConfig.SetBlockSize.
+func (p *Parser) SetBlockSize(blockSize int) error {
+	if err := p.Cfg.SetBlockSize(blockSize); err != nil {
+		return err
+	}
+	p.Demodulator = dsp.NewDemodulator(&p.Cfg)
+	return nil
 }
 
 type Hop struct {
@@ -104,7 +163,7 @@ func (h Hop) String() string {
 
 func (p *Parser) hop() (h Hop) {
 	h.ChannelIdx = p.hopPattern[p.hopIdx]
-	h.ChannelFreq = p.channels[h.ChannelIdx]
+	h.ChannelFreq = p.channelFreq(h.ChannelIdx)
 
 	// If this channel has already been visited, use frequency error from last
 	// visit. Otherwise use frequency error from previous channel.
@@ -116,6 +175,16 @@ func (p *Parser) hop() (h Hop) {
 	return h
 }
 
+// Sample rate the channel table is tuned for.
+const channelSampleRate = 19200 * 14
+
+// Frequency to tune to for the given channel. The demodulator expects the
+// signal a quarter of its sample rate below the tuned frequency, so the
+// table's frequencies move with the sample rate.
+func (p *Parser) channelFreq(channelIdx int) int {
+	return p.channels[channelIdx] + (p.Cfg.SampleRate-channelSampleRate)/4
+}
+
 // Increment the pattern index and return the new channel's parameters.
 func (p *Parser) NextHop() Hop {
 	p.hopIdx = (p.hopIdx + 1) % p.channelCount
@@ -128,6 +197,48 @@ func (p *Parser) RandHop() Hop {
 	return p.hop()
 }
 
+// Set the pattern index and return the new channel's parameters.
+func (p *Parser) SetHop(hopIdx int) Hop {
+	p.hopIdx = hopIdx % p.channelCount
+	return p.hop()
+}
+
+// HopIdx returns the current index into the hop pattern.
+func (p *Parser) HopIdx() int {
+	return p.hopIdx
+}
+
+// ChannelCount returns the length of the hop pattern.
+func (p *Parser) ChannelCount() int {
+	return p.channelCount
+}
+
+func (p *Parser) now() time.Time {
+	if p.Clock == nil {
+		return clock.Real.Now()
+	}
+	return p.Clock.Now()
+}
+
+// ErrCRCFailed is returned for messages whose checksum doesn't match.
+var ErrCRCFailed = errors.New("protocol: crc check failed")
+
+// ErrShortMessage is returned for messages too short to hold a payload and
+// its CRC.
+var ErrShortMessage = errors.New("protocol: message too short")
+
+// Verify checks a message's payload against its CRC, see MessageLength.
+// Anything following the CRC is ignored.
+func (p *Parser) Verify(data []byte) error {
+	if len(data) < MessageLength {
+		return fmt.Errorf("%w: %d bytes", ErrShortMessage, len(data))
+	}
+	if sum := p.Checksum(data[:MessageLength]); sum != 0 {
+		return fmt.Errorf("%w: residue %04X", ErrCRCFailed, sum)
+	}
+	return nil
+}
+
 // Given a list of packets, check them for validity and ignore duplicates,
 // return a list of parsed messages.
 func (p *Parser) Parse(pkts []dsp.Packet) (msgs []Message) {
@@ -146,9 +257,40 @@ func (p *Parser) Parse(pkts []dsp.Packet) (msgs []Message) {
 		}
 		seen[s] = true
 
-		// If the checksum fails, bail.
-		if p.Checksum(pkt.Data[2:]) != 0 {
-			continue
+		// If the checksum fails, try to correct it or bail. Packets too
+		// short to hold a message can't be verified or corrected.
+		short := len(pkt.Data) < SyncLength+MessageLength
+		valid := !short && p.Verify(pkt.Data[SyncLength:]) == nil
+		corrected := false
+		if !valid {
+			p.CRCFailures++
+
+			var fixed []byte
+			ok := false
+			if p.corrector != nil && !short {
+				fixed, ok = p.corrector.correct(pkt.Data[SyncLength:], p.now())
+			}
+
+			if !ok {
+				if p.IncludeInvalid {
+					msg := NewMessage(pkt)
+					msg.ChannelIdx = p.hopPattern[p.hopIdx]
+					msg.ChannelFreq = p.channelFreq(msg.ChannelIdx)
+					msg.Region = p.Region.Name
+					msgs = append(msgs, msg)
+				}
+				continue
+			}
+			copy(pkt.Data[SyncLength:], fixed)
+
+			s = string(pkt.Data)
+			if seen[s] {
+				continue
+			}
+			seen[s] = true
+			corrected = true
+		} else if p.corrector != nil {
+			p.corrector.observe(pkt.Data[SyncLength:], p.now())
 		}
 
 		// Look at the packet's tail to determine frequency error between
@@ -173,7 +315,14 @@ func (p *Parser) Parse(pkts []dsp.Packet) (msgs []Message) {
 		// Update the current frequency error.
 		p.currentFreqErr += freqError
 
-		msgs = append(msgs, NewMessage(pkt))
+		msg := NewMessage(pkt)
+		msg.ChannelIdx = p.hopPattern[p.hopIdx]
+		msg.ChannelFreq = p.channelFreq(msg.ChannelIdx)
+		msg.FreqError = freqError
+		msg.Region = p.Region.Name
+		msg.CRCValid = valid
+		msg.Corrected = corrected
+		msgs = append(msgs, msg)
 	}
 
 	return
@@ -182,17 +331,54 @@ func (p *Parser) Parse(pkts []dsp.Packet) (msgs []Message) {
 type Message struct {
 	dsp.Packet
 
+	// Time the message was received, set by the caller of Parse.
+	Time time.Time
+
+	// Index and center frequency of the hop channel the message was received
+	// on. ChannelIdx is -1 if unknown.
+	ChannelIdx  int
+	ChannelFreq int
+
+	// Name of the region the message was received in, and of the source it
+	// was received from if the caller sets one.
+	Region string
+	Source string
+
 	ID     byte
 	Sensor Sensor
 
 	WindSpeed     byte
 	WindDirection byte
+
+	// Frequency error measured from the packet's tail in Hz.
+	FreqError int
+
+	// CRCValid is set if the packet passed its CRC as received. Only unset
+	// for corrected messages, or for every failing packet if the parser
+	// includes invalid packets.
+	CRCValid bool
+
+	// Corrected is set if the message failed its CRC and was repaired, its
+	// contents are a best guess.
+	Corrected bool
 }
 
+// NewMessage builds a message from a packet starting with the sync word. A
+// packet too short to hold a payload gives a message with whatever data
+// follows the sync word and no header fields, it fails Verify and no sensor
+// values are parsed from it.
 func NewMessage(pkt dsp.Packet) (m Message) {
 	m.Idx = pkt.Idx
-	m.Data = make([]byte, len(pkt.Data)-2)
-	copy(m.Data, pkt.Data[2:])
+	m.Eye = pkt.Eye
+	if len(pkt.Data) > SyncLength {
+		m.Data = make([]byte, len(pkt.Data)-SyncLength)
+		copy(m.Data, pkt.Data[SyncLength:])
+	}
+
+	m.ChannelIdx = -1
+	if !m.hasPayload() {
+		return m
+	}
 
 	m.ID = m.Data[0] & 0xF
 	m.Sensor = Sensor(m.Data[0] >> 4)
@@ -201,8 +387,13 @@ func NewMessage(pkt dsp.Packet) (m Message) {
 	return m
 }
 
+// hasPayload reports whether m's data is long enough to parse values from.
+func (m Message) hasPayload() bool {
+	return len(m.Data) >= PayloadLength
+}
+
 func (m Message) String() string {
-	return fmt.Sprintf("{ID:%d Sensor:%s WindSpeed:%d WindDir:%d}", m.ID, m.Sensor, m.WindSpeed, m.WindDirection)
+	return fmt.Sprintf("{ID:%d Sensor:%s Channel:%d WindSpeed:%d WindDir:%d}", m.ID, m.Sensor, m.ChannelIdx, m.WindSpeed, m.WindDirection)
 }
 
 type Sensor byte
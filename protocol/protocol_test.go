@@ -0,0 +1,50 @@
+package protocol
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/bemasher/rtldavis/dsp"
+)
+
+func TestVerify(t *testing.T) {
+	p := NewParser(14, 0)
+	msg := newTestMessage(0x80, 0x05, 0x60, 0x02, 0xF1, 0x00)
+
+	if err := p.Verify(msg.Data); err != nil {
+		t.Fatalf("valid message: %v", err)
+	}
+
+	msg.Data[3] ^= 0x10
+	if err := p.Verify(msg.Data); !errors.Is(err, ErrCRCFailed) {
+		t.Fatalf("corrupt message: got %v, want %v", err, ErrCRCFailed)
+	}
+}
+
+func TestParseIncludeInvalid(t *testing.T) {
+	valid := newTestMessage(0x80, 0x05, 0x60, 0x02, 0xF1, 0x00)
+	corrupt := newTestMessage(0x80, 0x05, 0x60, 0x02, 0xF1, 0x00)
+	corrupt.Data[3] ^= 0x10
+
+	p := NewParser(14, 0)
+	pkts := func() []dsp.Packet {
+		return []dsp.Packet{airPacket(valid), airPacket(corrupt)}
+	}
+
+	msgs := p.Parse(pkts())
+	if len(msgs) != 1 || !msgs[0].CRCValid {
+		t.Fatalf("expected only the valid message, got %+v", msgs)
+	}
+
+	p.IncludeInvalid = true
+	msgs = p.Parse(pkts())
+	if len(msgs) != 2 || !msgs[0].CRCValid || msgs[1].CRCValid || msgs[1].Corrected {
+		t.Fatalf("expected valid and invalid messages, got %+v", msgs)
+	}
+	if string(msgs[1].Data) != string(corrupt.Data) || msgs[1].ChannelIdx != msgs[0].ChannelIdx {
+		t.Fatalf("invalid message not as received: %+v", msgs[1])
+	}
+	if p.CRCFailures != 2 {
+		t.Fatalf("crc failures: got %d, want 2", p.CRCFailures)
+	}
+}
@@ -0,0 +1,81 @@
+package protocol
+
+import "sync"
+
+// RainPerTip is the rainfall in inches each tip of the bucket measures.
+const RainPerTip = 0.01
+
+// RainAccumulator totals each transmitter's rainfall from its rain counter,
+// which wraps at 128 tips. The first counter heard from a transmitter is
+// only a baseline, so totals count from when the accumulator started
+// listening.
+//
+// RainAccumulator is safe for concurrent use.
+type RainAccumulator struct {
+	mu     sync.Mutex
+	gauges *stateTable
+}
+
+type rainGauge struct {
+	counter int
+	tips    int
+}
+
+func NewRainAccumulator() *RainAccumulator {
+	return &RainAccumulator{gauges: newStateTable()}
+}
+
+// SetLimit bounds the transmitters rain is accumulated for, see StateLimit.
+// A transmitter whose state expires starts over from a new baseline.
+func (a *RainAccumulator) SetLimit(limit StateLimit) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.gauges.setLimit(limit, identity)
+}
+
+// Add accumulates a message's rain counter and returns its transmitter's
+// total tips since the start. Ok is false, and nothing is accumulated, for
+// messages that aren't rain messages, failed their CRC or are from a
+// transmitter the limit excludes.
+func (a *RainAccumulator) Add(m Message) (tips int, ok bool) {
+	counter, ok := ParseRain(m)
+	if !ok || (!m.CRCValid && !m.Corrected) {
+		return 0, false
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	id := int(m.ID)
+	if !a.gauges.limit.Tracks(id) {
+		return 0, false
+	}
+
+	v, exists := a.gauges.lookup(id, m.Time)
+	if !exists {
+		a.gauges.put(id, id, m.Time, &rainGauge{counter: counter})
+		return 0, true
+	}
+	g := v.(*rainGauge)
+	a.gauges.put(id, id, m.Time, g)
+
+	g.tips += (counter - g.counter + 128) % 128
+	g.counter = counter
+	return g.tips, true
+}
+
+// Tips returns the total tips counted from a transmitter.
+func (a *RainAccumulator) Tips(id byte) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if g, exists := a.gauges.get(int(id)); exists {
+		return g.(*rainGauge).tips
+	}
+	return 0
+}
+
+// Rainfall returns the total rainfall in inches from a transmitter.
+func (a *RainAccumulator) Rainfall(id byte) float64 {
+	return float64(a.Tips(id)) * RainPerTip
+}
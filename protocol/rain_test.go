@@ -0,0 +1,55 @@
+package protocol
+
+import (
+	"math"
+	"testing"
+)
+
+func rainMessage(id, counter byte) Message {
+	m := newTestMessage(0xE0|id, 0, 0, counter, 0, 0)
+	m.CRCValid = true
+	return m
+}
+
+func TestRainAccumulator(t *testing.T) {
+	a := NewRainAccumulator()
+
+	// The first counter is a baseline, however high.
+	if tips, ok := a.Add(rainMessage(1, 120)); !ok || tips != 0 {
+		t.Fatalf("baseline: got %d, %t", tips, ok)
+	}
+
+	for _, tc := range []struct {
+		counter byte
+		tips    int
+	}{
+		{122, 2},
+		{122, 2},
+		// Wraps at 128, the top bit isn't part of the counter.
+		{0x80 | 3, 11},
+		{10, 18},
+	} {
+		if tips, _ := a.Add(rainMessage(1, tc.counter)); tips != tc.tips {
+			t.Fatalf("counter %d: got %d tips, want %d", tc.counter, tips, tc.tips)
+		}
+	}
+
+	if got := a.Rainfall(1); math.Abs(got-0.18) > 1e-9 {
+		t.Fatalf("rainfall: got %v, want 0.18", got)
+	}
+
+	// Transmitters are tracked separately.
+	if tips, _ := a.Add(rainMessage(2, 50)); tips != 0 || a.Tips(2) != 0 {
+		t.Fatalf("second transmitter: got %d tips", tips)
+	}
+
+	// Other messages and failed packets aren't counted.
+	invalid := rainMessage(1, 20)
+	invalid.CRCValid = false
+	if _, ok := a.Add(invalid); ok || a.Tips(1) != 18 {
+		t.Fatal("counted an invalid message")
+	}
+	if _, ok := a.Add(newTestMessage(0x81, 0, 0, 0x2E, 0xE0, 0)); ok {
+		t.Fatal("counted a temperature message")
+	}
+}
@@ -0,0 +1,145 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/bemasher/rtldavis/dsp"
+)
+
+// Binary log format. Every record is a fixed-size, big-endian structure so a
+// log can be scanned without any framing beyond the per-record header:
+//
+//	Offset  Size  Field
+//	     0     2  Magic (0xDA71)
+//	     2     1  Version
+//	     3     1  Channel index, 0xFF if unknown
+//	     4     8  Timestamp, nanoseconds since the Unix epoch
+//	    12     4  Frequency error in Hz, signed
+//	    16    10  Payload as transmitted, following the sync word
+//
+// The payload holds Davis' full 10-byte message: 6 bytes of data, the 2 byte
+// CRC and the 2 trailing bytes. Bytes the demodulator doesn't frame are
+// stored as zero.
+const (
+	RecordMagic      = 0xDA71
+	RecordVersion    = 1
+	RecordDataLength = 10
+	RecordLength     = 16 + RecordDataLength
+
+	noChannel = 0xFF
+)
+
+var (
+	ErrRecordMagic   = errors.New("protocol: bad record magic")
+	ErrRecordVersion = errors.New("protocol: unsupported record version")
+)
+
+type Record struct {
+	Time      time.Time
+	Channel   int
+	FreqError int
+	Data      [RecordDataLength]byte
+}
+
+// NewRecord builds a record from a received message. A negative channel index
+// is stored as unknown.
+func NewRecord(msg Message) (rec Record) {
+	rec.Time = msg.Time
+	rec.Channel = msg.ChannelIdx
+	rec.FreqError = msg.FreqError
+	copy(rec.Data[:], msg.Data)
+	return rec
+}
+
+func (rec Record) String() string {
+	return fmt.Sprintf("{Time:%s Channel:%d FreqError:%d Data:%02X}",
+		rec.Time.Format(time.RFC3339Nano), rec.Channel, rec.FreqError, rec.Data,
+	)
+}
+
+// Message rebuilds the message the record was created from.
+func (rec Record) Message() Message {
+	// NewMessage expects a frame starting with the sync word. The demodulator
+	// currently frames the first MessageLength bytes of the payload.
+	frame := make([]byte, FrameLength)
+	copy(frame[SyncLength:], rec.Data[:])
+
+	m := NewMessage(dsp.Packet{Idx: -1, Data: frame})
+	m.Time = rec.Time
+	m.ChannelIdx = rec.Channel
+	m.FreqError = rec.FreqError
+	return m
+}
+
+func WriteRecord(w io.Writer, rec Record) error {
+	var buf [RecordLength]byte
+
+	binary.BigEndian.PutUint16(buf[0:], RecordMagic)
+	buf[2] = RecordVersion
+	buf[3] = noChannel
+	if rec.Channel >= 0 && rec.Channel < noChannel {
+		buf[3] = byte(rec.Channel)
+	}
+	binary.BigEndian.PutUint64(buf[4:], uint64(rec.Time.UnixNano()))
+	binary.BigEndian.PutUint32(buf[12:], uint32(int32(rec.FreqError)))
+	copy(buf[16:], rec.Data[:])
+
+	_, err := w.Write(buf[:])
+	return err
+}
+
+// ReadRecord reads a single record. It returns io.EOF only if no bytes were
+// read, a truncated record is reported as io.ErrUnexpectedEOF.
+func ReadRecord(r io.Reader) (rec Record, err error) {
+	var buf [RecordLength]byte
+
+	if _, err = io.ReadFull(r, buf[:]); err != nil {
+		return rec, err
+	}
+
+	if binary.BigEndian.Uint16(buf[0:]) != RecordMagic {
+		return rec, ErrRecordMagic
+	}
+	if buf[2] != RecordVersion {
+		return rec, fmt.Errorf("%w: %d", ErrRecordVersion, buf[2])
+	}
+
+	rec.Channel = int(buf[3])
+	if buf[3] == noChannel {
+		rec.Channel = -1
+	}
+	rec.Time = time.Unix(0, int64(binary.BigEndian.Uint64(buf[4:])))
+	rec.FreqError = int(int32(binary.BigEndian.Uint32(buf[12:])))
+	copy(rec.Data[:], buf[16:])
+
+	return rec, nil
+}
+
+// Replay reads records until EOF and hands each one that still passes the
+// parser's checksum to fn along with its re-decoded message.
+func (p *Parser) Replay(r io.Reader, fn func(Record, Message)) error {
+	for {
+		rec, err := ReadRecord(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		msg := rec.Message()
+		if p.Verify(msg.Data) != nil {
+			continue
+		}
+		msg.CRCValid = true
+		if msg.ChannelIdx >= 0 && msg.ChannelIdx < p.channelCount {
+			msg.ChannelFreq = p.channels[msg.ChannelIdx]
+		}
+
+		fn(rec, msg)
+	}
+}
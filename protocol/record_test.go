@@ -0,0 +1,121 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/bemasher/rtldavis/crc"
+	"github.com/bemasher/rtldavis/dsp"
+)
+
+// newTestMessage builds a CRC-valid message from 6 bytes of data.
+func newTestMessage(data ...byte) Message {
+	ccitt := crc.NewCRC("CCITT-16", 0, 0x1021, 0)
+	sum := ccitt.Checksum(data)
+
+	frame := append([]byte{0, 0}, data...)
+	frame = append(frame, byte(sum>>8), byte(sum))
+	return NewMessage(dsp.Packet{Data: frame})
+}
+
+func TestRecordRoundTrip(t *testing.T) {
+	msg := newTestMessage(0x80, 0x05, 0x60, 0x02, 0xF1, 0x00)
+	msg.FreqError = -1234
+	msg.Time = time.Unix(1500000000, 123456789)
+	msg.ChannelIdx = 19
+	rec := NewRecord(msg)
+
+	var buf bytes.Buffer
+	if err := WriteRecord(&buf, rec); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != RecordLength {
+		t.Fatalf("record length: got %d, want %d", buf.Len(), RecordLength)
+	}
+
+	got, err := ReadRecord(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Time.Equal(rec.Time) || got.Channel != rec.Channel || got.FreqError != rec.FreqError || got.Data != rec.Data {
+		t.Fatalf("got %s, want %s", got, rec)
+	}
+
+	if _, err := ReadRecord(&buf); err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+}
+
+func TestRecordUnknownChannel(t *testing.T) {
+	var buf bytes.Buffer
+	rec := NewRecord(newTestMessage(0x80, 0, 0, 0, 0, 0))
+	if err := WriteRecord(&buf, rec); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ReadRecord(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Channel != -1 {
+		t.Fatalf("channel: got %d, want -1", got.Channel)
+	}
+}
+
+func TestRecordErrors(t *testing.T) {
+	var buf bytes.Buffer
+	rec := NewRecord(newTestMessage(0x80, 0, 0, 0, 0, 0))
+	WriteRecord(&buf, rec)
+	raw := buf.Bytes()
+
+	badMagic := append([]byte(nil), raw...)
+	badMagic[0] ^= 0xFF
+	if _, err := ReadRecord(bytes.NewReader(badMagic)); err != ErrRecordMagic {
+		t.Fatalf("expected ErrRecordMagic, got %v", err)
+	}
+
+	badVersion := append([]byte(nil), raw...)
+	badVersion[2] = RecordVersion + 1
+	if _, err := ReadRecord(bytes.NewReader(badVersion)); !errors.Is(err, ErrRecordVersion) {
+		t.Fatalf("expected ErrRecordVersion, got %v", err)
+	}
+
+	if _, err := ReadRecord(bytes.NewReader(raw[:RecordLength-1])); err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	p := NewParser(14, 0)
+
+	valid := newTestMessage(0x82, 0x03, 0x40, 0x01, 0x23, 0x00)
+	corrupt := newTestMessage(0x80, 0x03, 0x40, 0x01, 0x23, 0x00)
+	corrupt.Data[3] ^= 0x10
+
+	valid.ChannelIdx = 3
+
+	var buf bytes.Buffer
+	WriteRecord(&buf, NewRecord(valid))
+	WriteRecord(&buf, NewRecord(corrupt))
+
+	var msgs []Message
+	err := p.Replay(&buf, func(rec Record, msg Message) {
+		msgs = append(msgs, msg)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(msgs) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(msgs))
+	}
+	if msgs[0].ID != 2 || msgs[0].Sensor != Temperature || !bytes.Equal(msgs[0].Data, valid.Data) {
+		t.Fatalf("unexpected message: %s %02X", msgs[0], msgs[0].Data)
+	}
+	if msgs[0].ChannelIdx != 3 || msgs[0].ChannelFreq != p.channels[3] {
+		t.Fatalf("channel: got %d (%d Hz), want 3 (%d Hz)", msgs[0].ChannelIdx, msgs[0].ChannelFreq, p.channels[3])
+	}
+}
@@ -0,0 +1,70 @@
+package protocol
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Region is the set of channels and hop pattern stations sold in a region
+// transmit on.
+type Region struct {
+	Name string
+
+	// Channel center frequencies in Hz, tuned to directly. These sit about
+	// 63.5 kHz below the nominal channel frequencies, which centers the
+	// demodulator's passband on the signal.
+	Channels []int
+
+	// Order channels are visited in, as indexes into Channels.
+	HopPattern []int
+}
+
+func (r Region) String() string {
+	return r.Name
+}
+
+var (
+	// US is the 902–928 MHz band used in North America and Australia.
+	US = Region{
+		Name: "us",
+		Channels: []int{
+			902355835, 902857585, 903359336, 903861086, 904362837, 904864587,
+			905366338, 905868088, 906369839, 906871589, 907373340, 907875090,
+			908376841, 908878591, 909380342, 909882092, 910383843, 910885593,
+			911387344, 911889094, 912390845, 912892595, 913394346, 913896096,
+			914397847, 914899597, 915401347, 915903098, 916404848, 916906599,
+			917408349, 917910100, 918411850, 918913601, 919415351, 919917102,
+			920418852, 920920603, 921422353, 921924104, 922425854, 922927605,
+			923429355, 923931106, 924432856, 924934607, 925436357, 925938108,
+			926439858, 926941609, 927443359,
+		},
+		HopPattern: []int{
+			0, 19, 41, 25, 8, 47, 32, 13, 36, 22, 3, 29, 44, 16, 5, 27, 38, 10,
+			49, 21, 2, 30, 42, 14, 48, 7, 24, 34, 45, 1, 17, 39, 26, 9, 31, 50,
+			37, 12, 20, 33, 4, 43, 28, 15, 35, 6, 40, 11, 23, 46, 18,
+		},
+	}
+
+	// EU is the 868 MHz band used in Europe, five channels from 868.077250
+	// to 868.557250 MHz.
+	EU = Region{
+		Name: "eu",
+		Channels: []int{
+			868013747, 868133747, 868253747, 868373747, 868493747,
+		},
+		HopPattern: []int{0, 2, 4, 1, 3},
+	}
+)
+
+// Regions lists the supported regions.
+var Regions = []Region{US, EU}
+
+// ParseRegion returns the region with the given name.
+func ParseRegion(name string) (Region, error) {
+	for _, r := range Regions {
+		if strings.EqualFold(r.Name, name) {
+			return r, nil
+		}
+	}
+	return Region{}, fmt.Errorf("unknown region: %q", name)
+}
@@ -0,0 +1,50 @@
+package protocol
+
+import "testing"
+
+func TestParseRegion(t *testing.T) {
+	for _, r := range Regions {
+		got, err := ParseRegion(r.Name)
+		if err != nil || got.Name != r.Name {
+			t.Errorf("%s: got %s, %v", r.Name, got, err)
+		}
+	}
+
+	if _, err := ParseRegion("EU"); err != nil {
+		t.Errorf("names should be case insensitive: %v", err)
+	}
+	if _, err := ParseRegion("nz"); err == nil {
+		t.Error("expected error for unknown region")
+	}
+}
+
+func TestRegionPattern(t *testing.T) {
+	for _, r := range Regions {
+		// The pattern must visit every channel exactly once.
+		seen := make(map[int]bool)
+		for _, channel := range r.HopPattern {
+			if channel < 0 || channel >= len(r.Channels) || seen[channel] {
+				t.Fatalf("%s: bad pattern entry %d", r, channel)
+			}
+			seen[channel] = true
+		}
+		if len(seen) != len(r.Channels) {
+			t.Fatalf("%s: pattern visits %d of %d channels", r, len(seen), len(r.Channels))
+		}
+	}
+}
+
+func TestSetRegion(t *testing.T) {
+	p := NewParser(14, 0)
+	if p.Region.Name != "us" || p.ChannelCount() != 51 {
+		t.Fatalf("default region: got %s with %d channels", p.Region, p.ChannelCount())
+	}
+
+	p.SetRegion(EU)
+	for idx := range EU.HopPattern {
+		hop := p.SetHop(idx)
+		if hop.ChannelFreq != EU.Channels[EU.HopPattern[idx]] {
+			t.Fatalf("hop %d: got %d", idx, hop.ChannelFreq)
+		}
+	}
+}
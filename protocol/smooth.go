@@ -0,0 +1,99 @@
+package protocol
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Smoother applies an exponential moving average to each transmitter's
+// slowly varying sensor values: temperature, humidity, UV index, solar
+// radiation, supercap voltage and light. Raw readings jitter by a step or two
+// of the transmitter's resolution, the average settles that for graphing.
+// Wind gusts and rain are events rather than trends and aren't smoothed.
+//
+// Messages arrive irregularly, so each one is weighted by the time since the
+// previous value of the same sensor: a value heard after a gap of dt moves
+// the average by 1 - exp(-dt/tau) of the difference. Smoothing adds lag, the
+// average trails a steady change by about the time constant and takes about
+// three time constants to settle after a step.
+//
+// Smoother is safe for concurrent use.
+type Smoother struct {
+	tau time.Duration
+
+	mu       sync.Mutex
+	averages *stateTable
+}
+
+type average struct {
+	value float64
+	time  time.Time
+}
+
+// NewSmoother returns a smoother with time constant tau.
+func NewSmoother(tau time.Duration) *Smoother {
+	return &Smoother{tau: tau, averages: newStateTable()}
+}
+
+// Smoothed reports whether a sensor's values are smoothed.
+func Smoothed(s Sensor) bool {
+	switch s {
+	case Temperature, Humidity, UVIndex, SolarRadiation, SuperCapVoltage, Light:
+		return true
+	}
+	return false
+}
+
+// Keys of state kept per transmitter and sensor.
+func sensorKey(id byte, s Sensor) int { return int(id)<<8 | int(s) }
+func sensorKeyID(key int) int         { return key >> 8 }
+
+// SetLimit bounds the transmitters values are smoothed for, see StateLimit.
+// A sensor whose state expires starts over from its next raw value.
+func (s *Smoother) SetLimit(limit StateLimit) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.averages.setLimit(limit, sensorKeyID)
+}
+
+// Add folds a reading's value into its sensor's average and returns the
+// average. Ok is false, and nothing is averaged, for sensors that aren't
+// smoothed, readings without a valid value, messages that failed their CRC
+// and transmitters the limit excludes.
+func (s *Smoother) Add(r Reading) (smoothed float64, ok bool) {
+	if !Smoothed(r.Sensor) || !r.Valid || (!r.CRCValid && !r.Corrected) {
+		return 0, false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := int(r.ID)
+	if !s.averages.limit.Tracks(id) {
+		return 0, false
+	}
+
+	key := sensorKey(r.ID, r.Sensor)
+	v, exists := s.averages.lookup(key, r.Time)
+	if !exists {
+		s.averages.put(key, id, r.Time, &average{r.Value, r.Time})
+		return r.Value, true
+	}
+	a := v.(*average)
+	s.averages.put(key, id, r.Time, a)
+
+	// Out of order or duplicate readings leave the average alone.
+	dt := r.Time.Sub(a.time)
+	if dt <= 0 {
+		return a.value, true
+	}
+
+	alpha := 1.0
+	if s.tau > 0 {
+		alpha = 1 - math.Exp(-dt.Seconds()/s.tau.Seconds())
+	}
+	a.value += alpha * (r.Value - a.value)
+	a.time = r.Time
+	return a.value, true
+}
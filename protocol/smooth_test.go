@@ -0,0 +1,90 @@
+package protocol
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+var smoothStart = time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC)
+
+func smoothReading(header byte, value float64, at time.Duration) Reading {
+	m := newTestMessage(header, 0, 0, 0, 0, 0)
+	m.CRCValid = true
+	m.Time = smoothStart.Add(at)
+	return Reading{Message: m, Value: value, Valid: true}
+}
+
+func TestSmoother(t *testing.T) {
+	s := NewSmoother(time.Minute)
+
+	// The first value seeds the average.
+	if v, ok := s.Add(smoothReading(0x81, 70, 0)); !ok || v != 70 {
+		t.Fatalf("seed: got %v, %t", v, ok)
+	}
+
+	// A step is approached exponentially, weighted by the time since the
+	// last value.
+	want := 70 + 10*(1-math.Exp(-0.5))
+	if v, _ := s.Add(smoothReading(0x81, 80, 30*time.Second)); math.Abs(v-want) > 1e-9 {
+		t.Fatalf("step: got %v, want %v", v, want)
+	}
+	want += (80 - want) * (1 - math.Exp(-4))
+	if v, _ := s.Add(smoothReading(0x81, 80, 270*time.Second)); math.Abs(v-want) > 1e-9 {
+		t.Fatalf("settling: got %v, want %v", v, want)
+	}
+
+	// Out of order values don't move the average.
+	if v, _ := s.Add(smoothReading(0x81, 0, 10*time.Second)); math.Abs(v-want) > 1e-9 {
+		t.Fatalf("out of order: got %v, want %v", v, want)
+	}
+
+	// Sensors and transmitters are averaged separately.
+	if v, _ := s.Add(smoothReading(0xA1, 50, 300*time.Second)); v != 50 {
+		t.Fatalf("humidity: got %v, want 50", v)
+	}
+	if v, _ := s.Add(smoothReading(0x82, 60, 300*time.Second)); v != 60 {
+		t.Fatalf("second transmitter: got %v, want 60", v)
+	}
+}
+
+func TestSmootherExcluded(t *testing.T) {
+	s := NewSmoother(time.Minute)
+
+	for _, header := range []byte{0x91, 0xE1, 0x51} {
+		if _, ok := s.Add(smoothReading(header, 1, 0)); ok {
+			t.Fatalf("%s: smoothed", Sensor(header>>4))
+		}
+	}
+
+	invalid := smoothReading(0x81, 70, 0)
+	invalid.CRCValid = false
+	if _, ok := s.Add(invalid); ok {
+		t.Fatal("smoothed a message failing its crc")
+	}
+	missing := smoothReading(0x81, 70, 0)
+	missing.Valid = false
+	if _, ok := s.Add(missing); ok {
+		t.Fatal("smoothed a reading without a value")
+	}
+
+	s.SetLimit(StateLimit{IDs: []int{2}})
+	if _, ok := s.Add(smoothReading(0x81, 70, 0)); ok {
+		t.Fatal("smoothed a transmitter the limit excludes")
+	}
+}
+
+func TestDecoderSmooth(t *testing.T) {
+	d := Decoder{Smooth: NewSmoother(time.Minute)}
+
+	m := newTestMessage(0x81, 0, 0, 0x2E, 0xE0, 0)
+	m.CRCValid = true
+	r := d.Decode(m)
+	if !r.SmoothedValid || r.Smoothed != r.Value || r.Value != 75 {
+		t.Fatalf("got %+v", r)
+	}
+
+	if r := Decode(m); r.SmoothedValid {
+		t.Fatal("smoothed without a smoother")
+	}
+}
@@ -0,0 +1,99 @@
+package protocol
+
+import "time"
+
+// StateLimit bounds the per-transmitter state kept by Continuity,
+// RainAccumulator and the parser's corrector. Ids are only 4 bits so state
+// can't grow without bound, but there's no point keeping it for neighboring
+// stations nobody listens to, or for transmitters long gone.
+type StateLimit struct {
+	// IDs, if not empty, are the only transmitters state is kept for.
+	IDs []int
+
+	// Expiry, if positive, forgets a transmitter's state once it hasn't been
+	// heard from for this long. Its next message starts over as if it were
+	// the first.
+	Expiry time.Duration
+}
+
+// Tracks reports whether state is kept for transmitter id.
+func (l StateLimit) Tracks(id int) bool {
+	if len(l.IDs) == 0 {
+		return true
+	}
+	for _, i := range l.IDs {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+// stateTable holds per-transmitter state within a StateLimit. Entries are
+// keyed by anything identifying a transmitter's state, e.g. its id or a
+// message header, and also carry the id the limit applies to.
+type stateTable struct {
+	limit   StateLimit
+	entries map[int]*stateEntry
+}
+
+type stateEntry struct {
+	seen  time.Time
+	value interface{}
+}
+
+func newStateTable() *stateTable {
+	return &stateTable{entries: make(map[int]*stateEntry)}
+}
+
+// setLimit applies a new limit, forgetting state for ids it excludes.
+func (t *stateTable) setLimit(limit StateLimit, id func(key int) int) {
+	t.limit = limit
+	for key := range t.entries {
+		if !limit.Tracks(id(key)) {
+			delete(t.entries, key)
+		}
+	}
+}
+
+// lookup expires stale entries as of now and returns key's state, if any.
+func (t *stateTable) lookup(key int, now time.Time) (interface{}, bool) {
+	t.expire(now)
+	return t.get(key)
+}
+
+// get returns key's state, if any, without expiring entries.
+func (t *stateTable) get(key int) (interface{}, bool) {
+	if e, exists := t.entries[key]; exists {
+		return e.value, true
+	}
+	return nil, false
+}
+
+// put stores key's state, heard from transmitter id at now. Ignored if the
+// limit excludes id.
+func (t *stateTable) put(key, id int, now time.Time, value interface{}) {
+	if !t.limit.Tracks(id) {
+		return
+	}
+	t.entries[key] = &stateEntry{now, value}
+}
+
+// expire forgets entries not heard from within the limit's expiry of now.
+func (t *stateTable) expire(now time.Time) {
+	if t.limit.Expiry <= 0 {
+		return
+	}
+	for key, e := range t.entries {
+		if now.Sub(e.seen) > t.limit.Expiry {
+			delete(t.entries, key)
+		}
+	}
+}
+
+func (t *stateTable) len() int {
+	return len(t.entries)
+}
+
+// Keys that are ids.
+func identity(key int) int { return key }
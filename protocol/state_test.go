@@ -0,0 +1,122 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bemasher/rtldavis/clock"
+	"github.com/bemasher/rtldavis/dsp"
+)
+
+func TestStateTable(t *testing.T) {
+	start := time.Unix(1500000000, 0)
+	s := newStateTable()
+	s.setLimit(StateLimit{IDs: []int{1, 2}, Expiry: time.Minute}, identity)
+
+	s.put(1, 1, start, "one")
+	s.put(2, 2, start.Add(30*time.Second), "two")
+	s.put(3, 3, start, "three")
+	if s.len() != 2 {
+		t.Fatalf("expected only filtered ids, got %d entries", s.len())
+	}
+
+	if v, ok := s.lookup(1, start.Add(time.Minute)); !ok || v != "one" {
+		t.Fatalf("within expiry: got %v, %t", v, ok)
+	}
+	if _, ok := s.lookup(1, start.Add(time.Minute+time.Second)); ok {
+		t.Fatal("expected id 1 to expire")
+	}
+	if _, ok := s.get(2); !ok {
+		t.Fatal("expected id 2 to be kept")
+	}
+
+	// Narrowing the filter forgets excluded ids.
+	s.setLimit(StateLimit{IDs: []int{1}}, identity)
+	if s.len() != 0 {
+		t.Fatalf("expected excluded ids to be forgotten, got %d entries", s.len())
+	}
+
+	if !(StateLimit{}).Tracks(7) {
+		t.Fatal("an empty limit should track every id")
+	}
+}
+
+func TestRainAccumulatorLimit(t *testing.T) {
+	a := NewRainAccumulator()
+	a.SetLimit(StateLimit{IDs: []int{1}, Expiry: time.Hour})
+
+	start := time.Unix(1500000000, 0)
+	at := func(id, counter byte, elapsed time.Duration) Message {
+		m := rainMessage(id, counter)
+		m.Time = start.Add(elapsed)
+		return m
+	}
+
+	if _, ok := a.Add(at(2, 10, 0)); ok {
+		t.Fatal("expected excluded id to be ignored")
+	}
+
+	a.Add(at(1, 10, 0))
+	if tips, _ := a.Add(at(1, 15, time.Minute)); tips != 5 {
+		t.Fatalf("got %d tips, want 5", tips)
+	}
+
+	// Heard again after expiring, the counter is a new baseline.
+	if tips, ok := a.Add(at(1, 20, 2*time.Hour)); !ok || tips != 0 {
+		t.Fatalf("after expiry: got %d, %t", tips, ok)
+	}
+}
+
+func TestContinuityLimit(t *testing.T) {
+	p := NewParser(14, 0)
+	c := p.NewContinuity(DefaultTolerance)
+	c.SetLimit(StateLimit{Expiry: time.Minute})
+
+	first := newTestMessage(0x82, 0x05, 0x60, 0x02, 0xF1, 0x00)
+	first.Time = time.Unix(1500000000, 0)
+	c.Check(first)
+
+	// Far too soon after the first, but that was forgotten.
+	next := first
+	next.Time = first.Time.Add(time.Minute + time.Second)
+	if err := c.Check(next); err != nil {
+		t.Fatalf("expected expired state to be forgotten: %v", err)
+	}
+
+	again := next
+	again.Time = next.Time.Add(time.Second)
+	if err := c.Check(again); err == nil {
+		t.Fatal("expected an anomaly against the new reference")
+	}
+}
+
+func TestCorrectorLimit(t *testing.T) {
+	c := clock.NewFake(time.Unix(1500000000, 0))
+
+	p := NewParser(14, 0)
+	p.Clock = c
+	p.SetStateLimit(StateLimit{IDs: []int{0}, Expiry: time.Minute})
+	p.EnableCorrection(4)
+
+	corrupt := func(msg Message) dsp.Packet {
+		msg.Data = append([]byte(nil), msg.Data...)
+		msg.Data[3] ^= 0x01
+		return airPacket(msg)
+	}
+
+	valid := newTestMessage(0x80, 0x05, 0x60, 0x02, 0xF1, 0x00)
+	other := newTestMessage(0x81, 0x05, 0x60, 0x02, 0xF1, 0x00)
+	p.Parse([]dsp.Packet{airPacket(valid), airPacket(other)})
+
+	if msgs := p.Parse([]dsp.Packet{corrupt(other)}); len(msgs) != 0 {
+		t.Fatalf("expected excluded id not to be corrected: %+v", msgs)
+	}
+	if msgs := p.Parse([]dsp.Packet{corrupt(valid)}); len(msgs) != 1 || !msgs[0].Corrected {
+		t.Fatalf("expected correction: %+v", msgs)
+	}
+
+	c.Advance(2 * time.Minute)
+	if msgs := p.Parse([]dsp.Packet{corrupt(valid)}); len(msgs) != 0 {
+		t.Fatalf("expected expired reference not to be used: %+v", msgs)
+	}
+}
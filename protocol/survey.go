@@ -0,0 +1,109 @@
+package protocol
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DwellTime returns the interval between transmissions of the given
+// transmitter id. Higher ids transmit slightly less often so that stations
+// sharing a receiver don't collide continuously.
+func DwellTime(id int) time.Duration {
+	return 2562500*time.Microsecond + time.Duration(id)*62500*time.Microsecond
+}
+
+// Survey collects the transmitters heard while scanning, regardless of id.
+type Survey struct {
+	transmitters map[int]*TransmitterSurvey
+}
+
+// TransmitterSurvey summarizes the messages heard from a single transmitter.
+type TransmitterSurvey struct {
+	ID          int
+	Packets     int
+	First, Last time.Time
+
+	// Interval is the average time between transmissions, estimated from
+	// the gaps between consecutive messages. Zero if fewer than two messages
+	// were heard.
+	Interval time.Duration
+
+	Sensors map[Sensor]int
+
+	intervalSum   time.Duration
+	intervalCount int
+}
+
+func NewSurvey() *Survey {
+	return &Survey{transmitters: make(map[int]*TransmitterSurvey)}
+}
+
+// Add records a message, which must have its Time set.
+func (s *Survey) Add(msg Message) {
+	id := int(msg.ID)
+
+	t, exists := s.transmitters[id]
+	if !exists {
+		t = &TransmitterSurvey{
+			ID:      id,
+			First:   msg.Time,
+			Sensors: make(map[Sensor]int),
+		}
+		s.transmitters[id] = t
+	}
+
+	// Gaps usually span several transmissions, divide by the number of
+	// nominal dwell times they cover to get an estimate per transmission.
+	if t.Packets > 0 {
+		gap := msg.Time.Sub(t.Last)
+		hops := int((gap + DwellTime(id)/2) / DwellTime(id))
+		if hops > 0 {
+			t.intervalSum += gap / time.Duration(hops)
+			t.intervalCount++
+			t.Interval = t.intervalSum / time.Duration(t.intervalCount)
+		}
+	}
+
+	t.Packets++
+	t.Last = msg.Time
+	t.Sensors[msg.Sensor]++
+}
+
+// Results returns the transmitters heard, ordered by id.
+func (s *Survey) Results() (results []TransmitterSurvey) {
+	for _, t := range s.transmitters {
+		results = append(results, *t)
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].ID < results[j].ID
+	})
+
+	return results
+}
+
+func (t TransmitterSurvey) String() string {
+	var sensors []Sensor
+	for sensor := range t.Sensors {
+		sensors = append(sensors, sensor)
+	}
+	sort.Slice(sensors, func(i, j int) bool {
+		return sensors[i] < sensors[j]
+	})
+
+	names := make([]string, len(sensors))
+	for idx, sensor := range sensors {
+		names[idx] = sensor.String()
+	}
+
+	interval := "unknown"
+	if t.Interval > 0 {
+		interval = t.Interval.Round(time.Millisecond).String()
+	}
+
+	return fmt.Sprintf("ID:%d Packets:%d Interval:%s (expected %s) Sensors:[%s]",
+		t.ID, t.Packets, interval, DwellTime(t.ID), strings.Join(names, ", "),
+	)
+}
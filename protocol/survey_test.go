@@ -0,0 +1,45 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSurvey(t *testing.T) {
+	s := NewSurvey()
+	start := time.Unix(1500000000, 0)
+
+	add := func(id int, sensor Sensor, at time.Duration) {
+		msg := newTestMessage(byte(sensor)<<4|byte(id), 0, 0, 0, 0, 0)
+		msg.Time = start.Add(at)
+		s.Add(msg)
+	}
+
+	// Transmitter 3 heard on consecutive hops, then again after missing two.
+	dwell := DwellTime(3)
+	add(3, Temperature, 0)
+	add(3, Humidity, dwell)
+	add(3, Temperature, 4*dwell)
+
+	add(0, Rain, 10*time.Second)
+
+	results := s.Results()
+	if len(results) != 2 {
+		t.Fatalf("expected 2 transmitters, got %d", len(results))
+	}
+
+	if results[0].ID != 0 || results[0].Packets != 1 || results[0].Interval != 0 {
+		t.Fatalf("unexpected result for id 0: %s", results[0])
+	}
+
+	r := results[1]
+	if r.ID != 3 || r.Packets != 3 {
+		t.Fatalf("unexpected result for id 3: %s", r)
+	}
+	if r.Interval != dwell {
+		t.Fatalf("interval: got %s, want %s", r.Interval, dwell)
+	}
+	if r.Sensors[Temperature] != 2 || r.Sensors[Humidity] != 1 {
+		t.Fatalf("unexpected sensors: %v", r.Sensors)
+	}
+}
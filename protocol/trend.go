@@ -0,0 +1,192 @@
+package protocol
+
+import (
+	"sync"
+	"time"
+)
+
+// Trend is the direction a sensor's value has moved in over a trend
+// interval, as shown by a display's trend arrow.
+type Trend int
+
+const (
+	// TrendUnknown means there's no value from an interval ago to compare
+	// with, either the transmitter hasn't been heard from for that long or
+	// there was a gap in its messages at the time.
+	TrendUnknown Trend = iota
+	TrendFalling
+	TrendSteady
+	TrendRising
+)
+
+func (t Trend) String() string {
+	switch t {
+	case TrendFalling:
+		return "falling"
+	case TrendSteady:
+		return "steady"
+	case TrendRising:
+		return "rising"
+	default:
+		return "unknown"
+	}
+}
+
+// DefaultTrendInterval is the conventional interval trends are computed over,
+// that of the barometric pressure tendency.
+const DefaultTrendInterval = 3 * time.Hour
+
+// DefaultTrendThresholds are the changes over a trend interval, in the units
+// values are decoded in, below which a sensor is steady. Only temperature
+// has a trend by default. Pressure would be the other conventional one, but
+// the console measures it and no message carries it.
+var DefaultTrendThresholds = map[Sensor]float64{
+	Temperature: 1,
+}
+
+// Trends computes each transmitter's sensor trends by comparing a reading's
+// value, its smoothed value if it has one, with the value heard a trend
+// interval earlier.
+//
+// Messages arrive every few seconds but not exactly on time, and there may be
+// gaps of minutes or hours. The earlier value is the one heard closest to a
+// trend interval ago, and no further than an eighth of the interval from it.
+// If there's none the trend is unknown rather than measured over a longer or
+// shorter interval than asked for.
+//
+// Trends is safe for concurrent use.
+type Trends struct {
+	interval   time.Duration
+	thresholds map[Sensor]float64
+
+	mu        sync.Mutex
+	histories *stateTable
+}
+
+// At most this many values are kept per sensor and interval.
+const trendResolution = 64
+
+type trendSample struct {
+	time  time.Time
+	value float64
+}
+
+// NewTrends returns a trend calculator over interval with
+// DefaultTrendThresholds.
+func NewTrends(interval time.Duration) *Trends {
+	t := &Trends{
+		interval:   interval,
+		thresholds: make(map[Sensor]float64),
+		histories:  newStateTable(),
+	}
+	for s, threshold := range DefaultTrendThresholds {
+		t.thresholds[s] = threshold
+	}
+	return t
+}
+
+// SetThreshold sets the change over the interval below which sensor s is
+// steady. A threshold that isn't positive stops computing its trend.
+func (t *Trends) SetThreshold(s Sensor, threshold float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if threshold <= 0 {
+		delete(t.thresholds, s)
+		return
+	}
+	t.thresholds[s] = threshold
+}
+
+// SetLimit bounds the transmitters trends are computed for, see StateLimit.
+func (t *Trends) SetLimit(limit StateLimit) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.histories.setLimit(limit, sensorKeyID)
+}
+
+// Add records a reading's value and returns its sensor's trend. The trend is
+// unknown for sensors without a threshold, readings without a valid value,
+// messages that failed their CRC and transmitters the limit excludes.
+func (t *Trends) Add(r Reading) Trend {
+	if !r.Valid || (!r.CRCValid && !r.Corrected) {
+		return TrendUnknown
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	threshold, ok := t.thresholds[r.Sensor]
+	id := int(r.ID)
+	if !ok || !t.histories.limit.Tracks(id) {
+		return TrendUnknown
+	}
+
+	value := r.Value
+	if r.SmoothedValid {
+		value = r.Smoothed
+	}
+
+	key := sensorKey(r.ID, r.Sensor)
+	var samples []trendSample
+	if v, exists := t.histories.lookup(key, r.Time); exists {
+		samples = v.([]trendSample)
+	}
+
+	trend := TrendUnknown
+	if past, ok := t.past(samples, r.Time); ok {
+		switch delta := value - past; {
+		case delta >= threshold:
+			trend = TrendRising
+		case delta <= -threshold:
+			trend = TrendFalling
+		default:
+			trend = TrendSteady
+		}
+	}
+
+	t.histories.put(key, id, r.Time, t.record(samples, trendSample{r.Time, value}))
+	return trend
+}
+
+// tolerance is how far from an interval ago the earlier value may be.
+func (t *Trends) tolerance() time.Duration {
+	return t.interval / 8
+}
+
+// past returns the value in samples heard closest to an interval before now,
+// if one is within tolerance.
+func (t *Trends) past(samples []trendSample, now time.Time) (float64, bool) {
+	target := now.Add(-t.interval)
+
+	var best trendSample
+	bestDistance := t.tolerance() + 1
+	for _, s := range samples {
+		distance := s.time.Sub(target)
+		if distance < 0 {
+			distance = -distance
+		}
+		if distance < bestDistance {
+			best, bestDistance = s, distance
+		}
+	}
+	return best.value, bestDistance <= t.tolerance()
+}
+
+// record appends s to samples, unless the last one was recorded less than
+// interval/trendResolution before it, and drops samples too old to be
+// compared with again.
+func (t *Trends) record(samples []trendSample, s trendSample) []trendSample {
+	oldest := s.time.Add(-t.interval - t.tolerance())
+	drop := 0
+	for drop < len(samples) && samples[drop].time.Before(oldest) {
+		drop++
+	}
+	samples = append(samples[:0], samples[drop:]...)
+
+	if n := len(samples); n > 0 && s.time.Sub(samples[n-1].time) < t.interval/trendResolution {
+		// Out of order readings are dropped as well.
+		return samples
+	}
+	return append(samples, s)
+}
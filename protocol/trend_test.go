@@ -0,0 +1,83 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTrends(t *testing.T) {
+	tr := NewTrends(time.Hour)
+
+	// A temperature every 10 seconds, rising 1° an hour for an hour and a
+	// half, then falling twice as fast.
+	value := func(at time.Duration) float64 {
+		if at <= 90*time.Minute {
+			return 70 + at.Hours()
+		}
+		return 71.5 - 2*(at-90*time.Minute).Hours()
+	}
+
+	got := make(map[time.Duration]Trend)
+	for at := time.Duration(0); at <= 3*time.Hour; at += 10 * time.Second {
+		got[at] = tr.Add(smoothReading(0x81, value(at), at))
+	}
+
+	for _, tc := range []struct {
+		at   time.Duration
+		want Trend
+	}{
+		// Not heard from an interval ago yet.
+		{0, TrendUnknown},
+		{50 * time.Minute, TrendUnknown},
+		// The earliest values are within tolerance of an interval ago.
+		{55 * time.Minute, TrendSteady},
+		{time.Hour, TrendRising},
+		{100 * time.Minute, TrendSteady},
+		{150 * time.Minute, TrendFalling},
+		{3 * time.Hour, TrendFalling},
+	} {
+		if got[tc.at] != tc.want {
+			t.Errorf("%s: got %s, want %s", tc.at, got[tc.at], tc.want)
+		}
+	}
+}
+
+func TestTrendsGap(t *testing.T) {
+	tr := NewTrends(time.Hour)
+	tr.Add(smoothReading(0x81, 70, 0))
+
+	// Nothing heard near an interval ago.
+	tr.Add(smoothReading(0x81, 72, 50*time.Minute))
+	if got := tr.Add(smoothReading(0x81, 75, 2*time.Hour)); got != TrendUnknown {
+		t.Fatalf("across a gap: got %s", got)
+	}
+
+	// Values heard near, not exactly, an interval ago are compared with.
+	if got := tr.Add(smoothReading(0x81, 76.5, 2*time.Hour+58*time.Minute)); got != TrendRising {
+		t.Fatalf("within tolerance: got %s", got)
+	}
+}
+
+func TestTrendsExcluded(t *testing.T) {
+	tr := NewTrends(time.Hour)
+	tr.SetThreshold(Humidity, 5)
+	tr.SetThreshold(Temperature, 0)
+
+	for _, r := range []Reading{smoothReading(0x81, 70, 0), smoothReading(0x81, 80, time.Hour)} {
+		if got := tr.Add(r); got != TrendUnknown {
+			t.Fatalf("temperature without a threshold: got %s", got)
+		}
+	}
+
+	tr.Add(smoothReading(0xA1, 50, 0))
+	if got := tr.Add(smoothReading(0xA1, 40, time.Hour)); got != TrendFalling {
+		t.Fatalf("humidity: got %s", got)
+	}
+
+	// The smoothed value is used if there is one.
+	r := smoothReading(0xA1, 40, 2*time.Hour)
+	r.Smoothed, r.SmoothedValid = 38, true
+	if got := tr.Add(r); got != TrendSteady {
+		t.Fatalf("smoothed: got %s", got)
+	}
+}
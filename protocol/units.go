@@ -0,0 +1,103 @@
+package protocol
+
+import "fmt"
+
+// Units selects the unit system values are reported in. Decoders always
+// return the station's native imperial units, conversion happens only when
+// values are presented.
+type Units int
+
+const (
+	Imperial Units = iota
+	Metric
+)
+
+func ParseUnits(s string) (Units, error) {
+	switch s {
+	case "imperial":
+		return Imperial, nil
+	case "metric":
+		return Metric, nil
+	default:
+		return Imperial, fmt.Errorf("unknown unit system: %q", s)
+	}
+}
+
+func (u Units) String() string {
+	switch u {
+	case Imperial:
+		return "imperial"
+	case Metric:
+		return "metric"
+	default:
+		return fmt.Sprintf("Units(%d)", int(u))
+	}
+}
+
+// TempC converts degrees Fahrenheit to Celsius.
+func TempC(f float64) float64 {
+	return (f - 32) * 5 / 9
+}
+
+// WindKph converts miles per hour to kilometers per hour.
+func WindKph(mph float64) float64 {
+	return mph * 1.609344
+}
+
+// WindMs converts miles per hour to meters per second.
+func WindMs(mph float64) float64 {
+	return mph * 0.44704
+}
+
+// RainMm converts inches to millimeters.
+func RainMm(in float64) float64 {
+	return in * 25.4
+}
+
+// Rainfall converts rainfall in inches and returns it with its unit.
+func (u Units) Rainfall(in float64) (float64, string) {
+	if u == Metric {
+		return RainMm(in), "mm"
+	}
+	return in, "in"
+}
+
+// Speed converts a wind speed in mph and returns it with its unit.
+func (u Units) Speed(mph float64) (float64, string) {
+	if u == Metric {
+		return WindKph(mph), "km/h"
+	}
+	return mph, "mph"
+}
+
+// Value converts a sensor value as returned by its decoder and returns it
+// with its unit. Values without a unit system, like humidity, are returned
+// unchanged.
+func (u Units) Value(sensor Sensor, v float64) (float64, string) {
+	switch sensor {
+	case Temperature:
+		if u == Metric {
+			return TempC(v), "°C"
+		}
+		return v, "°F"
+	case WindGustSpeed:
+		return u.Speed(v)
+	case RainRate:
+		if u == Metric {
+			return RainMm(v), "mm/h"
+		}
+		return v, "in/h"
+	case Humidity:
+		return v, "%"
+	case Rain:
+		return v, "tips"
+	case UVIndex:
+		return v, "index"
+	case SolarRadiation:
+		return v, "W/m²"
+	case SuperCapVoltage:
+		return v, "V"
+	default:
+		return v, ""
+	}
+}
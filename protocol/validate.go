@@ -0,0 +1,113 @@
+package protocol
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Range is an inclusive range of plausible values. A value outside it most
+// likely comes from bit errors that happened to pass the CRC.
+type Range struct {
+	Min, Max float64
+}
+
+func (r Range) Contains(v float64) bool {
+	return v >= r.Min && v <= r.Max
+}
+
+func (r Range) String() string {
+	return fmt.Sprintf("%g:%g", r.Min, r.Max)
+}
+
+// Ranges holds the plausible values of each sensor, in the units its decoder
+// returns, and of the wind speed carried by every message. Sensors without a
+// range aren't validated.
+type Ranges struct {
+	WindSpeed Range
+	Sensors   map[Sensor]Range
+}
+
+// DefaultRanges covers what the station's sensors can physically report.
+func DefaultRanges() *Ranges {
+	return &Ranges{
+		WindSpeed: Range{0, 200},
+		Sensors: map[Sensor]Range{
+			Temperature:     {-60, 150},
+			Humidity:        {0, 100},
+			WindGustSpeed:   {0, 200},
+			RainRate:        {0, 100},
+			UVIndex:         {0, 16},
+			SolarRadiation:  {0, 1800},
+			SuperCapVoltage: {0, 5},
+		},
+	}
+}
+
+// Name used for a sensor's range in Set, wind for the wind speed.
+func rangeName(s Sensor) string {
+	return strings.ToLower(strings.Replace(s.String(), " ", "_", -1))
+}
+
+var rangeSensors = []Sensor{
+	SuperCapVoltage, UVIndex, RainRate, SolarRadiation, Light, Temperature,
+	WindGustSpeed, Humidity, Rain,
+}
+
+// Set overrides ranges from a comma separated list of name=min:max, where
+// name is wind or a sensor's name in lower case with underscores, such as
+// temperature or rain_rate.
+func (r *Ranges) Set(s string) error {
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		parts := strings.SplitN(field, "=", 2)
+		bounds := strings.SplitN(parts[len(parts)-1], ":", 2)
+		if len(parts) != 2 || len(bounds) != 2 {
+			return fmt.Errorf("invalid range %q, expected name=min:max", field)
+		}
+
+		var rng Range
+		var err error
+		if rng.Min, err = strconv.ParseFloat(bounds[0], 64); err != nil {
+			return fmt.Errorf("invalid range %q: %v", field, err)
+		}
+		if rng.Max, err = strconv.ParseFloat(bounds[1], 64); err != nil {
+			return fmt.Errorf("invalid range %q: %v", field, err)
+		}
+
+		if parts[0] == "wind" {
+			r.WindSpeed = rng
+			continue
+		}
+
+		found := false
+		for _, sensor := range rangeSensors {
+			if rangeName(sensor) == parts[0] {
+				r.Sensors[sensor] = rng
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("unknown sensor %q", parts[0])
+		}
+	}
+
+	return nil
+}
+
+// Check reports whether a reading's wind speed and sensor value are within
+// range.
+func (r *Ranges) Check(reading Reading) bool {
+	if !r.WindSpeed.Contains(reading.Speed) {
+		return false
+	}
+
+	if rng, exists := r.Sensors[reading.Sensor]; exists && reading.Valid {
+		return rng.Contains(reading.Value)
+	}
+	return true
+}
@@ -0,0 +1,64 @@
+package protocol
+
+import "testing"
+
+func TestRanges(t *testing.T) {
+	d := Decoder{Ranges: DefaultRanges()}
+
+	for _, tc := range []struct {
+		name       string
+		data       []byte
+		outOfRange bool
+	}{
+		{"temperature", []byte{0x80, 0x05, 0x60, 0x2E, 0xE0, 0x00}, false},
+		{"hot temperature", []byte{0x80, 0x05, 0x60, 0x7F, 0xF0, 0x00}, true},
+		{"humidity", []byte{0xA0, 0x05, 0x60, 0x1A, 0x20, 0x00}, false},
+		{"humidity over 100%", []byte{0xA0, 0x05, 0x60, 0xFF, 0x30, 0x00}, true},
+		{"gale", []byte{0x80, 210, 0x60, 0x2E, 0xE0, 0x00}, true},
+		{"unvalidated sensor", []byte{0xE0, 0x05, 0x60, 0x7F, 0x00, 0x00}, false},
+	} {
+		r := d.Decode(newTestMessage(tc.data...))
+		if r.OutOfRange != tc.outOfRange {
+			t.Errorf("%s: got out of range %t, want %t (value %v, wind %v)", tc.name, r.OutOfRange, tc.outOfRange, r.Value, r.Speed)
+		}
+		if !r.Valid {
+			t.Errorf("%s: flagging shouldn't invalidate the value", tc.name)
+		}
+	}
+
+	// Without ranges nothing is flagged.
+	if r := Decode(newTestMessage(0x80, 0x05, 0x60, 0x7F, 0xF0, 0x00)); r.OutOfRange {
+		t.Error("flagged without validation")
+	}
+
+	d.Reject = true
+	if r := d.Decode(newTestMessage(0x80, 0x05, 0x60, 0x7F, 0xF0, 0x00)); !r.OutOfRange || r.Valid {
+		t.Errorf("rejected reading: got out of range %t, valid %t", r.OutOfRange, r.Valid)
+	}
+}
+
+func TestRangesSet(t *testing.T) {
+	r := DefaultRanges()
+	if err := r.Set("temperature=-40:140, wind=0:150,rain_rate=0:20"); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := r.Sensors[Temperature]; got != (Range{-40, 140}) {
+		t.Errorf("temperature: got %s", got)
+	}
+	if got := r.Sensors[RainRate]; got != (Range{0, 20}) {
+		t.Errorf("rain rate: got %s", got)
+	}
+	if r.WindSpeed != (Range{0, 150}) {
+		t.Errorf("wind: got %s", r.WindSpeed)
+	}
+	if got := r.Sensors[Humidity]; got != (Range{0, 100}) {
+		t.Errorf("unset ranges should keep defaults: got %s", got)
+	}
+
+	for _, bad := range []string{"temperature", "temperature=1", "pressure=0:1", "humidity=a:b"} {
+		if err := r.Set(bad); err == nil {
+			t.Errorf("%q: expected error", bad)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package receiver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bemasher/rtldavis/protocol"
+)
+
+// DefaultCalibrationTime is long enough for several sweeps of the band, which
+// averages out transmissions heard during any one of them.
+const DefaultCalibrationTime = time.Minute
+
+// MaxGainOffset bounds the offsets Calibration suggests, in tenths of a dB. A
+// channel further off than this is more likely interfered with than poorly
+// received.
+const MaxGainOffset = 60
+
+// Time spent on each channel per sweep.
+const calibrationDwell = 100 * time.Millisecond
+
+// CalibrationConfig configures Calibrate.
+type CalibrationConfig struct {
+	// Gain is the fixed gain to measure at in tenths of a dB, that offsets
+	// will be applied to. It must be positive, an automatic gain would
+	// level out what's being measured.
+	Gain int
+
+	// How long to discard samples for after each retune, and how long to
+	// measure for in total, DefaultCalibrationTime if zero.
+	SettleTime time.Duration
+	Duration   time.Duration
+}
+
+// Calibration is the noise floor measured on each channel.
+type Calibration struct {
+	// Gain measured at, in tenths of a dB.
+	Gain int
+
+	// Median block power of each channel index, in dB relative to a full
+	// scale sine.
+	NoiseFloor map[int]float64
+}
+
+// Calibrate measures each channel's noise floor at a fixed gain by sweeping
+// the band repeatedly, to suggest per-channel gain offsets that flatten the
+// front end's response. See Calibration.Offsets.
+//
+// Capture rates would measure sensitivity more directly but take hours to be
+// meaningful and fold in interference and the hop schedule. Away from strong
+// signals the noise floor is the front end's own noise, and follows its gain.
+func Calibrate(ctx context.Context, p *protocol.Parser, dev Device, cfg CalibrationConfig) (Calibration, error) {
+	gs, ok := dev.(GainSetter)
+	if cfg.Gain <= 0 || !ok {
+		return Calibration{}, ErrNoGain
+	}
+	if err := gs.SetGain(cfg.Gain); err != nil {
+		return Calibration{}, err
+	}
+	if cfg.Duration <= 0 {
+		cfg.Duration = DefaultCalibrationTime
+	}
+
+	// Count samples rather than time them, captures read faster than real
+	// time.
+	blockTime := time.Duration(p.Cfg.DeviceBlockSize2/2) * time.Second / time.Duration(p.Cfg.DeviceSampleRate)
+	settleBlocks := int((cfg.SettleTime + blockTime - 1) / blockTime)
+	dwellBlocks := int(calibrationDwell / blockTime)
+	if dwellBlocks < 1 {
+		dwellBlocks = 1
+	}
+
+	channels := p.ChannelCount()
+	sweeps := int(cfg.Duration / (time.Duration(channels) * calibrationDwell))
+	if sweeps < 1 {
+		sweeps = 1
+	}
+
+	powers := make(map[int][]float64)
+	block := make([]byte, p.Cfg.DeviceBlockSize2)
+	for sweep := 0; sweep < sweeps; sweep++ {
+		for hopIdx := 0; hopIdx < channels; hopIdx++ {
+			hop := p.SetHop(hopIdx)
+			if err := dev.SetCenterFreq(hop.ChannelFreq + hop.FreqError); err != nil {
+				return Calibration{}, err
+			}
+
+			for idx := 0; idx < settleBlocks+dwellBlocks; idx++ {
+				if err := ctx.Err(); err != nil {
+					return Calibration{}, err
+				}
+
+				_, err := io.ReadFull(dev, block)
+				if errors.Is(err, ErrSampleDropped) || errors.Is(err, ErrReconnected) {
+					continue
+				} else if err != nil {
+					return Calibration{}, err
+				}
+
+				if idx >= settleBlocks {
+					powers[hop.ChannelIdx] = append(powers[hop.ChannelIdx], blockPower(block))
+				}
+			}
+		}
+	}
+
+	c := Calibration{Gain: cfg.Gain, NoiseFloor: make(map[int]float64)}
+	for channel, values := range powers {
+		// The median ignores blocks with a packet in them, a full scale
+		// sine has a power of 127.5^2.
+		c.NoiseFloor[channel] = 10 * math.Log10(median(values)/(127.5*127.5))
+	}
+	return c, nil
+}
+
+func median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+
+	n := len(sorted)
+	if n%2 == 0 {
+		return (sorted[n/2-1] + sorted[n/2]) / 2
+	}
+	return sorted[n/2]
+}
+
+// Offsets suggests the gain offset of each channel: the amount its noise
+// floor is below the median channel's, up to MaxGainOffset either way.
+// Channels whose offset rounds to zero are left out.
+func (c Calibration) Offsets() GainOffsets {
+	floors := make([]float64, 0, len(c.NoiseFloor))
+	for _, floor := range c.NoiseFloor {
+		floors = append(floors, floor)
+	}
+	if len(floors) == 0 {
+		return GainOffsets{}
+	}
+	mid := median(floors)
+
+	offsets := make(GainOffsets)
+	for channel, floor := range c.NoiseFloor {
+		offset := int(math.Round((mid - floor) * 10))
+		if offset > MaxGainOffset {
+			offset = MaxGainOffset
+		} else if offset < -MaxGainOffset {
+			offset = -MaxGainOffset
+		}
+		if offset != 0 {
+			offsets[channel] = offset
+		}
+	}
+	return offsets
+}
+
+// String formats the suggested offsets as read by ReadGainOffsets, each
+// channel's noise floor in a comment.
+func (c Calibration) String() string {
+	channels := make([]int, 0, len(c.NoiseFloor))
+	for channel := range c.NoiseFloor {
+		channels = append(channels, channel)
+	}
+	sort.Ints(channels)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Gain offsets in dB measured at %.1f dB, channel noise floors:\n", float64(c.Gain)/10)
+	for _, channel := range channels {
+		fmt.Fprintf(&b, "#   %2d %6.1f dBFS\n", channel, c.NoiseFloor[channel])
+	}
+	b.WriteString(c.Offsets().String())
+	return b.String()
+}
@@ -0,0 +1,85 @@
+package receiver
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/bemasher/rtldavis/protocol"
+)
+
+// A device producing noise at a level set per frequency.
+type noiseDevice struct {
+	rng    *rand.Rand
+	levels map[int]float64
+	level  float64
+	gain   int
+}
+
+func (d *noiseDevice) Read(buf []byte) (int, error) {
+	for idx := range buf {
+		v := 127.5 + d.level*d.rng.NormFloat64()
+		buf[idx] = byte(math.Max(0, math.Min(255, math.Round(v))))
+	}
+	return len(buf), nil
+}
+
+func (d *noiseDevice) SetCenterFreq(freq int) error {
+	d.level = d.levels[freq]
+	return nil
+}
+
+func (d *noiseDevice) SetGain(gain int) error {
+	d.gain = gain
+	return nil
+}
+
+func TestCalibrate(t *testing.T) {
+	p := protocol.NewParser(14, 0)
+	dev := &noiseDevice{rng: rand.New(rand.NewSource(1)), levels: make(map[int]float64)}
+
+	// Channel 0 is received 6 dB down, channel 1 3 dB up.
+	for hopIdx := 0; hopIdx < p.ChannelCount(); hopIdx++ {
+		hop := p.SetHop(hopIdx)
+		level := 10.0
+		switch hop.ChannelIdx {
+		case 0:
+			level /= 2
+		case 1:
+			level *= math.Sqrt2
+		}
+		dev.levels[hop.ChannelFreq+hop.FreqError] = level
+	}
+
+	c, err := Calibrate(context.Background(), &p, dev, CalibrationConfig{Gain: 402, Duration: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dev.gain != 402 || c.Gain != 402 || len(c.NoiseFloor) != p.ChannelCount() {
+		t.Fatalf("got gain %d, %+v", dev.gain, c)
+	}
+
+	offsets := c.Offsets()
+	if math.Abs(float64(offsets[0]-60)) > 2 || math.Abs(float64(offsets[1]+30)) > 2 {
+		t.Fatalf("offsets: got %v", offsets)
+	}
+	for channel, offset := range offsets {
+		if channel > 1 && (offset < -2 || offset > 2) {
+			t.Fatalf("channel %d: got offset %d", channel, offset)
+		}
+	}
+}
+
+func TestCalibrateNoGain(t *testing.T) {
+	p := protocol.NewParser(14, 0)
+	dev := &noiseDevice{rng: rand.New(rand.NewSource(1))}
+
+	if _, err := Calibrate(context.Background(), &p, dev, CalibrationConfig{}); err != ErrNoGain {
+		t.Fatalf("got %v, want ErrNoGain", err)
+	}
+	if _, err := Calibrate(context.Background(), &p, NewFileSource(nil), CalibrationConfig{Gain: 402}); err != ErrNoGain {
+		t.Fatalf("got %v, want ErrNoGain", err)
+	}
+}
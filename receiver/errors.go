@@ -0,0 +1,48 @@
+package receiver
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrDeviceNotFound is returned when no device is attached, or none
+	// matches the requested index or serial.
+	ErrDeviceNotFound = errors.New("receiver: device not found")
+
+	// ErrTunerUnsupported is returned when the device's tuner can't tune the
+	// station's band.
+	ErrTunerUnsupported = errors.New("receiver: tuner unsupported")
+
+	// ErrSampleDropped is returned by a Device's Read when samples were lost
+	// because they weren't read quickly enough. The stream is discontinuous
+	// but reading may continue.
+	ErrSampleDropped = errors.New("receiver: samples dropped")
+
+	// ErrReconnected is returned by a Device's Read when it lost its
+	// connection to a remote device and re-established it. As after a drop
+	// the stream is discontinuous, but the gap may be long.
+	ErrReconnected = errors.New("receiver: device reconnected")
+
+	// ErrNoGain is returned when asked to calibrate without a fixed gain or
+	// on a device whose gain can't be set.
+	ErrNoGain = errors.New("receiver: calibration needs a fixed gain")
+
+	// ErrNoTransmitters is returned when asked to follow no transmitters.
+	ErrNoTransmitters = errors.New("receiver: no transmitters")
+)
+
+// DeviceError records a failed device operation and the error the driver
+// returned for it.
+type DeviceError struct {
+	Op  string
+	Err error
+}
+
+func (e *DeviceError) Error() string {
+	return fmt.Sprintf("device: %s: %v", e.Op, e.Err)
+}
+
+func (e *DeviceError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,28 @@
+package receiver
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestDeviceError(t *testing.T) {
+	var err error = &DeviceError{Op: "read", Err: ErrSampleDropped}
+	err = fmt.Errorf("receiving: %w", err)
+
+	if !errors.Is(err, ErrSampleDropped) {
+		t.Fatalf("expected %v to match %v", err, ErrSampleDropped)
+	}
+	if errors.Is(err, ErrDeviceNotFound) {
+		t.Fatalf("unexpected match of %v", ErrDeviceNotFound)
+	}
+
+	var devErr *DeviceError
+	if !errors.As(err, &devErr) || devErr.Op != "read" {
+		t.Fatalf("expected DeviceError with op read: %v", err)
+	}
+
+	if got, want := devErr.Error(), "device: read: receiver: samples dropped"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
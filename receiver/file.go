@@ -0,0 +1,77 @@
+package receiver
+
+import (
+	"io"
+	"time"
+)
+
+// FileSource is a Device reading samples captured with rtl_sdr: 8-bit
+// unsigned interleaved IQ. Retuning is recorded but otherwise has no effect,
+// the capture plays back whatever it was recorded on.
+type FileSource struct {
+	r io.Reader
+
+	// Bytes per second the capture was recorded at, zero reads as fast as
+	// possible.
+	rate float64
+
+	start time.Time
+	read  int64
+	due   time.Time
+
+	freq int
+}
+
+// NewFileSource returns a source reading from r as fast as possible.
+func NewFileSource(r io.Reader) *FileSource {
+	return &FileSource{r: r}
+}
+
+// SetRealtime paces reads to the given sample rate so a capture plays back
+// with the timing it was recorded with. A rate of zero disables pacing.
+func (f *FileSource) SetRealtime(sampleRate int) {
+	f.rate = float64(sampleRate) * 2
+	f.start = time.Time{}
+	f.read = 0
+	f.due = time.Time{}
+}
+
+func (f *FileSource) Read(buf []byte) (int, error) {
+	if f.rate == 0 {
+		return f.r.Read(buf)
+	}
+
+	if f.start.IsZero() {
+		f.start = time.Now()
+	}
+
+	n, err := f.r.Read(buf)
+	f.read += int64(n)
+
+	// Hold samples until the time they would have been read from a device.
+	f.due = f.start.Add(time.Duration(float64(f.read) / f.rate * float64(time.Second)))
+	if wait := time.Until(f.due); wait > 0 {
+		time.Sleep(wait)
+	}
+
+	return n, err
+}
+
+// CaptureTime returns the time the last sample read would have been captured
+// by a device during realtime playback, otherwise the zero time.
+func (f *FileSource) CaptureTime() time.Time {
+	if f.rate == 0 {
+		return time.Time{}
+	}
+	return f.due
+}
+
+func (f *FileSource) SetCenterFreq(freq int) error {
+	f.freq = freq
+	return nil
+}
+
+// CenterFreq returns the frequency last tuned to.
+func (f *FileSource) CenterFreq() int {
+	return f.freq
+}
@@ -0,0 +1,52 @@
+package receiver
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestFileSource(t *testing.T) {
+	capture := make([]byte, 4000)
+	for idx := range capture {
+		capture[idx] = byte(idx)
+	}
+
+	f := NewFileSource(bytes.NewReader(capture))
+	got, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, capture) {
+		t.Fatal("capture was not read back unchanged")
+	}
+
+	if err := f.SetCenterFreq(902419338); err != nil || f.CenterFreq() != 902419338 {
+		t.Fatalf("center frequency: got %d, %v", f.CenterFreq(), err)
+	}
+}
+
+func TestFileSourceRealtime(t *testing.T) {
+	// 100ms of samples at 10 kHz.
+	const sampleRate = 10000
+	capture := make([]byte, 2*sampleRate/10)
+
+	f := NewFileSource(bytes.NewReader(capture))
+	f.SetRealtime(sampleRate)
+
+	start := time.Now()
+	block := make([]byte, 200)
+	for {
+		if _, err := io.ReadFull(f, block); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Fatalf("expected playback to take 100ms, took %s", elapsed)
+	}
+}
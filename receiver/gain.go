@@ -0,0 +1,80 @@
+package receiver
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// GainSetter is implemented by devices whose tuner gain can be fixed rather
+// than left automatic. SetGain gives gain in tenths of a dB, as the rtl-sdr
+// does. Tuners only have a few gain steps, the nearest one is used.
+type GainSetter interface {
+	SetGain(gain int) error
+}
+
+// GainOffsets adjust the tuner gain for each channel index, in tenths of a
+// dB, for front ends whose response isn't flat across the band. Channels
+// without an offset are received at the base gain.
+type GainOffsets map[int]int
+
+// Gain returns the gain to receive channel at with base gain base.
+func (o GainOffsets) Gain(base, channel int) int {
+	if gain := base + o[channel]; gain > 0 {
+		return gain
+	}
+	return 0
+}
+
+// ReadGainOffsets reads a table of gain offsets as written by String: one
+// channel index and offset in dB per line. Blank lines and those starting
+// with '#' are ignored.
+func ReadGainOffsets(r io.Reader) (GainOffsets, error) {
+	offsets := make(GainOffsets)
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("gain offsets line %d: expected channel and offset, got %q", line, text)
+		}
+		channel, err := strconv.Atoi(fields[0])
+		if err != nil || channel < 0 {
+			return nil, fmt.Errorf("gain offsets line %d: invalid channel %q", line, fields[0])
+		}
+		offset, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("gain offsets line %d: invalid offset %q", line, fields[1])
+		}
+		offsets[channel] = int(math.Round(offset * 10))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return offsets, nil
+}
+
+// String formats the offsets as read by ReadGainOffsets, ordered by channel.
+func (o GainOffsets) String() string {
+	channels := make([]int, 0, len(o))
+	for channel := range o {
+		channels = append(channels, channel)
+	}
+	sort.Ints(channels)
+
+	var b strings.Builder
+	for _, channel := range channels {
+		fmt.Fprintf(&b, "%d %+.1f\n", channel, float64(o[channel])/10)
+	}
+	return b.String()
+}
@@ -0,0 +1,97 @@
+package receiver
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bemasher/rtldavis/protocol"
+)
+
+func TestReadGainOffsets(t *testing.T) {
+	table := `
+# Measured at 40.2 dB.
+3 +1.5
+0 -0.25
+
+12 6
+`
+	offsets, err := ReadGainOffsets(strings.NewReader(table))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := GainOffsets{0: -3, 3: 15, 12: 60}
+	if !reflect.DeepEqual(offsets, want) {
+		t.Fatalf("got %v, want %v", offsets, want)
+	}
+
+	// Written tables read back the same.
+	if got := offsets.String(); got != "0 -0.3\n3 +1.5\n12 +6.0\n" {
+		t.Fatalf("string: got %q", got)
+	}
+	if again, err := ReadGainOffsets(strings.NewReader(offsets.String())); err != nil || !reflect.DeepEqual(again, want) {
+		t.Fatalf("read back: got %v, %v", again, err)
+	}
+
+	for _, bad := range []string{"3", "3 +1.5 2", "-1 2", "x 2", "3 y"} {
+		if _, err := ReadGainOffsets(strings.NewReader(bad)); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
+
+func TestGainOffsetsGain(t *testing.T) {
+	offsets := GainOffsets{1: 15, 2: -500}
+	for channel, want := range map[int]int{0: 400, 1: 415, 2: 0} {
+		if got := offsets.Gain(400, channel); got != want {
+			t.Errorf("channel %d: got %d, want %d", channel, got, want)
+		}
+	}
+}
+
+// A device that records gains set, and ends once it has been tuned.
+type gainDevice struct {
+	gains []int
+	tuned chan struct{}
+}
+
+func (d *gainDevice) Read(buf []byte) (int, error) {
+	select {
+	case <-d.tuned:
+	case <-time.After(time.Second):
+	}
+	return 0, io.EOF
+}
+
+func (d *gainDevice) SetCenterFreq(freq int) error {
+	close(d.tuned)
+	return nil
+}
+
+func (d *gainDevice) SetGain(gain int) error {
+	d.gains = append(d.gains, gain)
+	return nil
+}
+
+// The tuner gain is set with the channel's offset before tuning to it.
+func TestReceiverGain(t *testing.T) {
+	p := protocol.NewParser(14, 0)
+	offsets := make(GainOffsets)
+	for channel := 0; channel < p.ChannelCount(); channel++ {
+		offsets[channel] = 2*channel - 50
+	}
+
+	dev := &gainDevice{tuned: make(chan struct{})}
+	r := New(&p, dev, Config{IDs: []int{0}, Gain: 400, GainOffsets: offsets})
+	if err := r.Run(context.Background()); err != io.EOF {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+
+	channel := r.Stats().Channel
+	if want := []int{offsets.Gain(400, channel)}; !reflect.DeepEqual(dev.gains, want) {
+		t.Fatalf("gains on channel %d: got %v, want %v", channel, dev.gains, want)
+	}
+}
@@ -0,0 +1,81 @@
+package receiver
+
+import (
+	"fmt"
+	"time"
+)
+
+// LatencyBuckets are the upper bounds of Latency's buckets.
+var LatencyBuckets = [...]time.Duration{
+	1 * time.Millisecond,
+	2 * time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	20 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	200 * time.Millisecond,
+	500 * time.Millisecond,
+	1 * time.Second,
+	2 * time.Second,
+	5 * time.Second,
+}
+
+// Latency is a histogram of the time between a packet's last sample being
+// captured and the decoded message being delivered.
+type Latency struct {
+	// Observations per bucket, the last counts those above every bucket.
+	Buckets [len(LatencyBuckets) + 1]int
+
+	Count    int
+	Sum, Max time.Duration
+}
+
+func (l *Latency) Observe(d time.Duration) {
+	idx := 0
+	for idx < len(LatencyBuckets) && d > LatencyBuckets[idx] {
+		idx++
+	}
+	l.Buckets[idx]++
+
+	l.Count++
+	l.Sum += d
+	if d > l.Max {
+		l.Max = d
+	}
+}
+
+func (l Latency) Mean() time.Duration {
+	if l.Count == 0 {
+		return 0
+	}
+	return l.Sum / time.Duration(l.Count)
+}
+
+// Quantile returns the upper bound of the bucket the q'th quantile falls in,
+// or Max if it's above every bucket.
+func (l Latency) Quantile(q float64) time.Duration {
+	if l.Count == 0 {
+		return 0
+	}
+
+	rank := int(q*float64(l.Count) + 0.5)
+	if rank < 1 {
+		rank = 1
+	}
+
+	seen := 0
+	for idx, count := range l.Buckets[:len(LatencyBuckets)] {
+		seen += count
+		if seen >= rank {
+			return LatencyBuckets[idx]
+		}
+	}
+	return l.Max
+}
+
+func (l Latency) String() string {
+	return fmt.Sprintf("{Mean:%s P50:%s P99:%s Max:%s}",
+		l.Mean().Round(time.Microsecond), l.Quantile(0.5), l.Quantile(0.99), l.Max.Round(time.Microsecond),
+	)
+}
@@ -0,0 +1,35 @@
+package receiver
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLatency(t *testing.T) {
+	var l Latency
+	if l.Mean() != 0 || l.Quantile(0.5) != 0 {
+		t.Fatalf("empty histogram: %s", l)
+	}
+
+	for n := 0; n < 98; n++ {
+		l.Observe(3 * time.Millisecond)
+	}
+	l.Observe(150 * time.Millisecond)
+	l.Observe(10 * time.Second)
+
+	if l.Count != 100 || l.Buckets[2] != 98 || l.Buckets[7] != 1 || l.Buckets[len(LatencyBuckets)] != 1 {
+		t.Fatalf("unexpected buckets: %+v", l)
+	}
+	if got := l.Quantile(0.5); got != 5*time.Millisecond {
+		t.Errorf("p50: got %s, want 5ms", got)
+	}
+	if got := l.Quantile(0.99); got != 200*time.Millisecond {
+		t.Errorf("p99: got %s, want 200ms", got)
+	}
+	if got := l.Quantile(1); got != 10*time.Second {
+		t.Errorf("p100: got %s, want max", got)
+	}
+	if l.Max != 10*time.Second {
+		t.Errorf("max: got %s", l.Max)
+	}
+}
@@ -0,0 +1,32 @@
+package receiver
+
+import (
+	"sync"
+
+	"github.com/bemasher/rtldavis/protocol"
+)
+
+// Merge fans the messages of several receivers into one channel, which is
+// closed once every receiver's channel has been closed. Messages are tagged
+// with their receiver's Source and region so they can still be told apart.
+func Merge(receivers ...*Receiver) <-chan protocol.Message {
+	merged := make(chan protocol.Message, 16)
+
+	var wg sync.WaitGroup
+	wg.Add(len(receivers))
+	for _, r := range receivers {
+		go func(msgs <-chan protocol.Message) {
+			defer wg.Done()
+			for msg := range msgs {
+				merged <- msg
+			}
+		}(r.Messages())
+	}
+
+	go func() {
+		wg.Wait()
+		close(merged)
+	}()
+
+	return merged
+}
@@ -0,0 +1,29 @@
+package receiver
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/bemasher/rtldavis/protocol"
+)
+
+func TestMerge(t *testing.T) {
+	us := &Receiver{msgs: make(chan protocol.Message, 2)}
+	eu := &Receiver{msgs: make(chan protocol.Message, 2)}
+
+	us.msgs <- protocol.Message{Source: "0", Region: "us"}
+	us.msgs <- protocol.Message{Source: "0", Region: "us"}
+	eu.msgs <- protocol.Message{Source: "1", Region: "eu"}
+	close(us.msgs)
+	close(eu.msgs)
+
+	var regions []string
+	for msg := range Merge(us, eu) {
+		regions = append(regions, msg.Region)
+	}
+
+	sort.Strings(regions)
+	if len(regions) != 3 || regions[0] != "eu" || regions[2] != "us" {
+		t.Fatalf("got %v", regions)
+	}
+}
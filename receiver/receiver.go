@@ -0,0 +1,657 @@
+// Package receiver drives a tuner through the Davis hop pattern and delivers
+// the messages it receives.
+package receiver
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/bemasher/rtldavis/clock"
+	"github.com/bemasher/rtldavis/dsp"
+	"github.com/bemasher/rtldavis/protocol"
+)
+
+// Device is a source of 8-bit interleaved IQ samples at the parser's
+// DeviceSampleRate that can be retuned while being read.
+type Device interface {
+	io.Reader
+	SetCenterFreq(freq int) error
+}
+
+// CaptureTimer is implemented by devices that know when samples were
+// captured. CaptureTime returns the time the last sample returned by Read was
+// captured, or the zero time if unknown.
+type CaptureTimer interface {
+	CaptureTime() time.Time
+}
+
+// RateSetter is implemented by devices whose sample rate can change while
+// being read.
+type RateSetter interface {
+	SetSampleRate(rate int) error
+}
+
+// ConnectionReporter is implemented by remote devices, Connected reports
+// whether the device is currently connected to its server.
+type ConnectionReporter interface {
+	Connected() bool
+}
+
+type Config struct {
+	// Source names the device, every message received is tagged with it.
+	Source string
+
+	// Transmitter ids to follow. If empty, the receiver listens for any
+	// transmitter for DiscoveryTime and follows every id it hears.
+	IDs []int
+
+	// How long to discover transmitters for. Discovery continues past this
+	// until at least one transmitter has been heard.
+	DiscoveryTime time.Duration
+
+	// Continuity, if set, checks each message against the previous one from
+	// its transmitter. Anomalies are logged, and dropped if
+	// RejectOffSchedule is set.
+	Continuity        *protocol.Continuity
+	RejectOffSchedule bool
+
+	// Downgrade lowers the sample rate, see protocol.Parser.Downgrade, when
+	// the device drops samples more than DropLimit times within DropWindow.
+	// The device must implement RateSetter.
+	Downgrade  bool
+	DropLimit  int
+	DropWindow time.Duration
+
+	// SettleTime is how long after each retune samples are discarded while
+	// the tuner settles. If AutoSettle is set, the settle time starts from
+	// SettleTime and is refined by measuring how long block power takes to
+	// stabilize after each retune.
+	SettleTime time.Duration
+	AutoSettle bool
+
+	// Gain, if positive, fixes the tuner gain at this many tenths of a dB
+	// rather than leaving it automatic, adjusted for each channel by
+	// GainOffsets, see Calibrate. The device must implement GainSetter.
+	Gain        int
+	GainOffsets GainOffsets
+
+	// TrackTiming refines each transmitter's hop timing from the arrival
+	// times of its messages, keeping listening windows centered on its real
+	// schedule despite clock drift at either end. Otherwise each message
+	// heard anchors the next window and the nominal dwell time is assumed.
+	TrackTiming bool
+
+	// VerifyHops logs, once, if the channels messages arrive on don't follow
+	// the parser's hop pattern, see protocol.HopVerifier.
+	VerifyHops bool
+
+	// AutoInvert toggles the demodulator's SpectrumInverted every
+	// InvertAfter until a packet passes its CRC, then keeps whichever
+	// setting received it. Noise matches the preamble either way, only a
+	// valid packet tells the settings apart. InvertAfter defaults to the
+	// time spent waiting for sync on a single channel.
+	AutoInvert  bool
+	InvertAfter time.Duration
+
+	// Log receives verbose information about hops and discovery. Discarded
+	// if nil.
+	Log *log.Logger
+
+	// Clock times hops, discovery, drops and delivery. Defaults to the
+	// system clock.
+	Clock clock.Clock
+}
+
+// DefaultDiscoveryTime is long enough to catch each transmitter a few times
+// while following the first one heard.
+const DefaultDiscoveryTime = 30 * time.Second
+
+// Occasional drops are tolerated, continuous ones aren't.
+const (
+	DefaultDropLimit  = 5
+	DefaultDropWindow = 10 * time.Second
+)
+
+type Receiver struct {
+	p   *protocol.Parser
+	dev Device
+	cfg Config
+
+	sched  *scheduler
+	survey *protocol.Survey
+	settle *settler
+
+	// Nil unless verifying hops, and whether a mismatch was reported.
+	hopVerifier  *protocol.HopVerifier
+	hopsReported bool
+
+	// While detecting spectrum inversion, when to next toggle it.
+	inverting  bool
+	invertNext time.Time
+
+	msgs chan protocol.Message
+	hops chan protocol.Hop
+
+	// Signalled when UpdateTransmitters has left ids in pendingIDs.
+	reload chan struct{}
+
+	discovering bool
+	start       time.Time
+
+	// Times of drops within the last DropWindow.
+	drops []time.Time
+
+	mu    sync.Mutex
+	stats Stats
+
+	// Eyes of the last EyeWindow valid packets, oldest at nextEye once full.
+	eyes    []dsp.Eye
+	nextEye int
+
+	pendingIDs []int
+}
+
+// New returns a receiver reading from dev. The parser's configuration must
+// match the device's sample rate.
+func New(p *protocol.Parser, dev Device, cfg Config) *Receiver {
+	if cfg.Log == nil {
+		cfg.Log = log.New(ioutil.Discard, "", 0)
+	}
+	if cfg.DiscoveryTime == 0 {
+		cfg.DiscoveryTime = DefaultDiscoveryTime
+	}
+	if cfg.DropLimit == 0 {
+		cfg.DropLimit = DefaultDropLimit
+	}
+	if cfg.DropWindow == 0 {
+		cfg.DropWindow = DefaultDropWindow
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = clock.Real
+	}
+
+	var hopVerifier *protocol.HopVerifier
+	if cfg.VerifyHops {
+		hopVerifier = protocol.NewHopVerifier(p.Region)
+	}
+
+	r := &Receiver{
+		p:           p,
+		dev:         dev,
+		cfg:         cfg,
+		hopVerifier: hopVerifier,
+		sched:       newScheduler(cfg.IDs, p.ChannelCount()),
+		survey:      protocol.NewSurvey(),
+		settle:      newSettler(cfg.SettleTime, cfg.AutoSettle),
+		msgs:        make(chan protocol.Message, 16),
+		hops:        make(chan protocol.Hop, 1),
+		reload:      make(chan struct{}, 1),
+		inverting:   cfg.AutoInvert,
+		stats: Stats{
+			Channel:          -1,
+			SampleRate:       p.Cfg.DeviceSampleRate,
+			SpectrumInverted: p.Cfg.SpectrumInverted,
+			IDPackets:        make(map[int]int),
+			ChannelPackets:   make(map[int]int),
+		},
+	}
+
+	r.sched.track = cfg.TrackTiming
+
+	if r.cfg.InvertAfter == 0 {
+		r.cfg.InvertAfter = r.sched.syncWait(p.DwellTime)
+	}
+	return r
+}
+
+// Messages returns the channel messages from followed transmitters are
+// delivered on. It is closed when Run returns.
+func (r *Receiver) Messages() <-chan protocol.Message {
+	return r.msgs
+}
+
+// Survey returns what was heard during discovery.
+func (r *Receiver) Survey() []protocol.TransmitterSurvey {
+	return r.survey.Results()
+}
+
+// UpdateTransmitters replaces the transmitter ids the receiver follows, safe
+// to call while the receiver is running. Ids that remain keep their hop sync
+// so reception from them carries on uninterrupted, new ids are listened for
+// as during discovery. The parser's and Config.Continuity's state for removed
+// ids is forgotten and their limits follow the new ids. Stops discovery if it
+// is still running.
+func (r *Receiver) UpdateTransmitters(ids []int) error {
+	if len(ids) == 0 {
+		return ErrNoTransmitters
+	}
+
+	r.mu.Lock()
+	r.pendingIDs = append([]int(nil), ids...)
+	r.mu.Unlock()
+
+	select {
+	case r.reload <- struct{}{}:
+	default:
+		// Already signalled, the latest ids are applied.
+	}
+	return nil
+}
+
+// updateTransmitters applies the ids left by UpdateTransmitters.
+func (r *Receiver) updateTransmitters() {
+	r.mu.Lock()
+	ids := r.pendingIDs
+	r.pendingIDs = nil
+	r.mu.Unlock()
+	if ids == nil {
+		return
+	}
+
+	added, removed := r.sched.set(ids)
+	r.cfg.IDs = r.sched.ids()
+	r.discovering = false
+
+	limit := r.p.StateLimit()
+	limit.IDs = r.cfg.IDs
+	r.p.SetStateLimit(limit)
+	if c := r.cfg.Continuity; c != nil {
+		limit := c.Limit()
+		limit.IDs = r.cfg.IDs
+		c.SetLimit(limit)
+	}
+
+	r.cfg.Log.Printf("Following transmitters %v, added %v, removed %v\n", r.cfg.IDs, added, removed)
+}
+
+// Run receives until the context is cancelled or the device fails.
+func (r *Receiver) Run(ctx context.Context) error {
+	defer close(r.msgs)
+
+	// Handle frequency hops concurrently since the device's read callback
+	// will stall if we stop reading to hop. Keep draining hops after an error
+	// so the main loop never blocks on a hop before it sees the error.
+	tuneErr := make(chan error, 1)
+	gs := r.gainSetter()
+	go func() {
+		failed := false
+		gain := 0
+		for hop := range r.hops {
+			if failed {
+				continue
+			}
+			r.cfg.Log.Printf("Hop: %s\n", hop)
+
+			// Before retuning, so the tuner settles from both at once.
+			if g := r.cfg.GainOffsets.Gain(r.cfg.Gain, hop.ChannelIdx); gs != nil && g != gain {
+				if err := gs.SetGain(g); err != nil {
+					tuneErr <- err
+					failed = true
+					continue
+				}
+				gain = g
+			}
+
+			if err := r.dev.SetCenterFreq(hop.ChannelFreq + hop.FreqError); err != nil {
+				tuneErr <- err
+				failed = true
+				continue
+			}
+			r.settle.retuned(r.cfg.Clock.Now())
+		}
+	}()
+	defer close(r.hops)
+
+	r.discovering = len(r.cfg.IDs) == 0
+	if r.discovering {
+		r.cfg.Log.Printf("Discovering transmitters for %s\n", r.cfg.DiscoveryTime)
+	}
+	r.start = r.cfg.Clock.Now()
+	r.update(func(s *Stats) { s.Start = r.start })
+	r.invertNext = r.start.Add(r.cfg.InvertAfter)
+
+	block := make([]byte, r.p.Cfg.DeviceBlockSize2)
+	timer := r.retune(r.start)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-tuneErr:
+			return err
+		case <-r.reload:
+			r.updateTransmitters()
+			timer = r.retune(r.cfg.Clock.Now())
+		case <-timer:
+			// If the timer has expired one of two things has happened:
+			//     1: We've missed a message from the transmitter we were
+			//        waiting on.
+			//     2: We've waited for sync and nothing has happened for a
+			//        full cycle of the pattern.
+			now := r.cfg.Clock.Now()
+			r.sched.expire(now)
+			timer = r.retune(now)
+		default:
+			n, err := io.ReadFull(r.dev, block)
+			if errors.Is(err, ErrReconnected) {
+				// Pick up the transmitters being followed where their
+				// schedules have got to rather than waiting for sync.
+				r.cfg.Log.Println(err)
+				r.p.Demodulator.Reset()
+				r.update(func(s *Stats) { s.Reconnects++ })
+
+				now := r.cfg.Clock.Now()
+				r.sched.resume(now)
+				timer = r.retune(now)
+				continue
+			} else if errors.Is(err, ErrSampleDropped) {
+				// Samples buffered from before the gap can't be joined to
+				// those after it.
+				r.cfg.Log.Println(err)
+				r.p.Demodulator.Reset()
+				r.update(func(s *Stats) { s.Drops++ })
+
+				if r.overloaded(r.cfg.Clock.Now()) {
+					if err := r.downgrade(); err != nil {
+						return err
+					}
+					block = make([]byte, r.p.Cfg.DeviceBlockSize2)
+				}
+				continue
+			} else if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return r.flush(ctx, block, n)
+			} else if err != nil {
+				return err
+			}
+
+			now := r.cfg.Clock.Now()
+			captured := now
+			if ct, ok := r.dev.(CaptureTimer); ok {
+				if t := ct.CaptureTime(); !t.IsZero() {
+					captured = t
+				}
+			}
+
+			if r.settle.skip(block, captured, r.p.Cfg.DeviceSampleRate) {
+				r.update(func(s *Stats) { s.Unsettled++ })
+				continue
+			}
+
+			recvPacket, err := r.receive(ctx, block, now, captured)
+			if err != nil {
+				return err
+			}
+
+			if recvPacket {
+				timer = r.retune(now)
+			}
+			r.detectInversion(now)
+		}
+	}
+}
+
+// receive demodulates a block whose last sample was captured at captured and
+// delivers messages from the transmitters being followed. Returns true if any
+// were delivered.
+func (r *Receiver) receive(ctx context.Context, block []byte, now, captured time.Time) (recvPacket bool, err error) {
+	msgs := r.p.Parse(r.p.Demodulate(block))
+	r.update(func(s *Stats) { s.CRCFailures = r.p.CRCFailures })
+
+	cfg := r.p.Cfg
+	for _, msg := range msgs {
+		// Time the packet's last sample was captured, it's followed by the
+		// rest of the buffer.
+		after := cfg.BufferLength - (msg.Idx + cfg.PacketLength)
+		msg.Time = captured.Add(-time.Duration(after) * time.Second / time.Duration(cfg.SampleRate))
+		msg.Source = r.cfg.Source
+		id := int(msg.ID)
+
+		// Packets failing their CRC are only included for debugging, they
+		// say nothing reliable about who sent them.
+		if !msg.CRCValid && !msg.Corrected {
+			if err := r.deliver(ctx, msg); err != nil {
+				return recvPacket, err
+			}
+			continue
+		}
+
+		if msg.CRCValid && r.inverting {
+			r.inverting = false
+			if r.p.Cfg.SpectrumInverted {
+				log.Printf("%sreceiving with the spectrum inverted, the device needs -invert", r.logPrefix())
+			}
+		}
+
+		if msg.CRCValid {
+			r.observeEye(msg.Eye)
+		}
+		r.verifyHops(msg)
+
+		if r.discovering {
+			r.survey.Add(msg)
+			if r.sched.add(id) {
+				r.cfg.Log.Printf("Discovered transmitter %d\n", id)
+			}
+		}
+
+		if r.sched.lookup(id) == nil {
+			continue
+		}
+
+		if r.cfg.Continuity != nil {
+			if err := r.cfg.Continuity.Check(msg); err != nil {
+				r.cfg.Log.Println(err)
+				if r.cfg.RejectOffSchedule {
+					r.update(func(s *Stats) { s.Rejected++ })
+					continue
+				}
+			}
+		}
+
+		// Tracking needs the packet's arrival, not when its block was
+		// read.
+		arrival := now
+		if r.cfg.TrackTiming {
+			arrival = msg.Time
+		}
+		r.sched.received(id, r.p.HopIdx(), arrival)
+		recvPacket = true
+		r.update(func(s *Stats) {
+			s.Packets++
+			s.IDPackets[id]++
+			s.ChannelPackets[msg.ChannelIdx]++
+		})
+
+		if err := r.deliver(ctx, msg); err != nil {
+			return recvPacket, err
+		}
+	}
+
+	if r.discovering && now.Sub(r.start) >= r.cfg.DiscoveryTime && len(r.sched.txs) > 0 {
+		r.discovering = false
+		r.cfg.Log.Printf("Following transmitters %v\n", r.sched.ids())
+	}
+
+	return recvPacket, nil
+}
+
+// deliver sends a message to the consumer.
+func (r *Receiver) deliver(ctx context.Context, msg protocol.Message) error {
+	select {
+	case r.msgs <- msg:
+		r.update(func(s *Stats) { s.Latency.Observe(r.cfg.Clock.Now().Sub(msg.Time)) })
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// flush demodulates what's left at the end of a stream: the first n bytes of
+// block, then enough silence to push every buffered sample through so
+// packets ending right at the end are still found. Returns io.EOF.
+func (r *Receiver) flush(ctx context.Context, block []byte, n int) error {
+	// Samples are unsigned, silence is the middle of their range. Keep whole
+	// IQ pairs from the partial block.
+	n &^= 1
+	for idx := n; idx < len(block); idx++ {
+		block[idx] = 127
+	}
+
+	blocks := 1 + r.p.Cfg.BufferLength/r.p.Cfg.BlockSize
+	if n == 0 {
+		blocks--
+	}
+
+	for idx := 0; idx < blocks; idx++ {
+		now := r.cfg.Clock.Now()
+		if _, err := r.receive(ctx, block, now, now); err != nil {
+			return err
+		}
+		for idx := range block {
+			block[idx] = 127
+		}
+	}
+
+	return io.EOF
+}
+
+// retune hops to the channel of the next expected message and returns a
+// timer for its deadline. If no transmitter is synced we hop to a random
+// channel and wait there for a full hopping cycle.
+func (r *Receiver) retune(now time.Time) <-chan time.Time {
+	if t := r.sched.target(); t != nil {
+		if t.hopIdx != r.p.HopIdx() {
+			r.hop(r.p.SetHop(t.hopIdx))
+		}
+	} else if !now.Before(r.sched.wait) {
+		r.hop(r.p.SetHop(rand.Intn(r.p.ChannelCount())))
+		r.sched.wait = now.Add(r.sched.syncWait(r.p.DwellTime))
+	}
+
+	return r.cfg.Clock.After(r.sched.deadline().Sub(now))
+}
+
+func (r *Receiver) hop(hop protocol.Hop) {
+	r.hops <- hop
+	r.update(func(s *Stats) {
+		s.Hops++
+		s.Channel = hop.ChannelIdx
+		s.Frequency = hop.ChannelFreq + hop.FreqError
+	})
+}
+
+// gainSetter returns the device's GainSetter if a gain is configured. Always
+// logged if the device can't set its gain, it's a configuration mistake
+// that otherwise only shows as differences in capture rate.
+func (r *Receiver) gainSetter() GainSetter {
+	if r.cfg.Gain <= 0 {
+		return nil
+	}
+	gs, ok := r.dev.(GainSetter)
+	if !ok {
+		log.Printf("%sdevice's gain can't be set, leaving it automatic", r.logPrefix())
+	}
+	return gs
+}
+
+// overloaded records a drop and reports whether there have been too many
+// recently to carry on at the current sample rate.
+func (r *Receiver) overloaded(now time.Time) bool {
+	if !r.cfg.Downgrade {
+		return false
+	}
+
+	r.drops = append(r.drops, now)
+	for len(r.drops) > 0 && now.Sub(r.drops[0]) > r.cfg.DropWindow {
+		r.drops = r.drops[1:]
+	}
+	return len(r.drops) > r.cfg.DropLimit
+}
+
+// downgrade lowers the device's sample rate and retunes to the current
+// channel, or disables downgrading if the rate can't be lowered. Always
+// logged, a downgrade costs sensitivity.
+func (r *Receiver) downgrade() error {
+	r.drops = nil
+
+	rs, ok := r.dev.(RateSetter)
+	if !ok {
+		log.Printf("%sdropping samples, device's sample rate can't be lowered", r.logPrefix())
+		r.cfg.Downgrade = false
+		return nil
+	}
+
+	prev := r.p.Cfg
+	if !r.p.Downgrade() {
+		log.Printf("%sdropping samples at the lowest sample rate, %d Hz", r.logPrefix(), prev.DeviceSampleRate)
+		r.cfg.Downgrade = false
+		return nil
+	}
+
+	if err := rs.SetSampleRate(r.p.Cfg.DeviceSampleRate); err != nil {
+		return err
+	}
+	log.Printf("%sdropping samples, lowered sample rate from %d to %d Hz (decimation %d, symbol length %d, block size %d)",
+		r.logPrefix(), prev.DeviceSampleRate, r.p.Cfg.DeviceSampleRate,
+		r.p.Cfg.Decimation, r.p.Cfg.SymbolLength, r.p.Cfg.BlockSize,
+	)
+
+	r.update(func(s *Stats) {
+		s.Downgrades++
+		s.SampleRate = r.p.Cfg.DeviceSampleRate
+	})
+
+	// The tuned frequency depends on the sample rate.
+	r.hop(r.p.SetHop(r.p.HopIdx()))
+
+	return nil
+}
+
+// detectInversion toggles spectrum inversion if nothing valid was received
+// since the last toggle.
+func (r *Receiver) detectInversion(now time.Time) {
+	if !r.inverting || now.Before(r.invertNext) {
+		return
+	}
+
+	r.p.SetSpectrumInverted(!r.p.Cfg.SpectrumInverted)
+	r.invertNext = now.Add(r.cfg.InvertAfter)
+	r.cfg.Log.Printf("Nothing received, trying spectrum inverted: %t\n", r.p.Cfg.SpectrumInverted)
+
+	r.update(func(s *Stats) { s.SpectrumInverted = r.p.Cfg.SpectrumInverted })
+}
+
+// verifyHops scores a message against the hop pattern and logs the first
+// mismatch found. Always logged, a wrong hop table otherwise only shows as a
+// poor capture rate.
+func (r *Receiver) verifyHops(msg protocol.Message) {
+	if r.hopVerifier == nil || r.hopsReported {
+		return
+	}
+
+	r.hopVerifier.Add(msg)
+	if err := r.hopVerifier.Check(); err != nil {
+		log.Printf("%s%v", r.logPrefix(), err)
+		r.hopsReported = true
+	}
+}
+
+func (r *Receiver) logPrefix() string {
+	if r.cfg.Source == "" {
+		return ""
+	}
+	return r.cfg.Source + ": "
+}
+
+// update modifies the receiver's stats while holding its lock.
+func (r *Receiver) update(fn func(*Stats)) {
+	r.mu.Lock()
+	fn(&r.stats)
+	r.mu.Unlock()
+}
@@ -0,0 +1,350 @@
+package receiver
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bemasher/rtldavis/clock"
+	"github.com/bemasher/rtldavis/crc"
+	"github.com/bemasher/rtldavis/dsp"
+	"github.com/bemasher/rtldavis/protocol"
+)
+
+// Symbols of a packet carrying data, which gets its CRC appended: a short
+// preamble, the sync word and the message sent least significant bit first.
+func packetBits(data ...byte) (bits []byte) {
+	sum := crc.NewCRC("CCITT-16", 0, 0x1021, 0).Checksum(data)
+	data = append(data, byte(sum>>8), byte(sum))
+
+	for idx := 0; idx < 32; idx++ {
+		bits = append(bits, byte(idx&1))
+	}
+	for _, c := range "1100101110001001" {
+		bits = append(bits, byte(c-'0'))
+	}
+	for _, b := range data {
+		for bit := uint(0); bit < 8; bit++ {
+			bits = append(bits, b>>bit&1)
+		}
+	}
+
+	return bits
+}
+
+// Samples of silence.
+func silence(n int) []byte {
+	s := make([]byte, n)
+	for idx := range s {
+		s[idx] = 127
+	}
+	return s
+}
+
+func TestReceiverStats(t *testing.T) {
+	p := protocol.NewParser(14, 0)
+
+	// A capture with no signal in it.
+	capture := make([]byte, 16*p.Cfg.DeviceBlockSize2)
+	for idx := range capture {
+		capture[idx] = 127
+	}
+	dev := NewFileSource(bytes.NewReader(capture))
+
+	r := New(&p, dev, Config{IDs: []int{0}})
+	if s := r.Stats(); s.Uptime != 0 || s.Channel != -1 {
+		t.Fatalf("stats before running: %+v", s)
+	}
+
+	if err := r.Run(context.Background()); err != io.EOF {
+		t.Fatalf("expected EOF at end of capture, got %v", err)
+	}
+
+	s := r.Stats()
+	if s.Start.IsZero() || s.Packets != 0 {
+		t.Fatalf("unexpected stats: %+v", s)
+	}
+
+	// The receiver starts on a random channel while waiting for sync.
+	if s.Hops != 1 || s.Channel < 0 || s.Frequency != protocol.US.Channels[s.Channel] {
+		t.Fatalf("expected initial hop: %+v", s)
+	}
+
+	// Snapshots don't share maps with the receiver.
+	s.IDPackets[0]++
+	if r.Stats().IDPackets[0] != 0 {
+		t.Fatal("snapshot shares counters with the receiver")
+	}
+}
+
+// A packet ending in a partial block at the end of a stream must still be
+// delivered.
+func TestReceiverFlush(t *testing.T) {
+	p := protocol.NewParser(14, 0)
+
+	// Place the sync word mid-block, the packet ends in the stream's last
+	// block, which is partial.
+	capture := silence(4*p.Cfg.DeviceBlockSize2 + 2*314)
+	capture = append(capture, dsp.Modulate(p.Cfg, packetBits(0x80, 0x05, 0x60, 0x2E, 0xE0, 0x00), 9600)...)
+	if len(capture)%p.Cfg.DeviceBlockSize2 == 0 {
+		t.Fatal("capture should end in a partial block")
+	}
+
+	r := New(&p, NewFileSource(bytes.NewReader(capture)), Config{IDs: []int{0}})
+	if err := r.Run(context.Background()); err != io.EOF {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+
+	var msgs []protocol.Message
+	for msg := range r.Messages() {
+		msgs = append(msgs, msg)
+	}
+	if len(msgs) != 1 || msgs[0].Sensor != protocol.Temperature {
+		t.Fatalf("expected temperature message, got %v", msgs)
+	}
+
+	// Delivery is timed from when the packet was captured.
+	s := r.Stats()
+	if s.Latency.Count != 1 || s.Latency.Max < 0 {
+		t.Fatalf("expected one latency observation: %s", s.Latency)
+	}
+	if n := s.Eye.Count[0] + s.Eye.Count[1]; n != p.Cfg.PacketSymbols || s.Eye.Opening() <= 0 {
+		t.Fatalf("expected the packet's symbols in an open eye: %s", s.Eye)
+	}
+	if msgs[0].Time.IsZero() || msgs[0].Time.After(time.Now()) {
+		t.Fatalf("unexpected message time: %s", msgs[0].Time)
+	}
+}
+
+// With a fake clock message times, latency and uptime are exact.
+func TestReceiverClock(t *testing.T) {
+	p := protocol.NewParser(14, 0)
+
+	capture := silence(4*p.Cfg.DeviceBlockSize2 + 2*314)
+	capture = append(capture, dsp.Modulate(p.Cfg, packetBits(0x80, 0x05, 0x60, 0x2E, 0xE0, 0x00), 9600)...)
+
+	start := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	c := clock.NewFake(start)
+
+	r := New(&p, NewFileSource(bytes.NewReader(capture)), Config{IDs: []int{0}, Clock: c})
+	if err := r.Run(context.Background()); err != io.EOF {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+
+	msg, ok := <-r.Messages()
+	if !ok {
+		t.Fatal("expected a message")
+	}
+
+	// The packet was captured before the end of the buffer it was found in.
+	cfg := p.Cfg
+	after := time.Duration(cfg.BufferLength-(msg.Idx+cfg.PacketLength)) * time.Second / time.Duration(cfg.SampleRate)
+	if want := start.Add(-after); !msg.Time.Equal(want) {
+		t.Fatalf("message time: got %s, want %s", msg.Time, want)
+	}
+
+	s := r.Stats()
+	if !s.Start.Equal(start) || s.Uptime != 0 {
+		t.Fatalf("start: got %s after %s, want %s", s.Start, s.Uptime, start)
+	}
+	if s.Latency.Count != 1 || s.Latency.Max != after {
+		t.Fatalf("latency: got %s, want %s", s.Latency, after)
+	}
+
+	c.Advance(time.Minute)
+	if s := r.Stats(); s.Uptime != time.Minute {
+		t.Fatalf("uptime: got %s, want %s", s.Uptime, time.Minute)
+	}
+}
+
+// A device whose reads advance a fake clock by the time the samples took.
+type clockedDevice struct {
+	Device
+	clock *clock.Fake
+	rate  int
+}
+
+func (d clockedDevice) Read(buf []byte) (int, error) {
+	n, err := d.Device.Read(buf)
+	d.clock.Advance(time.Duration(n/2) * time.Second / time.Duration(d.rate))
+	return n, err
+}
+
+func TestReceiverAutoInvert(t *testing.T) {
+	p := protocol.NewParser(14, 0)
+
+	// An inverted dongle: Q negated about the center of the sample range.
+	capture := silence(30 * p.Cfg.DeviceBlockSize2)
+	capture = append(capture, dsp.Modulate(p.Cfg, packetBits(0x80, 0x05, 0x60, 0x2E, 0xE0, 0x00), 9600)...)
+	capture = append(capture, silence(4*p.Cfg.DeviceBlockSize2)...)
+	noise := rand.New(rand.NewSource(1))
+	for idx := range capture {
+		v := float64(capture[idx]) + 8*noise.NormFloat64()
+		if idx&1 == 1 {
+			v = 254.8 - v
+		}
+		capture[idx] = byte(math.Max(0, math.Min(255, math.Round(v))))
+	}
+
+	// The first toggle comes before the packet, the next after it.
+	c := clock.NewFake(time.Unix(1500000000, 0))
+	dev := clockedDevice{NewFileSource(bytes.NewReader(capture)), c, p.Cfg.DeviceSampleRate}
+	r := New(&p, dev, Config{IDs: []int{0}, Clock: c, AutoInvert: true, InvertAfter: 50 * time.Millisecond})
+
+	if err := r.Run(context.Background()); err != io.EOF {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+	if msg, ok := <-r.Messages(); !ok || msg.Sensor != protocol.Temperature {
+		t.Fatalf("expected the inverted packet to be received")
+	}
+	if s := r.Stats(); !s.SpectrumInverted || !p.Cfg.SpectrumInverted {
+		t.Fatalf("expected inversion to be kept: %+v", s)
+	}
+}
+
+// A device that drops samples on every read, then ends.
+type droppingDevice struct {
+	drops int
+	rates []int
+}
+
+func (d *droppingDevice) Read(buf []byte) (int, error) {
+	if d.drops == 0 {
+		return 0, io.EOF
+	}
+	d.drops--
+	return 0, &DeviceError{Op: "read", Err: ErrSampleDropped}
+}
+
+func (d *droppingDevice) SetCenterFreq(freq int) error {
+	return nil
+}
+
+func (d *droppingDevice) SetSampleRate(rate int) error {
+	d.rates = append(d.rates, rate)
+	return nil
+}
+
+func TestReceiverDowngrade(t *testing.T) {
+	for _, downgrade := range []bool{false, true} {
+		p := protocol.NewParser(14, 0)
+		p.SetDecimation(2)
+
+		dev := &droppingDevice{drops: 12}
+		r := New(&p, dev, Config{IDs: []int{0}, Downgrade: downgrade, DropLimit: 2})
+		if err := r.Run(context.Background()); err != io.EOF {
+			t.Fatalf("expected EOF, got %v", err)
+		}
+
+		s := r.Stats()
+		if !downgrade {
+			if len(dev.rates) != 0 || s.Downgrades != 0 || s.SampleRate != 2*268800 {
+				t.Fatalf("downgraded while disabled: %v, %+v", dev.rates, s)
+			}
+			continue
+		}
+
+		// Every third drop downgrades until the lowest rate is reached.
+		if len(dev.rates) != 2 || dev.rates[0] != 268800 || dev.rates[1] != 230400 {
+			t.Fatalf("unexpected sample rates: %v", dev.rates)
+		}
+		if s.Drops != 12 || s.Downgrades != 2 || s.SampleRate != 230400 {
+			t.Fatalf("unexpected stats: %+v", s)
+		}
+	}
+}
+
+// A device whose connection is re-established on every read, then ends.
+type reconnectingDevice struct {
+	droppingDevice
+}
+
+func (d *reconnectingDevice) Connected() bool {
+	return d.drops > 0
+}
+
+func (d *reconnectingDevice) Read(buf []byte) (int, error) {
+	if d.drops == 0 {
+		return 0, io.EOF
+	}
+	d.drops--
+	return 0, &DeviceError{Op: "read", Err: ErrReconnected}
+}
+
+// Reconnections are counted apart from drops and never downgrade. The lost
+// connection is reported once it has ended.
+func TestReceiverReconnect(t *testing.T) {
+	p := protocol.NewParser(14, 0)
+	dev := &reconnectingDevice{droppingDevice{drops: 5}}
+	r := New(&p, dev, Config{IDs: []int{0}, Downgrade: true, DropLimit: 2})
+	if err := r.Run(context.Background()); err != io.EOF {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+
+	if s := r.Stats(); s.Reconnects != 5 || !s.Disconnected || s.Drops != 0 || s.Downgrades != 0 || len(dev.rates) != 0 {
+		t.Fatalf("unexpected stats: %+v", s)
+	}
+}
+
+// Transmitters updated before or while running are followed, with the
+// parser's and continuity's state limited to them.
+func TestReceiverUpdateTransmitters(t *testing.T) {
+	p := protocol.NewParser(14, 0)
+	p.SetStateLimit(protocol.StateLimit{IDs: []int{1}, Expiry: time.Hour})
+
+	capture := silence(4 * p.Cfg.DeviceBlockSize2)
+	capture = append(capture, dsp.Modulate(p.Cfg, packetBits(0x80, 0x05, 0x60, 0x2E, 0xE0, 0x00), 9600)...)
+	capture = append(capture, silence(4*p.Cfg.DeviceBlockSize2)...)
+
+	cfg := Config{IDs: []int{1}, Continuity: p.NewContinuity(protocol.DefaultTolerance)}
+	cfg.Continuity.SetLimit(p.StateLimit())
+	r := New(&p, NewFileSource(bytes.NewReader(capture)), cfg)
+
+	if err := r.UpdateTransmitters(nil); err != ErrNoTransmitters {
+		t.Fatalf("no ids: got %v, want ErrNoTransmitters", err)
+	}
+	if err := r.UpdateTransmitters([]int{0}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Run(context.Background()); err != io.EOF {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+
+	var msgs []protocol.Message
+	for msg := range r.Messages() {
+		msgs = append(msgs, msg)
+	}
+	if len(msgs) != 1 || msgs[0].ID != 0 {
+		t.Fatalf("expected a message from transmitter 0, got %v", msgs)
+	}
+
+	want := protocol.StateLimit{IDs: []int{0}, Expiry: time.Hour}
+	if got := p.StateLimit(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("parser limit: got %+v, want %+v", got, want)
+	}
+	if got := cfg.Continuity.Limit(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("continuity limit: got %+v, want %+v", got, want)
+	}
+}
+
+// Stats summarize only the last EyeWindow packets' eyes.
+func TestStatsEyeWindow(t *testing.T) {
+	p := protocol.NewParser(14, 0)
+	r := New(&p, NewFileSource(bytes.NewReader(nil)), Config{})
+
+	for idx := 0; idx < EyeWindow+5; idx++ {
+		var e dsp.Eye
+		e.Add(float64(idx), 0)
+		r.observeEye(e)
+	}
+
+	s := r.Stats()
+	if want := float64(5+EyeWindow+4) / 2; s.Eye.Count[0] != EyeWindow || s.Eye.Mean(0) != want {
+		t.Fatalf("got %s, want %d decisions averaging %v", s.Eye, EyeWindow, want)
+	}
+}
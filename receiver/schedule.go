@@ -0,0 +1,263 @@
+package receiver
+
+import (
+	"sort"
+	"time"
+
+	"github.com/bemasher/rtldavis/protocol"
+)
+
+// missLimit is the number of consecutive messages a transmitter may miss
+// before we consider its timing lost.
+const missLimit = 3
+
+// Gains of the loop tracking a transmitter's timing, see scheduler.track: the
+// fractions of an arrival's timing error applied to the estimated arrival
+// and to the estimated interval between messages. Together they damp the
+// loop critically enough not to oscillate, while following a few messages of
+// jitter at most.
+const (
+	lockPhaseGain  = 0.5
+	lockPeriodGain = 0.125
+)
+
+// The estimated interval between messages stays within 1/lockPeriodRange of
+// the nominal dwell time. Both crystals are good to far better than that, an
+// estimate beyond it is following noise.
+const lockPeriodRange = 100
+
+// transmitter tracks where and when the next message from a single
+// transmitter is expected.
+type transmitter struct {
+	id    int
+	dwell time.Duration
+
+	// Interval between messages. The dwell time unless tracking timing.
+	period time.Duration
+
+	// Pattern index of the channel the next message will be sent on, and the
+	// time after which we consider it missed.
+	hopIdx   int
+	deadline time.Time
+
+	synced bool
+	misses int
+}
+
+// expected returns the time the next message should arrive. Deadlines are set
+// half a dwell time past the expected arrival.
+func (t *transmitter) expected() time.Time {
+	return t.deadline.Add(-t.dwell / 2)
+}
+
+// scheduler decides which channel to listen on when following one or more
+// transmitters. Each transmitter hops through the same pattern at its own
+// rate, so at any time we listen for whichever message is due next.
+type scheduler struct {
+	txs          []*transmitter
+	channelCount int
+
+	// While no transmitter is synced we wait on a random channel until wait.
+	wait time.Time
+
+	// Whether to track each transmitter's timing from its messages' arrival
+	// times rather than re-anchoring on each one at a nominal dwell time.
+	track bool
+}
+
+func newScheduler(ids []int, channelCount int) *scheduler {
+	s := &scheduler{channelCount: channelCount}
+	for _, id := range ids {
+		s.add(id)
+	}
+	return s
+}
+
+// add starts tracking a transmitter, returns false if it already is.
+func (s *scheduler) add(id int) bool {
+	if s.lookup(id) != nil {
+		return false
+	}
+
+	dwell := protocol.DwellTime(id)
+	s.txs = append(s.txs, &transmitter{id: id, dwell: dwell, period: dwell})
+	sort.Slice(s.txs, func(i, j int) bool {
+		return s.txs[i].id < s.txs[j].id
+	})
+
+	return true
+}
+
+// remove stops tracking a transmitter, returns false if it wasn't.
+func (s *scheduler) remove(id int) bool {
+	for idx, t := range s.txs {
+		if t.id == id {
+			s.txs = append(s.txs[:idx], s.txs[idx+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// set tracks exactly ids and returns those it started and stopped tracking.
+// Transmitters that remain keep their sync and timing.
+func (s *scheduler) set(ids []int) (added, removed []int) {
+	keep := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		keep[id] = true
+	}
+	for _, id := range s.ids() {
+		if !keep[id] && s.remove(id) {
+			removed = append(removed, id)
+		}
+	}
+	for _, id := range ids {
+		if s.add(id) {
+			added = append(added, id)
+		}
+	}
+	return added, removed
+}
+
+func (s *scheduler) lookup(id int) *transmitter {
+	for _, t := range s.txs {
+		if t.id == id {
+			return t
+		}
+	}
+	return nil
+}
+
+func (s *scheduler) ids() (ids []int) {
+	for _, t := range s.txs {
+		ids = append(ids, t.id)
+	}
+	return ids
+}
+
+// received records a message from id on pattern index hopIdx arriving at
+// now. Returns false if the transmitter isn't tracked.
+func (s *scheduler) received(id, hopIdx int, now time.Time) bool {
+	t := s.lookup(id)
+	if t == nil {
+		return false
+	}
+
+	if s.track {
+		now = s.lock(t, hopIdx, now)
+	}
+
+	// The next message is one hop further along the pattern. Set the
+	// deadline to half a dwell time after it's expected so that we expect
+	// the message half-way between now and the deadline.
+	t.hopIdx = (hopIdx + 1) % s.channelCount
+	t.deadline = now.Add(t.period + t.dwell/2)
+	t.synced = true
+	t.misses = 0
+
+	return true
+}
+
+// lock corrects t's timing from a message on pattern index hopIdx arriving at
+// now and returns the estimated time it arrived, a phase-locked loop on the
+// transmitter's cadence.
+//
+// Without tracking, each message heard anchors the next one's window and
+// missed messages are expected at the nominal dwell time. But arrival times
+// are jittered by block boundaries and neither end's clock runs exactly at
+// the nominal rate, so windows drift off center between messages and further
+// with each miss. Tracking filters the jitter out of the arrival time and
+// learns the real interval between messages. A message that isn't the one
+// expected, e.g. heard while listening for another transmitter, or the first
+// since sync was lost anchors timing afresh.
+func (s *scheduler) lock(t *transmitter, hopIdx int, now time.Time) time.Time {
+	timingErr := now.Sub(t.expected())
+	if !t.synced || hopIdx != t.hopIdx || timingErr < -t.dwell/2 || timingErr > t.dwell/2 {
+		return now
+	}
+
+	// The error built up over every interval since the last message heard.
+	t.period += time.Duration(lockPeriodGain * float64(timingErr) / float64(t.misses+1))
+	if limit := t.dwell / lockPeriodRange; t.period > t.dwell+limit {
+		t.period = t.dwell + limit
+	} else if t.period < t.dwell-limit {
+		t.period = t.dwell - limit
+	}
+
+	return t.expected().Add(time.Duration(lockPhaseGain * float64(timingErr)))
+}
+
+// expire advances every synced transmitter whose deadline has passed. A
+// transmitter that misses too many messages in a row loses sync.
+func (s *scheduler) expire(now time.Time) {
+	for _, t := range s.txs {
+		for t.synced && !now.Before(t.deadline) {
+			t.misses++
+			s.advance(t)
+
+			if t.misses >= missLimit {
+				t.synced = false
+			}
+		}
+	}
+}
+
+// resume advances every synced transmitter past a gap in reception ending
+// at now without counting the messages sent during it as missed. Hops are
+// deterministic, a transmitter is still where its schedule puts it however
+// long nothing was heard.
+func (s *scheduler) resume(now time.Time) {
+	for _, t := range s.txs {
+		for t.synced && !now.Before(t.deadline) {
+			s.advance(t)
+		}
+	}
+}
+
+// advance moves a transmitter on to its next message.
+func (s *scheduler) advance(t *transmitter) {
+	t.hopIdx = (t.hopIdx + 1) % s.channelCount
+	t.deadline = t.deadline.Add(t.period)
+}
+
+// target returns the synced transmitter whose next message is due first, or
+// nil if none are synced.
+func (s *scheduler) target() (target *transmitter) {
+	for _, t := range s.txs {
+		if t.synced && (target == nil || t.expected().Before(target.expected())) {
+			target = t
+		}
+	}
+	return target
+}
+
+// deadline returns the earliest deadline of any synced transmitter, or the
+// end of the sync wait if none are synced.
+func (s *scheduler) deadline() (deadline time.Time) {
+	synced := false
+	for _, t := range s.txs {
+		if t.synced && (!synced || t.deadline.Before(deadline)) {
+			deadline = t.deadline
+			synced = true
+		}
+	}
+
+	if !synced {
+		return s.wait
+	}
+	return deadline
+}
+
+// syncWait is how long to wait on a single channel for any transmitter: one
+// full rotation of the pattern plus one of the slowest transmitter. Some
+// channels may have enough frequency error that they won't receive until
+// we've seen at least one message and set the frequency correction.
+func (s *scheduler) syncWait(fallback time.Duration) time.Duration {
+	dwell := fallback
+	for _, t := range s.txs {
+		if t.dwell > dwell {
+			dwell = t.dwell
+		}
+	}
+	return time.Duration(s.channelCount+1) * dwell
+}
@@ -0,0 +1,206 @@
+package receiver
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bemasher/rtldavis/protocol"
+)
+
+func TestSchedulerAdd(t *testing.T) {
+	s := newScheduler([]int{3}, 51)
+
+	if !s.add(1) {
+		t.Fatal("expected new id to be added")
+	}
+	if s.add(3) {
+		t.Fatal("expected duplicate id to be ignored")
+	}
+
+	if ids := s.ids(); !reflect.DeepEqual(ids, []int{1, 3}) {
+		t.Fatalf("ids: got %v, want [1 3]", ids)
+	}
+}
+
+func TestSchedulerUntracked(t *testing.T) {
+	s := newScheduler([]int{0}, 51)
+
+	if s.received(2, 10, time.Now()) {
+		t.Fatal("expected message from untracked id to be rejected")
+	}
+	if s.target() != nil {
+		t.Fatal("expected no target")
+	}
+}
+
+func TestSchedulerFollow(t *testing.T) {
+	s := newScheduler([]int{0, 1}, 51)
+	now := time.Unix(0, 0)
+	s.wait = now.Add(time.Minute)
+
+	if !s.deadline().Equal(s.wait) {
+		t.Fatalf("deadline before sync: got %s, want %s", s.deadline(), s.wait)
+	}
+
+	s.received(0, 10, now)
+	s.received(1, 20, now.Add(time.Second))
+
+	// Transmitter 0's next message is due first.
+	target := s.target()
+	if target == nil || target.id != 0 || target.hopIdx != 11 {
+		t.Fatalf("target: got %+v, want id 0 on hop 11", target)
+	}
+
+	dwell := protocol.DwellTime(0)
+	if want := now.Add(dwell + dwell/2); !s.deadline().Equal(want) {
+		t.Fatalf("deadline: got %s, want %s", s.deadline(), want)
+	}
+
+	// Hearing transmitter 0 again moves its next message past transmitter 1's.
+	s.received(0, 11, now.Add(dwell))
+	if target := s.target(); target.id != 1 || target.hopIdx != 21 {
+		t.Fatalf("target: got %+v, want id 1 on hop 21", target)
+	}
+}
+
+func TestSchedulerExpire(t *testing.T) {
+	s := newScheduler([]int{2}, 51)
+	now := time.Unix(0, 0)
+
+	s.received(2, 50, now)
+	tx := s.lookup(2)
+	if tx.hopIdx != 0 {
+		t.Fatalf("hop index should wrap: got %d", tx.hopIdx)
+	}
+
+	// Each missed message advances the pattern by one hop.
+	for miss := 1; miss < missLimit; miss++ {
+		s.expire(tx.deadline)
+		if !tx.synced || tx.misses != miss || tx.hopIdx != miss {
+			t.Fatalf("miss %d: got %+v", miss, tx)
+		}
+	}
+
+	s.expire(tx.deadline)
+	if tx.synced {
+		t.Fatalf("expected sync to be lost after %d misses", missLimit)
+	}
+	if s.target() != nil {
+		t.Fatal("expected no target after losing sync")
+	}
+}
+
+func TestSchedulerResume(t *testing.T) {
+	s := newScheduler([]int{2}, 51)
+	now := time.Unix(0, 0)
+	dwell := protocol.DwellTime(2)
+
+	s.received(2, 10, now)
+	tx := s.lookup(2)
+
+	// A gap far longer than the miss limit keeps sync, each message sent
+	// during it moves the pattern on by one hop.
+	s.resume(now.Add(20 * dwell))
+	if !tx.synced || tx.misses != 0 || tx.hopIdx != 30 {
+		t.Fatalf("got %+v, want synced on hop 30", tx)
+	}
+	if want := now.Add(20*dwell + dwell/2); !tx.deadline.Equal(want) {
+		t.Fatalf("deadline: got %s, want %s", tx.deadline, want)
+	}
+}
+
+// A transmitter whose messages arrive slower than nominal and jittered is
+// expected closer to its real schedule after missed messages when tracking.
+func TestSchedulerTrack(t *testing.T) {
+	start := time.Unix(0, 0)
+	dwell := protocol.DwellTime(0)
+	period := dwell + dwell/200
+	arrival := func(idx int) time.Time {
+		jitter := 10 * time.Millisecond
+		if idx%2 == 1 {
+			jitter = -jitter
+		}
+		return start.Add(time.Duration(idx)*period + jitter)
+	}
+
+	errs := make(map[bool]time.Duration)
+	for _, track := range []bool{false, true} {
+		s := newScheduler([]int{0}, 51)
+		s.track = track
+		tx := s.lookup(0)
+
+		for idx := 0; idx < 40; idx++ {
+			s.received(0, idx%51, arrival(idx))
+		}
+		if track && (tx.period < period-time.Millisecond || tx.period > period+time.Millisecond) {
+			t.Fatalf("period: got %s, want %s", tx.period, period)
+		}
+
+		// Miss two messages.
+		s.expire(tx.deadline)
+		s.expire(tx.deadline)
+		if !tx.synced || tx.hopIdx != 42 {
+			t.Fatalf("got %+v, want synced on hop 42", tx)
+		}
+
+		errs[track] = tx.expected().Sub(start.Add(42 * period))
+		if errs[track] < 0 {
+			errs[track] = -errs[track]
+		}
+	}
+
+	if errs[true] > 10*time.Millisecond || errs[false] < 30*time.Millisecond {
+		t.Fatalf("timing error: got %s tracking, %s without", errs[true], errs[false])
+	}
+}
+
+// Messages other than the one expected anchor timing afresh.
+func TestSchedulerTrackUnexpected(t *testing.T) {
+	s := newScheduler([]int{0}, 51)
+	s.track = true
+	now := time.Unix(0, 0)
+	dwell := protocol.DwellTime(0)
+
+	s.received(0, 10, now)
+	s.received(0, 20, now.Add(dwell+100*time.Millisecond))
+
+	tx := s.lookup(0)
+	if tx.period != dwell {
+		t.Fatalf("period: got %s, want %s", tx.period, dwell)
+	}
+	if want := now.Add(2*dwell + 100*time.Millisecond); !tx.expected().Equal(want) {
+		t.Fatalf("expected: got %s, want %s", tx.expected(), want)
+	}
+}
+
+func TestSchedulerSyncWait(t *testing.T) {
+	s := newScheduler([]int{0, 7}, 51)
+
+	if got, want := s.syncWait(protocol.DwellTime(0)), 52*protocol.DwellTime(7); got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+func TestSchedulerSet(t *testing.T) {
+	s := newScheduler([]int{0, 1}, 51)
+	now := time.Unix(0, 0)
+	s.received(1, 20, now)
+	before := *s.lookup(1)
+
+	added, removed := s.set([]int{2, 1, 2})
+	if !reflect.DeepEqual(added, []int{2}) || !reflect.DeepEqual(removed, []int{0}) {
+		t.Fatalf("got added %v, removed %v, want [2] and [0]", added, removed)
+	}
+	if ids := s.ids(); !reflect.DeepEqual(ids, []int{1, 2}) {
+		t.Fatalf("ids: got %v, want [1 2]", ids)
+	}
+
+	// Transmitters that remain keep their timing.
+	if after := *s.lookup(1); after != before {
+		t.Fatalf("remaining transmitter changed: got %+v, want %+v", after, before)
+	}
+	if s.lookup(2).synced {
+		t.Fatal("new transmitter synced")
+	}
+}
@@ -0,0 +1,103 @@
+package receiver
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// Blocks whose power is within this fraction of the previous block's
+	// count as settled.
+	settleTolerance = 0.25
+
+	// Longest a tuner is given to settle, measuring stops after this.
+	maxSettleTime = 50 * time.Millisecond
+
+	// Weight of each new measurement in the settle time estimate.
+	settleWeight = 0.25
+)
+
+// settler discards samples captured while the tuner settles after a retune.
+// The settle time is either fixed or, if auto is set, estimated from how long
+// block power takes to stabilize after each retune.
+type settler struct {
+	auto bool
+
+	// Used by the tuner goroutine as well as Run.
+	mu         sync.Mutex
+	settleTime time.Duration
+	tuned      time.Time
+
+	// Set until the settle time after the last retune has been measured,
+	// and the power of the previous block since, zero if none.
+	measuring bool
+	prevPower float64
+}
+
+func newSettler(settleTime time.Duration, auto bool) *settler {
+	return &settler{settleTime: settleTime, auto: auto}
+}
+
+// retuned notes that the tuner finished retuning at t.
+func (s *settler) retuned(t time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tuned = t
+	s.measuring = s.auto
+	s.prevPower = 0
+}
+
+// skip reports whether a block whose last sample was captured at captured
+// should be discarded, and refines the estimate if measuring.
+func (s *settler) skip(block []byte, captured time.Time, sampleRate int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Samples from before the retune are from the previous channel.
+	if s.tuned.IsZero() || captured.Before(s.tuned) {
+		return false
+	}
+	elapsed := captured.Sub(s.tuned)
+
+	if s.measuring {
+		duration := time.Duration(len(block)/2) * time.Second / time.Duration(sampleRate)
+		power := blockPower(block)
+
+		if s.prevPower > 0 && power > s.prevPower*(1-settleTolerance) && power < s.prevPower*(1+settleTolerance) {
+			// Settled from the start of the previous block.
+			measured := elapsed - 2*duration
+			if measured < 0 {
+				measured = 0
+			}
+			s.update(measured)
+		} else if elapsed >= maxSettleTime {
+			s.update(maxSettleTime)
+		}
+		s.prevPower = power
+	}
+
+	return elapsed < s.settleTime
+}
+
+func (s *settler) update(measured time.Duration) {
+	s.settleTime += time.Duration(settleWeight * float64(measured-s.settleTime))
+	s.measuring = false
+}
+
+// estimate returns the current settle time.
+func (s *settler) estimate() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.settleTime
+}
+
+// blockPower returns the mean power of a block of 8-bit IQ samples.
+func blockPower(block []byte) float64 {
+	var power float64
+	for _, b := range block {
+		v := float64(b) - 127.4
+		power += v * v
+	}
+	return power / float64(len(block)/2)
+}
@@ -0,0 +1,70 @@
+package receiver
+
+import (
+	"testing"
+	"time"
+)
+
+// A block of 100 samples whose values swing by amplitude around the middle
+// of their range.
+func powerBlock(amplitude int) []byte {
+	block := make([]byte, 200)
+	for idx := range block {
+		block[idx] = byte(127 + amplitude*(idx&1*2-1))
+	}
+	return block
+}
+
+func TestSettlerFixed(t *testing.T) {
+	s := newSettler(3*time.Millisecond, false)
+	tuned := time.Now()
+
+	// Nothing to skip until the first retune.
+	if s.skip(powerBlock(10), tuned, 100000) {
+		t.Fatal("skipped before retuning")
+	}
+
+	s.retuned(tuned)
+	if s.skip(powerBlock(10), tuned.Add(-time.Millisecond), 100000) {
+		t.Fatal("skipped samples from before the retune")
+	}
+	if !s.skip(powerBlock(10), tuned.Add(2*time.Millisecond), 100000) {
+		t.Fatal("kept samples while settling")
+	}
+	if s.skip(powerBlock(10), tuned.Add(3*time.Millisecond), 100000) {
+		t.Fatal("skipped samples after settling")
+	}
+	if s.estimate() != 3*time.Millisecond {
+		t.Fatalf("fixed settle time changed to %s", s.estimate())
+	}
+}
+
+func TestSettlerAuto(t *testing.T) {
+	s := newSettler(10*time.Millisecond, true)
+	tuned := time.Now()
+	s.retuned(tuned)
+
+	// 1ms blocks: a transient then a steady noise floor from the start of
+	// the third block.
+	for idx, amplitude := range []int{100, 50, 10, 10, 10} {
+		captured := tuned.Add(time.Duration(idx+1) * time.Millisecond)
+		if !s.skip(powerBlock(amplitude), captured, 100000) {
+			t.Fatalf("block %d: kept while settling", idx)
+		}
+	}
+
+	// Measured 2ms, moving the estimate a quarter of the way from 10ms.
+	if got := s.estimate(); got != 8*time.Millisecond {
+		t.Fatalf("expected 8ms settle time, got %s", got)
+	}
+
+	// A tuner that never settles is given the maximum.
+	s.retuned(tuned)
+	for idx := 0; idx <= 50; idx++ {
+		captured := tuned.Add(time.Duration(idx+1) * time.Millisecond)
+		s.skip(powerBlock(10+50*(idx&1)), captured, 100000)
+	}
+	if got := s.estimate(); got != 8*time.Millisecond+(maxSettleTime-8*time.Millisecond)/4 {
+		t.Fatalf("unexpected settle time %s", got)
+	}
+}
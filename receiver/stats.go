@@ -0,0 +1,127 @@
+package receiver
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bemasher/rtldavis/dsp"
+)
+
+// EyeWindow is the number of recent packets Stats.Eye summarizes.
+const EyeWindow = 32
+
+// Stats is a snapshot of a receiver's counters.
+type Stats struct {
+	Start  time.Time
+	Uptime time.Duration
+
+	// Messages delivered, in total, per transmitter id and per channel
+	// index they were received on.
+	Packets        int
+	IDPackets      map[int]int
+	ChannelPackets map[int]int
+
+	// Packets whose CRC failed, messages rejected as off schedule, number
+	// of times the device dropped samples and number of times a remote
+	// device reconnected.
+	CRCFailures int
+	Rejected    int
+	Drops       int
+	Reconnects  int
+
+	// Whether a remote device has lost its connection and not yet
+	// reconnected.
+	Disconnected bool
+
+	// Number of hops and the channel currently tuned to.
+	Hops      int
+	Channel   int
+	Frequency int
+
+	// Blocks discarded while the tuner settled after a retune and the
+	// current settle time.
+	Unsettled  int
+	SettleTime time.Duration
+
+	// Device sample rate and number of times it was lowered to keep up.
+	SampleRate int
+	Downgrades int
+
+	// Whether samples are conjugated before demodulation.
+	SpectrumInverted bool
+
+	// Time from capturing a packet to delivering its message.
+	Latency Latency
+
+	// Symbol decisions of the last EyeWindow packets that passed their CRC,
+	// a measure of signal quality finer than whether packets are received.
+	Eye dsp.Eye
+}
+
+func (s Stats) String() string {
+	return fmt.Sprintf("Uptime:%s Packets:%d IDs:[%s] CRCFailures:%d Rejected:%d Drops:%d Reconnects:%d Disconnected:%t Hops:%d Channel:%d Unsettled:%d SettleTime:%s SampleRate:%d Downgrades:%d Latency:%s Eye:%s",
+		s.Uptime.Round(time.Second), s.Packets, counts(s.IDPackets), s.CRCFailures,
+		s.Rejected, s.Drops, s.Reconnects, s.Disconnected, s.Hops, s.Channel, s.Unsettled, s.SettleTime, s.SampleRate, s.Downgrades, s.Latency, s.Eye,
+	)
+}
+
+// Format counts as key:value pairs ordered by key.
+func counts(m map[int]int) string {
+	keys := make([]int, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Ints(keys)
+
+	pairs := make([]string, len(keys))
+	for idx, key := range keys {
+		pairs[idx] = fmt.Sprintf("%d:%d", key, m[key])
+	}
+	return strings.Join(pairs, " ")
+}
+
+// Stats returns a consistent snapshot of the receiver's counters, safe to
+// call while the receiver is running.
+func (r *Receiver) Stats() Stats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s := r.stats
+	if !s.Start.IsZero() {
+		s.Uptime = r.cfg.Clock.Now().Sub(s.Start)
+	}
+	s.SettleTime = r.settle.estimate()
+	if cr, ok := r.dev.(ConnectionReporter); ok {
+		s.Disconnected = !cr.Connected()
+	}
+	s.IDPackets = copyCounts(r.stats.IDPackets)
+	s.ChannelPackets = copyCounts(r.stats.ChannelPackets)
+	for _, e := range r.eyes {
+		s.Eye.Merge(e)
+	}
+	return s
+}
+
+// observeEye records the symbol decisions of a packet that passed its CRC.
+// Decisions in packets that failed can't be trusted to be right.
+func (r *Receiver) observeEye(e dsp.Eye) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.eyes) < EyeWindow {
+		r.eyes = append(r.eyes, e)
+		return
+	}
+	r.eyes[r.nextEye] = e
+	r.nextEye = (r.nextEye + 1) % EyeWindow
+}
+
+func copyCounts(m map[int]int) map[int]int {
+	c := make(map[int]int, len(m))
+	for key, count := range m {
+		c[key] = count
+	}
+	return c
+}
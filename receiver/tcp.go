@@ -0,0 +1,333 @@
+package receiver
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// rtl_tcp's protocol: on connecting the server sends a header naming the
+// tuner, then streams 8-bit interleaved IQ samples. Commands are a byte
+// followed by a big-endian 32-bit parameter.
+const (
+	tcpMagic        = "RTL0"
+	tcpHeaderLength = 12
+
+	tcpSetFrequency  = 0x01
+	tcpSetSampleRate = 0x02
+	tcpSetGainMode   = 0x03
+	tcpSetGain       = 0x04
+	tcpSetAGCMode    = 0x08
+)
+
+// Bounds of the wait between reconnection attempts, doubling after each
+// failure.
+const (
+	DefaultTCPBackoff    = time.Second
+	DefaultTCPMaxBackoff = 30 * time.Second
+)
+
+// Timeout of each connection attempt.
+const tcpDialTimeout = 10 * time.Second
+
+// A connection that delivers no samples for tcpReadBlocks times as long as a
+// read's worth takes to sample is taken to be lost, otherwise a half-open
+// connection blocks Read forever. A few blocks' time is too short to tell a
+// stall from network jitter, so the timeout is at least tcpMinReadTimeout.
+const (
+	tcpReadBlocks     = 4
+	tcpMinReadTimeout = time.Second
+)
+
+// ErrBadHeader is returned when a server doesn't identify itself as rtl_tcp.
+var ErrBadHeader = errors.New("receiver: not an rtl_tcp server")
+
+// TCPSource is a Device reading from an rtl_tcp server, for dongles attached
+// to another host.
+//
+// A lost connection is reconnected with backoff between MinBackoff and
+// MaxBackoff, Read blocks meanwhile. Once reconnected the sample rate, gain
+// settings and last frequency tuned to are issued again, and Read returns an
+// error wrapping ErrReconnected: samples from before and after the gap can't
+// be joined. Each loss and reconnection is logged. A connection is also taken
+// to be lost when no samples arrive for a few reads' worth of time.
+//
+// Close interrupts a Read blocked on the server, including while
+// reconnecting.
+type TCPSource struct {
+	addr string
+
+	// Backoff between reconnection attempts. A MaxBackoff of zero disables
+	// reconnecting, a lost connection ends the stream.
+	MinBackoff, MaxBackoff time.Duration
+
+	// Log receives connection losses and reconnections, the standard
+	// logger if nil.
+	Log *log.Logger
+
+	// Dials the server, net.DialTimeout unless replaced for testing.
+	dial func(addr string) (net.Conn, error)
+
+	// Lower bound of the read timeout, tcpMinReadTimeout unless lowered for
+	// testing.
+	minReadTimeout time.Duration
+
+	mu     sync.Mutex
+	conn   net.Conn
+	rate   int
+	freq   int
+	gain   int
+	closed bool
+	done   chan struct{}
+}
+
+// DialTCP connects to the rtl_tcp server at addr and sets its sample rate.
+func DialTCP(addr string, sampleRate int) (*TCPSource, error) {
+	return dialTCP(addr, sampleRate, func(addr string) (net.Conn, error) {
+		return net.DialTimeout("tcp", addr, tcpDialTimeout)
+	})
+}
+
+func dialTCP(addr string, sampleRate int, dial func(string) (net.Conn, error)) (*TCPSource, error) {
+	t := &TCPSource{
+		addr:           addr,
+		MinBackoff:     DefaultTCPBackoff,
+		MaxBackoff:     DefaultTCPMaxBackoff,
+		dial:           dial,
+		minReadTimeout: tcpMinReadTimeout,
+		rate:           sampleRate,
+		done:           make(chan struct{}),
+	}
+
+	conn, err := t.connect()
+	if err != nil {
+		return nil, &DeviceError{Op: "connect " + addr, Err: err}
+	}
+	t.conn = conn
+	return t, nil
+}
+
+// connect dials the server, checks its header and configures it.
+func (t *TCPSource) connect() (net.Conn, error) {
+	conn, err := t.dial(t.addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// A server that accepts but never answers would block reconnecting.
+	var header [tcpHeaderLength]byte
+	conn.SetReadDeadline(time.Now().Add(tcpDialTimeout))
+	if _, err := io.ReadFull(conn, header[:]); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if !bytes.Equal(header[:len(tcpMagic)], []byte(tcpMagic)) {
+		conn.Close()
+		return nil, ErrBadHeader
+	}
+
+	t.mu.Lock()
+	commands := [][2]int{
+		{tcpSetSampleRate, t.rate},
+		{tcpSetAGCMode, 0},
+	}
+	if t.gain > 0 {
+		commands = append(commands, [2]int{tcpSetGainMode, 1}, [2]int{tcpSetGain, t.gain})
+	} else {
+		commands = append(commands, [2]int{tcpSetGainMode, 0})
+	}
+	if t.freq != 0 {
+		commands = append(commands, [2]int{tcpSetFrequency, t.freq})
+	}
+	t.mu.Unlock()
+
+	for _, c := range commands {
+		if err := command(conn, c[0], c[1]); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+func command(w io.Writer, cmd, param int) error {
+	var buf [5]byte
+	buf[0] = byte(cmd)
+	binary.BigEndian.PutUint32(buf[1:], uint32(param))
+	_, err := w.Write(buf[:])
+	return err
+}
+
+func (t *TCPSource) logf(format string, v ...interface{}) {
+	msg := fmt.Sprintf("rtl_tcp %s: "+format, append([]interface{}{t.addr}, v...)...)
+	if t.Log == nil {
+		log.Println(msg)
+		return
+	}
+	t.Log.Println(msg)
+}
+
+func (t *TCPSource) Read(buf []byte) (int, error) {
+	t.mu.Lock()
+	conn, closed, rate := t.conn, t.closed, t.rate
+	t.mu.Unlock()
+	if closed {
+		return 0, io.EOF
+	}
+
+	if conn != nil {
+		timeout := tcpReadBlocks * time.Duration(len(buf)/2) * time.Second / time.Duration(rate)
+		if timeout < t.minReadTimeout {
+			timeout = t.minReadTimeout
+		}
+		conn.SetReadDeadline(time.Now().Add(timeout))
+
+		n, err := conn.Read(buf)
+		if err == nil {
+			return n, nil
+		}
+		t.disconnect(conn, err)
+		if n > 0 {
+			return n, nil
+		}
+	}
+
+	if t.MaxBackoff <= 0 {
+		return 0, io.EOF
+	}
+	return 0, t.reconnect()
+}
+
+// disconnect drops conn, which failed with err, unless it has already been
+// replaced.
+func (t *TCPSource) disconnect(conn net.Conn, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.conn != conn {
+		return
+	}
+	conn.Close()
+	t.conn = nil
+	if !t.closed {
+		t.logf("connection lost: %v", err)
+	}
+}
+
+// reconnect retries with backoff until connected and returns an error
+// wrapping ErrReconnected, or io.EOF if the source is closed first.
+func (t *TCPSource) reconnect() error {
+	start := time.Now()
+	backoff := t.MinBackoff
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-t.done:
+			return io.EOF
+		default:
+		}
+
+		conn, err := t.connect()
+		if err == nil {
+			t.mu.Lock()
+			if t.closed {
+				t.mu.Unlock()
+				conn.Close()
+				return io.EOF
+			}
+			t.conn = conn
+			t.mu.Unlock()
+
+			t.logf("reconnected after %s", time.Since(start).Round(time.Millisecond))
+			return &DeviceError{Op: "read", Err: ErrReconnected}
+		}
+
+		t.logf("reconnect attempt %d failed: %v, retrying in %s", attempt, err, backoff)
+		select {
+		case <-time.After(backoff):
+		case <-t.done:
+			return io.EOF
+		}
+		if backoff *= 2; backoff > t.MaxBackoff {
+			backoff = t.MaxBackoff
+		}
+	}
+}
+
+// send issues a command if connected. A failed command drops the connection
+// for Read to reconnect, the setting is issued again once it has.
+func (t *TCPSource) send(cmd, param int) {
+	t.mu.Lock()
+	conn := t.conn
+	t.mu.Unlock()
+	if conn == nil {
+		return
+	}
+	if err := command(conn, cmd, param); err != nil {
+		t.disconnect(conn, err)
+	}
+}
+
+// SetCenterFreq tunes the server's dongle. While disconnected the frequency
+// is tuned to once reconnected.
+func (t *TCPSource) SetCenterFreq(freq int) error {
+	t.mu.Lock()
+	t.freq = freq
+	t.mu.Unlock()
+	t.send(tcpSetFrequency, freq)
+	return nil
+}
+
+// SetSampleRate changes the sample rate while reading, samples already
+// buffered were taken at the old rate.
+func (t *TCPSource) SetSampleRate(rate int) error {
+	t.mu.Lock()
+	t.rate = rate
+	t.mu.Unlock()
+	t.send(tcpSetSampleRate, rate)
+	return nil
+}
+
+// SetGain fixes the tuner gain in tenths of a dB, kept across reconnections.
+func (t *TCPSource) SetGain(gain int) error {
+	t.mu.Lock()
+	manual := t.gain > 0
+	t.gain = gain
+	t.mu.Unlock()
+
+	if !manual {
+		t.send(tcpSetGainMode, 1)
+	}
+	t.send(tcpSetGain, gain)
+	return nil
+}
+
+// Connected reports whether the source is connected to its server.
+func (t *TCPSource) Connected() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.conn != nil
+}
+
+// Close disconnects, any Read in progress or after returns io.EOF.
+func (t *TCPSource) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return nil
+	}
+	t.closed = true
+	close(t.done)
+	if t.conn != nil {
+		err := t.conn.Close()
+		t.conn = nil
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+package receiver
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"testing"
+	"time"
+)
+
+// A fake rtl_tcp server. Each connection is sent the header and the next
+// payload, then closed if more payloads follow. Commands received on each
+// connection are sent on commands.
+type tcpServer struct {
+	ln       net.Listener
+	payloads [][]byte
+	commands chan [2]int
+}
+
+func newTCPServer(t *testing.T, header string, payloads ...[]byte) *tcpServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip("can't listen on loopback:", err)
+	}
+	s := &tcpServer{ln: ln, payloads: payloads, commands: make(chan [2]int, 64)}
+	go s.serve(header)
+	return s
+}
+
+func (s *tcpServer) serve(header string) {
+	for idx, payload := range s.payloads {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		go func(conn net.Conn) {
+			var cmd [5]byte
+			for {
+				if _, err := io.ReadFull(conn, cmd[:]); err != nil {
+					return
+				}
+				s.commands <- [2]int{int(cmd[0]), int(binary.BigEndian.Uint32(cmd[1:]))}
+			}
+		}(conn)
+
+		hdr := make([]byte, tcpHeaderLength)
+		copy(hdr, header)
+		conn.Write(hdr)
+		conn.Write(payload)
+
+		if idx < len(s.payloads)-1 {
+			// Let the client's commands arrive before hanging up.
+			time.Sleep(20 * time.Millisecond)
+			conn.Close()
+		}
+	}
+}
+
+// Commands received until none arrive for a while.
+func (s *tcpServer) received() (cmds [][2]int) {
+	for {
+		select {
+		case cmd := <-s.commands:
+			cmds = append(cmds, cmd)
+		case <-time.After(50 * time.Millisecond):
+			return cmds
+		}
+	}
+}
+
+func hasCommand(cmds [][2]int, cmd, param int) bool {
+	for _, c := range cmds {
+		if c == [2]int{cmd, param} {
+			return true
+		}
+	}
+	return false
+}
+
+func TestTCPSourceReconnect(t *testing.T) {
+	s := newTCPServer(t, tcpMagic, []byte("before"), []byte("after"))
+	defer s.ln.Close()
+
+	src, err := DialTCP(s.ln.Addr().String(), 268800)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	src.MinBackoff = time.Millisecond
+	src.Log = log.New(ioutil.Discard, "", 0)
+
+	if err := src.SetCenterFreq(911000000); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.SetGain(402); err != nil {
+		t.Fatal(err)
+	}
+	if cmds := s.received(); !hasCommand(cmds, tcpSetSampleRate, 268800) || !hasCommand(cmds, tcpSetFrequency, 911000000) || !hasCommand(cmds, tcpSetGain, 402) {
+		t.Fatalf("initial commands: got %v", cmds)
+	}
+
+	buf := make([]byte, 6)
+	if _, err := io.ReadFull(src, buf); err != nil || string(buf) != "before" {
+		t.Fatalf("first connection: got %q, %v", buf, err)
+	}
+
+	// The connection is lost and re-established.
+	var n int
+	for err == nil {
+		n, err = src.Read(buf)
+		if n != 0 {
+			t.Fatalf("unexpected data: %q", buf[:n])
+		}
+	}
+	if !errors.Is(err, ErrReconnected) || !src.Connected() {
+		t.Fatalf("expected a reconnection, got %v (connected %t)", err, src.Connected())
+	}
+
+	// Settings are issued again.
+	cmds := s.received()
+	if !hasCommand(cmds, tcpSetSampleRate, 268800) || !hasCommand(cmds, tcpSetFrequency, 911000000) || !hasCommand(cmds, tcpSetGain, 402) {
+		t.Fatalf("commands after reconnecting: got %v", cmds)
+	}
+
+	buf = make([]byte, 5)
+	if _, err := io.ReadFull(src, buf); err != nil || string(buf) != "after" {
+		t.Fatalf("second connection: got %q, %v", buf, err)
+	}
+
+	src.Close()
+	if _, err := src.Read(buf); err != io.EOF {
+		t.Fatalf("after close: got %v, want EOF", err)
+	}
+}
+
+func TestTCPSourceNoReconnect(t *testing.T) {
+	s := newTCPServer(t, tcpMagic, []byte("only"), nil)
+	defer s.ln.Close()
+
+	src, err := DialTCP(s.ln.Addr().String(), 268800)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	src.MaxBackoff = 0
+	src.Log = log.New(ioutil.Discard, "", 0)
+
+	data, err := ioutil.ReadAll(src)
+	if err != nil || !bytes.Equal(data, []byte("only")) {
+		t.Fatalf("got %q, %v", data, err)
+	}
+}
+
+// A connection that stops delivering samples without closing is dropped.
+func TestTCPSourceStalled(t *testing.T) {
+	s := newTCPServer(t, tcpMagic, []byte("only"))
+	defer s.ln.Close()
+
+	src, err := DialTCP(s.ln.Addr().String(), 268800)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	src.MaxBackoff = 0
+	src.minReadTimeout = 20 * time.Millisecond
+	src.Log = log.New(ioutil.Discard, "", 0)
+
+	data, err := ioutil.ReadAll(src)
+	if err != nil || !bytes.Equal(data, []byte("only")) || src.Connected() {
+		t.Fatalf("got %q, %v (connected %t)", data, err, src.Connected())
+	}
+}
+
+// Close interrupts a Read waiting to reconnect.
+func TestTCPSourceCloseReconnecting(t *testing.T) {
+	s := newTCPServer(t, tcpMagic, nil)
+	src, err := DialTCP(s.ln.Addr().String(), 268800)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src.MinBackoff = time.Hour
+	src.minReadTimeout = time.Millisecond
+	src.Log = log.New(ioutil.Discard, "", 0)
+
+	// Nothing is listening once the connection is lost.
+	s.ln.Close()
+	errc := make(chan error, 1)
+	go func() {
+		_, err := io.ReadFull(src, make([]byte, 16))
+		errc <- err
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	src.Close()
+	select {
+	case err := <-errc:
+		if err != io.EOF {
+			t.Fatalf("got %v, want EOF", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Read still blocked after Close")
+	}
+}
+
+func TestTCPSourceBadHeader(t *testing.T) {
+	s := newTCPServer(t, "HTTP", nil)
+	defer s.ln.Close()
+
+	if _, err := DialTCP(s.ln.Addr().String(), 268800); !errors.Is(err, ErrBadHeader) {
+		t.Fatalf("got %v, want ErrBadHeader", err)
+	}
+}
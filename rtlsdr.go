@@ -0,0 +1,251 @@
+package main
+
+import (
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/bemasher/rtldavis/dsp"
+	"github.com/bemasher/rtldavis/receiver"
+	"github.com/jpoirier/gortlsdr"
+)
+
+// librtlsdr's transfers must be a multiple of this many bytes. Transfers are
+// sized independently of the demodulator's blocks, Read splits them as
+// needed.
+const rtlTransferAlign = 512
+
+// Number of transfers buffered between the device's callback and Read. If the
+// reader falls further behind than this, transfers are dropped rather than
+// stalling the callback.
+const rtlBlocks = 16
+
+// Time each tuner takes to settle after a retune, used unless -settle is
+// given. The R820T and R828D relock their PLL within a couple of
+// milliseconds, the older tuners are slower and less consistent. These are
+// conservative, -auto-settle measures the dongle at hand.
+var tunerSettleTimes = map[string]time.Duration{
+	"RTLSDR_TUNER_R820T":  2 * time.Millisecond,
+	"RTLSDR_TUNER_R828D":  2 * time.Millisecond,
+	"RTLSDR_TUNER_E4000":  5 * time.Millisecond,
+	"RTLSDR_TUNER_FC0012": 5 * time.Millisecond,
+	"RTLSDR_TUNER_FC0013": 5 * time.Millisecond,
+	"RTLSDR_TUNER_FC2580": 5 * time.Millisecond,
+}
+
+// Settle time of tuners missing from tunerSettleTimes.
+const defaultSettleTime = 5 * time.Millisecond
+
+// rtlDevice adapts an rtl-sdr dongle to receiver.Device. Samples are read
+// asynchronously into a ring of blocks so the receiver can retune while
+// reading.
+type rtlDevice struct {
+	*rtlsdr.Context
+
+	free    chan []byte
+	blocks  chan rtlBlock
+	pending []byte
+	current []byte
+
+	// Bytes per second and when the current block's last sample arrived.
+	rate     int
+	captured time.Time
+
+	// Set by the callback while blocks are being dropped, only accessed from
+	// the callback.
+	dropping bool
+
+	// Whether the tuner gain has been switched to manual.
+	manualGain bool
+
+	done chan struct{}
+}
+
+type rtlBlock struct {
+	buf []byte
+
+	// Blocks were dropped immediately before this one.
+	dropped bool
+
+	// When the block was handed to the callback.
+	captured time.Time
+}
+
+func init() {
+	registerDriver("rtlsdr", func(cfg dsp.PacketConfig, device string) (receiver.Device, io.Closer, error) {
+		dev, err := openRTL(cfg, device)
+		if err != nil {
+			return nil, nil, err
+		}
+		return dev, dev, nil
+	})
+}
+
+// Open the device with the given index or serial number.
+func openRTL(cfg dsp.PacketConfig, device string) (*rtlDevice, error) {
+	op := "open " + device
+
+	index, err := strconv.Atoi(device)
+	if err != nil {
+		if index, err = rtlsdr.GetIndexBySerial(device); err != nil {
+			return nil, &receiver.DeviceError{Op: op, Err: receiver.ErrDeviceNotFound}
+		}
+	}
+	if index < 0 || index >= rtlsdr.GetDeviceCount() {
+		return nil, &receiver.DeviceError{Op: op, Err: receiver.ErrDeviceNotFound}
+	}
+
+	ctx, err := rtlsdr.Open(index)
+	if err != nil {
+		return nil, &receiver.DeviceError{Op: op, Err: err}
+	}
+
+	d := &rtlDevice{
+		Context: ctx,
+		free:    make(chan []byte, rtlBlocks),
+		blocks:  make(chan rtlBlock, rtlBlocks),
+		rate:    2 * cfg.DeviceSampleRate,
+		done:    make(chan struct{}),
+	}
+
+	// Keep transfers about a block long so latency doesn't grow.
+	transferSize := (cfg.DeviceBlockSize2 + rtlTransferAlign - 1) / rtlTransferAlign * rtlTransferAlign
+	for idx := 0; idx < rtlBlocks; idx++ {
+		d.free <- make([]byte, transferSize)
+	}
+
+	if tuner := d.GetTunerType(); tuner == "RTLSDR_TUNER_UNKNOWN" {
+		d.Context.Close()
+		return nil, &receiver.DeviceError{Op: op, Err: receiver.ErrTunerUnsupported}
+	}
+
+	if err := d.init(cfg); err != nil {
+		d.Context.Close()
+		return nil, err
+	}
+
+	go d.ReadAsync(d.callback, nil, 1, transferSize)
+
+	return d, nil
+}
+
+// init puts the device in a known state regardless of what a previous
+// program left behind. Leaving direct sampling mode reinitializes the tuner,
+// which recovers tuners left in a bad state that would otherwise need the
+// dongle to be replugged.
+func (d *rtlDevice) init(cfg dsp.PacketConfig) error {
+	steps := []struct {
+		op string
+		fn func() error
+	}{
+		{"disable test mode", func() error { return d.SetTestMode(false) }},
+		{"disable direct sampling", func() error { return d.SetDirectSampling(rtlsdr.SamplingNone) }},
+		{"disable agc", func() error { return d.SetAgcMode(false) }},
+		{"set sample rate", func() error { return d.SetSampleRate(cfg.DeviceSampleRate) }},
+		{"set gain mode", func() error { return d.SetTunerGainMode(false) }},
+		{"reset buffer", d.ResetBuffer},
+	}
+
+	for _, step := range steps {
+		if err := step.fn(); err != nil {
+			return &receiver.DeviceError{Op: step.op, Err: err}
+		}
+	}
+
+	return nil
+}
+
+// Called by the driver for each block of samples, which is only valid until
+// the callback returns.
+func (d *rtlDevice) callback(buf []byte) {
+	select {
+	case block := <-d.free:
+		block = block[:copy(block[:cap(block)], buf)]
+		d.blocks <- rtlBlock{block, d.dropping, time.Now()}
+		d.dropping = false
+	default:
+		d.dropping = true
+	}
+}
+
+func (d *rtlDevice) Read(buf []byte) (int, error) {
+	if len(d.pending) == 0 {
+		if d.current != nil {
+			d.free <- d.current
+			d.current = nil
+		}
+
+		var block rtlBlock
+		select {
+		case block = <-d.blocks:
+		case <-d.done:
+			return 0, io.EOF
+		}
+		d.current, d.pending = block.buf, block.buf
+		d.captured = block.captured
+
+		// Report dropped blocks before handing out samples from after them.
+		if block.dropped {
+			return 0, &receiver.DeviceError{Op: "read", Err: receiver.ErrSampleDropped}
+		}
+	}
+
+	n := copy(buf, d.pending)
+	d.pending = d.pending[n:]
+	return n, nil
+}
+
+// CaptureTime estimates when the last sample read was captured from when its
+// transfer completed, less the time taken to capture the rest of the transfer.
+func (d *rtlDevice) CaptureTime() time.Time {
+	if d.captured.IsZero() {
+		return time.Time{}
+	}
+	return d.captured.Add(-time.Duration(len(d.pending)) * time.Second / time.Duration(d.rate))
+}
+
+func (d *rtlDevice) SetCenterFreq(freq int) error {
+	if err := d.Context.SetCenterFreq(freq); err != nil {
+		return &receiver.DeviceError{Op: "set center frequency", Err: err}
+	}
+	return nil
+}
+
+// SettleTime returns the default settle time for the device's tuner.
+func (d *rtlDevice) SettleTime() time.Duration {
+	if settle, ok := tunerSettleTimes[d.GetTunerType()]; ok {
+		return settle
+	}
+	return defaultSettleTime
+}
+
+// SetSampleRate changes the sample rate while reading, samples already
+// buffered were taken at the old rate.
+func (d *rtlDevice) SetSampleRate(rate int) error {
+	if err := d.Context.SetSampleRate(rate); err != nil {
+		return &receiver.DeviceError{Op: "set sample rate", Err: err}
+	}
+	d.rate = 2 * rate
+	return nil
+}
+
+// SetGain fixes the tuner gain in tenths of a dB, the driver picks the
+// nearest gain the tuner supports.
+func (d *rtlDevice) SetGain(gain int) error {
+	if !d.manualGain {
+		if err := d.SetTunerGainMode(true); err != nil {
+			return &receiver.DeviceError{Op: "set gain mode", Err: err}
+		}
+		d.manualGain = true
+	}
+	if err := d.SetTunerGain(gain); err != nil {
+		return &receiver.DeviceError{Op: "set gain", Err: err}
+	}
+	return nil
+}
+
+func (d *rtlDevice) Close() error {
+	close(d.done)
+	d.CancelAsync()
+	return d.Context.Close()
+}
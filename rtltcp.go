@@ -0,0 +1,27 @@
+package main
+
+import (
+	"io"
+
+	"github.com/bemasher/rtldavis/dsp"
+	"github.com/bemasher/rtldavis/receiver"
+)
+
+// rtl_tcp serves a dongle attached to another host, e.g. a Raspberry Pi by
+// the station: rtl_tcp -a 0.0.0.0, then -driver rtltcp -device pi:1234.
+// Samples tuned before a retune are still in flight over the network when it
+// takes effect, -auto-settle measures how long to discard after each one.
+
+func init() {
+	registerDriver("rtltcp", func(cfg dsp.PacketConfig, addr string) (receiver.Device, io.Closer, error) {
+		dev, err := receiver.DialTCP(addr, cfg.DeviceSampleRate)
+		if err != nil {
+			return nil, nil, err
+		}
+		dev.MaxBackoff = *reconnect
+		if dev.MinBackoff > dev.MaxBackoff {
+			dev.MinBackoff = dev.MaxBackoff
+		}
+		return dev, dev, nil
+	})
+}
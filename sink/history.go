@@ -0,0 +1,89 @@
+package sink
+
+import (
+	"sync"
+
+	"github.com/bemasher/rtldavis/protocol"
+)
+
+// DefaultHistorySize is enough readings to see every sensor of a station a
+// few times over.
+const DefaultHistorySize = 100
+
+// History keeps the most recent readings, for consumers showing the last few
+// without keeping a buffer of their own. Once full, each reading written
+// replaces the oldest.
+//
+// History is safe for concurrent use.
+type History struct {
+	mu       sync.Mutex
+	readings []protocol.Reading
+	next     int
+	full     bool
+}
+
+// NewHistory returns a history of up to size readings, DefaultHistorySize if
+// size isn't positive.
+func NewHistory(size int) *History {
+	if size <= 0 {
+		size = DefaultHistorySize
+	}
+	return &History{readings: make([]protocol.Reading, size)}
+}
+
+func (h *History) Write(r protocol.Reading) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.readings[h.next] = r
+	h.next = (h.next + 1) % len(h.readings)
+	if h.next == 0 {
+		h.full = true
+	}
+	return nil
+}
+
+// RecentPackets returns up to n of the most recent readings, oldest first.
+// A non-positive n returns every reading kept.
+func (h *History) RecentPackets(n int) []protocol.Reading {
+	return h.Recent(n, nil)
+}
+
+// Recent returns up to n of the most recent readings match accepts, oldest
+// first. A nil match accepts every reading, a non-positive n returns every
+// match kept.
+func (h *History) Recent(n int, match func(protocol.Reading) bool) []protocol.Reading {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	count := h.next
+	if h.full {
+		count = len(h.readings)
+	}
+	if n <= 0 || n > count {
+		n = count
+	}
+
+	// Walk back from the newest, then reverse.
+	var recent []protocol.Reading
+	for idx := 0; idx < count && len(recent) < n; idx++ {
+		r := h.readings[(h.next-1-idx+len(h.readings))%len(h.readings)]
+		if match == nil || match(r) {
+			recent = append(recent, r)
+		}
+	}
+	for i, j := 0, len(recent)-1; i < j; i, j = i+1, j-1 {
+		recent[i], recent[j] = recent[j], recent[i]
+	}
+	return recent
+}
+
+// FromID matches readings from transmitter id.
+func FromID(id int) func(protocol.Reading) bool {
+	return func(r protocol.Reading) bool { return int(r.ID) == id }
+}
+
+// OfSensor matches readings carrying the given sensor's value.
+func OfSensor(sensor protocol.Sensor) func(protocol.Reading) bool {
+	return func(r protocol.Reading) bool { return r.Sensor == sensor }
+}
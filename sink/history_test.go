@@ -0,0 +1,61 @@
+package sink
+
+import (
+	"testing"
+
+	"github.com/bemasher/rtldavis/protocol"
+)
+
+func TestHistory(t *testing.T) {
+	h := NewHistory(4)
+	if got := h.RecentPackets(10); len(got) != 0 {
+		t.Fatalf("empty history: got %d readings", len(got))
+	}
+
+	// Six readings alternating between two transmitters and sensors, only
+	// the last four are kept.
+	for idx := 0; idx < 6; idx++ {
+		r := testReading()
+		r.ID = byte(idx % 2)
+		r.Sensor = protocol.Temperature
+		if idx%2 == 1 {
+			r.Sensor = protocol.Humidity
+		}
+		r.Value = float64(idx)
+		h.Write(r)
+	}
+
+	values := func(rs []protocol.Reading) (vs []float64) {
+		for _, r := range rs {
+			vs = append(vs, r.Value)
+		}
+		return vs
+	}
+	equal := func(got, want []float64) bool {
+		if len(got) != len(want) {
+			return false
+		}
+		for idx := range got {
+			if got[idx] != want[idx] {
+				return false
+			}
+		}
+		return true
+	}
+
+	for _, tc := range []struct {
+		name  string
+		got   []protocol.Reading
+		wants []float64
+	}{
+		{"all", h.RecentPackets(0), []float64{2, 3, 4, 5}},
+		{"last two", h.RecentPackets(2), []float64{4, 5}},
+		{"more than kept", h.RecentPackets(10), []float64{2, 3, 4, 5}},
+		{"by id", h.Recent(0, FromID(1)), []float64{3, 5}},
+		{"by sensor", h.Recent(1, OfSensor(protocol.Temperature)), []float64{4}},
+	} {
+		if got := values(tc.got); !equal(got, tc.wants) {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.wants)
+		}
+	}
+}
@@ -0,0 +1,376 @@
+package sink
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bemasher/rtldavis/crc"
+	"github.com/bemasher/rtldavis/protocol"
+)
+
+// Loop emulates the LOOP command of a Davis console's serial protocol, so
+// software that only talks to a console or data logger can read from the
+// receiver. Readings update the console's current conditions and clients
+// connected to Serve poll them as 99 byte LOOP packets.
+//
+// Only what the transmitters send over the air is available: outside
+// temperature and humidity, wind speed and direction, rain rate, daily rain,
+// UV and solar radiation. The console measures barometric pressure and
+// inside conditions itself, these are always reported as missing, as are ET,
+// forecasts, alarms, battery status and sunrise and sunset. Daily rain counts
+// from a gauge's first reading, or from midnight after, so it starts from
+// zero when the receiver does. Each transmitter's gauge has its own day, the
+// one heard last is reported.
+//
+// A console shows one station, SetLimit picks the transmitters making it up.
+// Every message carries wind, which is taken from the limit's first id, the
+// transmitter the anemometer is wired to. Other values are taken from
+// whichever transmitter in the limit sends them, the last heard if several
+// do. Without a limit every transmitter heard updates the conditions.
+//
+// Only the wakeup, TEST and LOOP commands are understood, everything else is
+// answered with a NAK. Clients expecting a serial port can be given one with
+// socat, e.g. socat pty,link=/tmp/davis,raw tcp:localhost:22222.
+type Loop struct {
+	// Time between packets in response to LOOP, a console sends one every
+	// 2 seconds.
+	Interval time.Duration
+
+	crc  crc.CRC
+	rain *protocol.RainAccumulator
+
+	mu    sync.Mutex
+	limit protocol.StateLimit
+	state loopState
+}
+
+// Current conditions, values are unset until received.
+type loopState struct {
+	temp, humidity   *float64
+	speed, direction *float64
+	rainRate         *float64
+	uv, solar        *float64
+
+	// Wind speeds over the last 10 minutes, for the average.
+	wind []windSample
+
+	// Each rain gauge's day by transmitter id, and the day's tips of the
+	// gauge heard last.
+	gauges  map[int]*rainDay
+	dayTips int
+}
+
+// Total tips a gauge counted, at the start of the day and since.
+type rainDay struct {
+	total int
+	day   time.Time
+	base  int
+}
+
+type windSample struct {
+	time  time.Time
+	speed float64
+}
+
+const (
+	loopLength   = 99
+	loopInterval = 2 * time.Second
+
+	loopACK = 0x06
+	loopNAK = 0x21
+
+	// Values of fields with no data.
+	dashByte  = 0xFF
+	dashShort = 0x7FFF
+)
+
+func NewLoop() *Loop {
+	return &Loop{
+		Interval: loopInterval,
+		crc:      crc.NewCRC("CCITT-16", 0, 0x1021, 0),
+		rain:     protocol.NewRainAccumulator(),
+	}
+}
+
+// SetLimit bounds the transmitters whose readings update the current
+// conditions, see StateLimit. Rain is accumulated within the same limit.
+func (l *Loop) SetLimit(limit protocol.StateLimit) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.limit = limit
+	l.rain.SetLimit(limit)
+	for id := range l.state.gauges {
+		if !limit.Tracks(id) {
+			delete(l.state.gauges, id)
+		}
+	}
+}
+
+// Write updates the current conditions from a reading. Readings that aren't
+// Valid only update the wind, those from messages that failed their CRC and
+// weren't corrected, or from transmitters outside the limit, are ignored.
+func (l *Loop) Write(r protocol.Reading) error {
+	if !r.CRCValid && !r.Corrected {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	id := int(r.ID)
+	if !l.limit.Tracks(id) {
+		return nil
+	}
+
+	s := &l.state
+
+	if ids := l.limit.IDs; len(ids) == 0 || ids[0] == id {
+		speed, direction := r.Speed, r.Direction
+		s.speed, s.direction = &speed, &direction
+		s.wind = append(s.wind, windSample{r.Time, speed})
+		for len(s.wind) > 0 && r.Time.Sub(s.wind[0].time) > 10*time.Minute {
+			s.wind = s.wind[1:]
+		}
+	}
+
+	if !r.Valid {
+		return nil
+	}
+
+	value := r.Value
+	switch r.Sensor {
+	case protocol.Temperature:
+		s.temp = &value
+	case protocol.Humidity:
+		s.humidity = &value
+	case protocol.RainRate:
+		s.rainRate = &value
+	case protocol.UVIndex:
+		s.uv = &value
+	case protocol.SolarRadiation:
+		s.solar = &value
+	case protocol.Rain:
+		if tips, ok := l.rain.Add(r.Message); ok {
+			s.addRain(id, r.Time, tips)
+		}
+	}
+
+	return nil
+}
+
+// addRain updates the day's rain from the total tips counted by transmitter
+// id at t. Tips since the previous reading count towards t's day. Totals of
+// different transmitters are unrelated, each has its own day.
+func (s *loopState) addRain(id int, t time.Time, tips int) {
+	if s.gauges == nil {
+		s.gauges = make(map[int]*rainDay)
+	}
+	g, exists := s.gauges[id]
+	if !exists {
+		g = &rainDay{}
+		s.gauges[id] = g
+	}
+
+	year, month, day := t.Date()
+	today := time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+	if !today.Equal(g.day) {
+		g.day = today
+		g.base = g.total
+	}
+	g.total = tips
+	s.dayTips = g.total - g.base
+}
+
+// Packet returns a LOOP packet of the current conditions.
+func (l *Loop) Packet() []byte {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	s := &l.state
+	pkt := make([]byte, loopLength)
+
+	copy(pkt, "LOO")
+	// No barometric trend, which also reads as a revision A packet.
+	pkt[3] = 'P'
+
+	short := func(idx int, v *float64, scale float64) {
+		raw := uint16(dashShort)
+		if v != nil {
+			raw = uint16(int16(math.Round(*v * scale)))
+		}
+		binary.LittleEndian.PutUint16(pkt[idx:], raw)
+	}
+	byt := func(idx int, v *float64, scale float64) {
+		raw := byte(dashByte)
+		if v != nil {
+			raw = byte(math.Max(0, math.Min(254, math.Round(*v*scale))))
+		}
+		pkt[idx] = raw
+	}
+
+	// Barometer is left zero, no data. Inside temperature and humidity.
+	short(9, nil, 1)
+	pkt[11] = dashByte
+	short(12, s.temp, 10)
+
+	byt(14, s.speed, 1)
+	if len(s.wind) > 0 {
+		var sum float64
+		for _, w := range s.wind {
+			sum += w.speed
+		}
+		avg := sum / float64(len(s.wind))
+		byt(15, &avg, 1)
+	} else {
+		pkt[15] = dashByte
+	}
+
+	// Degrees from 1 to 360, zero means no data.
+	if s.direction != nil {
+		direction := math.Round(*s.direction)
+		if direction == 0 {
+			direction = 360
+		}
+		binary.LittleEndian.PutUint16(pkt[16:], uint16(direction))
+	}
+
+	// Extra temperatures, soil and leaf temperatures.
+	for idx := 18; idx < 33; idx++ {
+		pkt[idx] = dashByte
+	}
+	byt(33, s.humidity, 1)
+	// Extra humidities.
+	for idx := 34; idx < 41; idx++ {
+		pkt[idx] = dashByte
+	}
+
+	// Rain in 0.01" clicks.
+	if s.rainRate != nil {
+		binary.LittleEndian.PutUint16(pkt[41:], uint16(math.Round(*s.rainRate*100)))
+	}
+	byt(43, s.uv, 10)
+	short(44, s.solar, 1)
+	// No storm in progress.
+	binary.LittleEndian.PutUint16(pkt[48:], 0xFFFF)
+	binary.LittleEndian.PutUint16(pkt[50:], uint16(s.dayTips))
+
+	// Soil moistures and leaf wetnesses.
+	for idx := 62; idx < 70; idx++ {
+		pkt[idx] = dashByte
+	}
+
+	pkt[95], pkt[96] = '\n', '\r'
+	binary.BigEndian.PutUint16(pkt[97:], l.crc.Checksum(pkt[:97]))
+
+	return pkt
+}
+
+// Serve accepts connections from ln and answers commands on each until ln
+// is closed.
+func (l *Loop) Serve(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+
+		go func() {
+			l.handle(conn)
+			conn.Close()
+		}()
+	}
+}
+
+// handle answers commands from a client until it disconnects.
+func (l *Loop) handle(conn io.ReadWriter) {
+	// Read commands concurrently so a command can interrupt a LOOP, as it
+	// does on a console.
+	cmds := make(chan string)
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		defer close(cmds)
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			select {
+			case cmds <- strings.TrimSpace(scanner.Text()):
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	cmd, ok := <-cmds
+	for ok {
+		next, interrupted, err := l.command(conn, cmd, cmds)
+		if err != nil {
+			return
+		}
+		if interrupted {
+			cmd = next
+			continue
+		}
+		cmd, ok = <-cmds
+	}
+}
+
+// command answers a single command. If a LOOP is interrupted by another
+// command, that command is returned.
+func (l *Loop) command(w io.Writer, cmd string, cmds <-chan string) (next string, interrupted bool, err error) {
+	fields := strings.Fields(strings.ToUpper(cmd))
+	switch {
+	case len(fields) == 0:
+		// Wakeup.
+		_, err = io.WriteString(w, "\n\r")
+	case fields[0] == "TEST":
+		_, err = io.WriteString(w, "\n\rTEST\n\r")
+	case fields[0] == "LOOP" && len(fields) == 2:
+		n, convErr := strconv.Atoi(fields[1])
+		if convErr != nil || n < 1 {
+			_, err = w.Write([]byte{loopNAK})
+			break
+		}
+		if _, err = w.Write([]byte{loopACK}); err != nil {
+			break
+		}
+		return l.loop(w, cmds, n)
+	default:
+		_, err = w.Write([]byte{loopNAK})
+	}
+
+	return "", false, err
+}
+
+// loop sends n packets Interval apart, stopping early if a command arrives.
+func (l *Loop) loop(w io.Writer, cmds <-chan string, n int) (next string, interrupted bool, err error) {
+	ticker := time.NewTicker(l.Interval)
+	defer ticker.Stop()
+
+	for idx := 0; idx < n; idx++ {
+		if idx > 0 {
+			select {
+			case cmd, ok := <-cmds:
+				if !ok {
+					return "", false, io.EOF
+				}
+				return cmd, true, nil
+			case <-ticker.C:
+			}
+		}
+
+		if _, err := w.Write(l.Packet()); err != nil {
+			return "", false, err
+		}
+	}
+
+	return "", false, nil
+}
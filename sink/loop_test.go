@@ -0,0 +1,173 @@
+package sink
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/bemasher/rtldavis/crc"
+	"github.com/bemasher/rtldavis/dsp"
+	"github.com/bemasher/rtldavis/protocol"
+)
+
+func rainReading(id byte, t time.Time, tips byte) protocol.Reading {
+	msg := protocol.NewMessage(dsp.Packet{Data: []byte{0, 0, 0xE0 | id, 0, 0, tips, 0, 0, 0, 0}})
+	msg.Time = t
+	msg.CRCValid = true
+	return protocol.Decode(msg)
+}
+
+func TestLoopPacket(t *testing.T) {
+	l := NewLoop()
+
+	// Before any readings every field we can fill is missing.
+	pkt := l.Packet()
+	if len(pkt) != 99 || string(pkt[:3]) != "LOO" || pkt[95] != '\n' || pkt[96] != '\r' {
+		t.Fatalf("malformed packet: %02X", pkt)
+	}
+	if binary.LittleEndian.Uint16(pkt[12:]) != 0x7FFF || pkt[33] != 0xFF || pkt[14] != 0xFF {
+		t.Fatalf("expected dashed values: %02X", pkt)
+	}
+
+	r := testReading()
+	l.Write(r)
+	if pkt := l.Packet(); pkt[14] != 10 || pkt[15] != 10 {
+		t.Errorf("wind speed: got %d, average %d", pkt[14], pkt[15])
+	}
+
+	// Readings that failed their CRC don't change current conditions.
+	bad := testReading()
+	bad.CRCValid = false
+	bad.Speed, bad.Value = 40, 20
+	l.Write(bad)
+	if pkt := l.Packet(); pkt[14] != 10 || int16(binary.LittleEndian.Uint16(pkt[12:])) != 750 {
+		t.Errorf("invalid reading applied: wind speed %d, temperature %d", pkt[14], int16(binary.LittleEndian.Uint16(pkt[12:])))
+	}
+
+	// Rain counter wraps between readings: 3 tips.
+	day := r.Time
+	l.Write(rainReading(0, day, 126))
+	l.Write(rainReading(0, day.Add(time.Minute), 1))
+
+	pkt = l.Packet()
+	if got := int16(binary.LittleEndian.Uint16(pkt[12:])); got != 750 {
+		t.Errorf("temperature: got %d, want 750", got)
+	}
+	// Rain messages carry calm wind, the average covers 10 minutes.
+	if pkt[14] != 0 || pkt[15] != 3 {
+		t.Errorf("wind speed: got %d, average %d", pkt[14], pkt[15])
+	}
+	if got := binary.LittleEndian.Uint16(pkt[50:]); got != 3 {
+		t.Errorf("day rain: got %d, want 3", got)
+	}
+
+	// A new day starts from zero.
+	l.Write(rainReading(0, day.Add(24*time.Hour), 5))
+	if got := binary.LittleEndian.Uint16(l.Packet()[50:]); got != 4 {
+		t.Errorf("day rain after midnight: got %d, want 4", got)
+	}
+
+	// Clients check the CRC over the whole packet.
+	c := crc.NewCRC("CCITT-16", 0, 0x1021, 0)
+	if sum := c.Checksum(l.Packet()); sum != 0 {
+		t.Errorf("crc residue %04X", sum)
+	}
+}
+
+// Rain gauges of different transmitters keep their own day, the packet
+// reports the one heard last.
+func TestLoopRainTransmitters(t *testing.T) {
+	l := NewLoop()
+	day := time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	// Transmitter 0 counts 50 tips, then a new day starts from them.
+	l.Write(rainReading(0, day, 10))
+	l.Write(rainReading(0, day.Add(time.Minute), 60))
+	next := day.Add(24 * time.Hour)
+	l.Write(rainReading(0, next, 60))
+
+	// Transmitter 1 counts 3 tips from its own first reading.
+	l.Write(rainReading(1, next, 100))
+	l.Write(rainReading(1, next.Add(time.Minute), 103))
+	if got := binary.LittleEndian.Uint16(l.Packet()[50:]); got != 3 {
+		t.Errorf("day rain of transmitter 1: got %d, want 3", got)
+	}
+
+	l.Write(rainReading(0, next.Add(2*time.Minute), 62))
+	if got := binary.LittleEndian.Uint16(l.Packet()[50:]); got != 2 {
+		t.Errorf("day rain of transmitter 0: got %d, want 2", got)
+	}
+}
+
+// Only transmitters in the limit update conditions, wind only from the first.
+func TestLoopLimit(t *testing.T) {
+	l := NewLoop()
+	l.SetLimit(protocol.StateLimit{IDs: []int{1, 0}})
+
+	from := func(id byte, speed, temp float64) protocol.Reading {
+		r := testReading()
+		r.ID = id
+		r.Speed, r.Value = speed, temp
+		return r
+	}
+	l.Write(from(1, 10, 75))
+	l.Write(from(0, 20, 70))
+	l.Write(from(2, 30, 40))
+
+	pkt := l.Packet()
+	if pkt[14] != 10 || pkt[15] != 10 {
+		t.Errorf("wind speed: got %d, average %d, want 10", pkt[14], pkt[15])
+	}
+	if got := int16(binary.LittleEndian.Uint16(pkt[12:])); got != 700 {
+		t.Errorf("temperature: got %d, want 700", got)
+	}
+}
+
+func TestLoopServe(t *testing.T) {
+	l := NewLoop()
+	l.Interval = time.Millisecond
+	l.Write(testReading())
+
+	server, client := net.Pipe()
+	defer client.Close()
+	go func() {
+		l.handle(server)
+		server.Close()
+	}()
+
+	r := bufio.NewReader(client)
+	expect := func(want []byte) {
+		t.Helper()
+		got := make([]byte, len(want))
+		if _, err := io.ReadFull(r, got); err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != string(want) {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	}
+
+	io.WriteString(client, "\n")
+	expect([]byte("\n\r"))
+
+	io.WriteString(client, "BARREAD\n")
+	expect([]byte{0x21})
+
+	io.WriteString(client, "LOOP 2\n")
+	expect([]byte{0x06})
+	for n := 0; n < 2; n++ {
+		pkt := make([]byte, 99)
+		if _, err := io.ReadFull(r, pkt); err != nil {
+			t.Fatal(err)
+		}
+		if string(pkt[:3]) != "LOO" {
+			t.Fatalf("expected LOOP packet, got %02X", pkt)
+		}
+	}
+
+	io.WriteString(client, "TEST\n")
+	expect([]byte("\n\rTEST\n\r"))
+}
@@ -0,0 +1,179 @@
+// Package sink formats decoded readings for consumption by other programs.
+package sink
+
+import (
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/bemasher/rtldavis/protocol"
+)
+
+type Sink interface {
+	Write(r protocol.Reading) error
+}
+
+// field is a named output value. Every sink emits the same fields in the same
+// order.
+type field struct {
+	Name  string
+	Value interface{}
+}
+
+func (f field) String() string {
+	switch v := f.Value.(type) {
+	case string:
+		return v
+	case int:
+		return strconv.Itoa(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// fields converts a reading to the given unit system. Values carrying a unit
+// are followed by a field labeling it.
+func fields(r protocol.Reading, units protocol.Units) []field {
+	speed, speedUnit := units.Speed(r.Speed)
+
+	var value interface{}
+	value, unit := units.Value(r.Sensor, r.Value)
+	if !r.Valid {
+		value, unit = nil, ""
+	}
+
+	var smoothed interface{}
+	smoothed, _ = units.Value(r.Sensor, r.Smoothed)
+	if !r.Valid || !r.SmoothedValid {
+		smoothed = nil
+	}
+
+	var trend interface{}
+	if r.Trend != protocol.TrendUnknown {
+		trend = r.Trend.String()
+	}
+
+	var opening interface{}
+	if r.Eye.Count[0] > 0 && r.Eye.Count[1] > 0 {
+		opening = r.Eye.Opening()
+	}
+
+	var rain interface{}
+	rain, rainUnit := units.Rainfall(r.RainTotal)
+	if !r.RainTotalValid {
+		rain, rainUnit = nil, ""
+	}
+
+	return []field{
+		{"time", r.Time.Format(time.RFC3339Nano)},
+		{"id", int(r.ID)},
+		{"sensor", r.Sensor.String()},
+		{"channel", r.ChannelIdx},
+		{"frequency", r.ChannelFreq},
+		{"freq_error", r.FreqError},
+		{"wind_speed", speed},
+		{"wind_speed_unit", speedUnit},
+		{"wind_direction", r.Direction},
+		{"value", value},
+		{"unit", unit},
+		{"smoothed", smoothed},
+		{"trend", trend},
+		{"rain_total", rain},
+		{"rain_total_unit", rainUnit},
+		{"data", hex.EncodeToString(r.Data)},
+		{"region", r.Region},
+		{"source", r.Source},
+		{"corrected", r.Corrected},
+		{"crc_valid", r.CRCValid},
+		{"out_of_range", r.OutOfRange},
+		{"eye_opening", opening},
+		{"confidence", r.Confidence},
+	}
+}
+
+// JSON writes one JSON object per line.
+type JSON struct {
+	w     io.Writer
+	units protocol.Units
+}
+
+func NewJSON(w io.Writer, units protocol.Units) *JSON {
+	return &JSON{w, units}
+}
+
+func (j *JSON) Write(r protocol.Reading) error {
+	buf, err := Marshal(r, j.units)
+	if err != nil {
+		return err
+	}
+	_, err = j.w.Write(append(buf, '\n'))
+	return err
+}
+
+// Marshal encodes a reading as a JSON object with fields in a fixed order.
+func Marshal(r protocol.Reading, units protocol.Units) ([]byte, error) {
+	buf := []byte{'{'}
+	for idx, f := range fields(r, units) {
+		if idx > 0 {
+			buf = append(buf, ',')
+		}
+
+		name, _ := json.Marshal(f.Name)
+		value, err := json.Marshal(f.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		buf = append(buf, name...)
+		buf = append(buf, ':')
+		buf = append(buf, value...)
+	}
+	return append(buf, '}'), nil
+}
+
+// CSV writes a header row followed by one row per reading.
+type CSV struct {
+	w      *csv.Writer
+	units  protocol.Units
+	header bool
+}
+
+func NewCSV(w io.Writer, units protocol.Units) *CSV {
+	return &CSV{w: csv.NewWriter(w), units: units}
+}
+
+func (c *CSV) Write(r protocol.Reading) error {
+	fs := fields(r, c.units)
+
+	if !c.header {
+		names := make([]string, len(fs))
+		for idx, f := range fs {
+			names[idx] = f.Name
+		}
+		if err := c.w.Write(names); err != nil {
+			return err
+		}
+		c.header = true
+	}
+
+	row := make([]string, len(fs))
+	for idx, f := range fs {
+		row[idx] = f.String()
+	}
+	if err := c.w.Write(row); err != nil {
+		return err
+	}
+
+	c.w.Flush()
+	return c.w.Error()
+}
@@ -0,0 +1,198 @@
+package sink
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bemasher/rtldavis/dsp"
+	"github.com/bemasher/rtldavis/protocol"
+)
+
+// A temperature reading of 75°F with a 10 mph wind.
+func testReading() protocol.Reading {
+	msg := protocol.NewMessage(dsp.Packet{Data: []byte{0, 0, 0x82, 0x0A, 0x60, 0x2E, 0xE0, 0x00, 0xAB, 0xCD}})
+	msg.Time = time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC)
+	msg.ChannelIdx = 19
+	msg.ChannelFreq = 911887344
+	msg.Region = "us"
+	msg.Source = "00000001"
+	msg.CRCValid = true
+	return protocol.Decode(msg)
+}
+
+func TestJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := NewJSON(&buf, protocol.Imperial).Write(testReading()); err != nil {
+		t.Fatal(err)
+	}
+
+	var obj map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &obj); err != nil {
+		t.Fatal(err)
+	}
+
+	if obj["channel"] != 19.0 || obj["frequency"] != 911887344.0 {
+		t.Fatalf("unexpected channel fields: %s", buf.String())
+	}
+	if obj["region"] != "us" || obj["source"] != "00000001" || obj["corrected"] != false || obj["crc_valid"] != true {
+		t.Fatalf("unexpected source fields: %s", buf.String())
+	}
+	if obj["id"] != 2.0 || obj["sensor"] != "Temperature" || obj["data"] != "820a602ee000abcd" {
+		t.Fatalf("unexpected message fields: %s", buf.String())
+	}
+	if obj["value"] != 75.0 || obj["unit"] != "°F" || obj["wind_speed"] != 10.0 || obj["wind_speed_unit"] != "mph" {
+		t.Fatalf("unexpected values: %s", buf.String())
+	}
+}
+
+func TestJSONMetric(t *testing.T) {
+	var buf bytes.Buffer
+	if err := NewJSON(&buf, protocol.Metric).Write(testReading()); err != nil {
+		t.Fatal(err)
+	}
+
+	var obj map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &obj); err != nil {
+		t.Fatal(err)
+	}
+
+	if v := obj["value"].(float64); math.Abs(v-23.889) > 1e-3 || obj["unit"] != "°C" {
+		t.Fatalf("unexpected temperature: %s", buf.String())
+	}
+	if v := obj["wind_speed"].(float64); math.Abs(v-16.093) > 1e-3 || obj["wind_speed_unit"] != "km/h" {
+		t.Fatalf("unexpected wind speed: %s", buf.String())
+	}
+}
+
+func TestCSV(t *testing.T) {
+	var buf bytes.Buffer
+	c := NewCSV(&buf, protocol.Imperial)
+	for idx := 0; idx < 2; idx++ {
+		if err := c.Write(testReading()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected header and 2 rows, got %q", lines)
+	}
+	if !strings.HasPrefix(lines[0], "time,id,sensor,channel,frequency,") {
+		t.Fatalf("unexpected header: %q", lines[0])
+	}
+	if !strings.Contains(lines[1], ",19,911887344,") {
+		t.Fatalf("channel missing from row: %q", lines[1])
+	}
+	if !strings.Contains(lines[1], ",75,°F,") {
+		t.Fatalf("value missing from row: %q", lines[1])
+	}
+}
+
+func TestJSONRainTotal(t *testing.T) {
+	decoder := protocol.Decoder{Rain: protocol.NewRainAccumulator()}
+
+	var obj map[string]interface{}
+	for _, counter := range []byte{126, 2} {
+		msg := protocol.NewMessage(dsp.Packet{Data: []byte{0, 0, 0xE0, 0, 0, counter, 0, 0, 0, 0}})
+		msg.CRCValid = true
+
+		var buf bytes.Buffer
+		if err := NewJSON(&buf, protocol.Metric).Write(decoder.Decode(msg)); err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(buf.Bytes(), &obj); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Four tips across the counter's wrap.
+	if v, _ := obj["rain_total"].(float64); math.Abs(v-4*0.254) > 1e-9 || obj["rain_total_unit"] != "mm" {
+		t.Fatalf("unexpected rain total: %v", obj)
+	}
+
+	var buf bytes.Buffer
+	NewJSON(&buf, protocol.Imperial).Write(testReading())
+	if err := json.Unmarshal(buf.Bytes(), &obj); err != nil {
+		t.Fatal(err)
+	}
+	if obj["rain_total"] != nil {
+		t.Fatalf("rain total on a temperature reading: %v", obj)
+	}
+}
+
+func TestJSONSmoothed(t *testing.T) {
+	decoder := protocol.Decoder{Smooth: protocol.NewSmoother(time.Minute)}
+	r := testReading()
+
+	var buf bytes.Buffer
+	if err := NewJSON(&buf, protocol.Metric).Write(decoder.Decode(r.Message)); err != nil {
+		t.Fatal(err)
+	}
+	var obj map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &obj); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := obj["smoothed"].(float64); math.Abs(v-23.889) > 1e-3 {
+		t.Fatalf("unexpected smoothed value: %s", buf.String())
+	}
+
+	buf.Reset()
+	NewJSON(&buf, protocol.Imperial).Write(r)
+	if err := json.Unmarshal(buf.Bytes(), &obj); err != nil {
+		t.Fatal(err)
+	}
+	if obj["smoothed"] != nil {
+		t.Fatalf("smoothed value without smoothing: %v", obj)
+	}
+}
+
+func TestJSONTrend(t *testing.T) {
+	decoder := protocol.Decoder{Trends: protocol.NewTrends(time.Hour)}
+	r := testReading()
+
+	var obj map[string]interface{}
+	for _, at := range []time.Duration{0, time.Hour} {
+		msg := r.Message
+		msg.Time = msg.Time.Add(at)
+
+		var buf bytes.Buffer
+		if err := NewJSON(&buf, protocol.Imperial).Write(decoder.Decode(msg)); err != nil {
+			t.Fatal(err)
+		}
+		obj = nil
+		if err := json.Unmarshal(buf.Bytes(), &obj); err != nil {
+			t.Fatal(err)
+		}
+		if at == 0 && obj["trend"] != nil {
+			t.Fatalf("trend without history: %v", obj)
+		}
+	}
+
+	if obj["trend"] != "steady" {
+		t.Fatalf("unexpected trend: %v", obj)
+	}
+}
+
+func TestJSONConfidence(t *testing.T) {
+	r := testReading()
+	r.OutOfRange = true
+	r.Confidence = protocol.Confidence(r)
+
+	var buf bytes.Buffer
+	if err := NewJSON(&buf, protocol.Imperial).Write(r); err != nil {
+		t.Fatal(err)
+	}
+	var obj map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &obj); err != nil {
+		t.Fatal(err)
+	}
+
+	// The test packet has no symbol decisions.
+	if obj["confidence"] != protocol.OutOfRangeConfidence || obj["eye_opening"] != nil {
+		t.Fatalf("unexpected confidence: %s", buf.String())
+	}
+}
@@ -0,0 +1,42 @@
+package sink
+
+import (
+	"net"
+
+	"github.com/bemasher/rtldavis/protocol"
+)
+
+// UDP sends each reading as a single JSON datagram, see Marshal. There's no
+// connection to manage and nothing is retried, a reading whose datagram is
+// lost is gone. Readings are well under the size of an Ethernet frame.
+type UDP struct {
+	conn  net.Conn
+	units protocol.Units
+}
+
+// DialUDP returns a sink sending to addr, a host:port.
+func DialUDP(addr string, units protocol.Units) (*UDP, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewUDP(conn, units), nil
+}
+
+// NewUDP returns a sink sending on conn, a connected packet socket.
+func NewUDP(conn net.Conn, units protocol.Units) *UDP {
+	return &UDP{conn, units}
+}
+
+func (u *UDP) Write(r protocol.Reading) error {
+	buf, err := Marshal(r, u.units)
+	if err != nil {
+		return err
+	}
+	_, err = u.conn.Write(buf)
+	return err
+}
+
+func (u *UDP) Close() error {
+	return u.conn.Close()
+}
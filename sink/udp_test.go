@@ -0,0 +1,46 @@
+package sink
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/bemasher/rtldavis/protocol"
+)
+
+func TestUDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	u, err := DialUDP(pc.LocalAddr().String(), protocol.Imperial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer u.Close()
+
+	readings := []protocol.Reading{testReading(), testReading()}
+	readings[1].Value = 75.5
+	for _, r := range readings {
+		if err := u.Write(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// One datagram per reading, each the same object as the JSON sink.
+	buf := make([]byte, 2048)
+	for _, r := range readings {
+		pc.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want, _ := Marshal(r, protocol.Imperial)
+		if string(buf[:n]) != string(want) {
+			t.Fatalf("got %s, want %s", buf[:n], want)
+		}
+	}
+}
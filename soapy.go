@@ -0,0 +1,148 @@
+//go:build soapy
+// +build soapy
+
+package main
+
+import (
+	"io"
+	"strings"
+
+	"github.com/bemasher/rtldavis/dsp"
+	"github.com/bemasher/rtldavis/receiver"
+	"github.com/pothosware/go-soapy-sdr/pkg/device"
+)
+
+// SoapySDR support needs the SoapySDR library and its Go bindings. go.mod
+// doesn't require the bindings so the default build doesn't need them, go get
+// adds the requirement before building with the tag:
+//
+//	go get github.com/pothosware/go-soapy-sdr
+//	go build -tags soapy
+//
+// Most radios other than the rtl-sdr can't sample as slowly as the
+// demodulator, use -decimation to pick a device sample rate the radio
+// supports, e.g. -decimation 8 samples a HackRF at 2.15 MHz.
+
+func init() {
+	registerDriver("soapy", openSoapy)
+}
+
+// Time to wait for samples before giving up on the device.
+const soapyTimeoutUs = 1000000
+
+// soapyDevice adapts any radio supported by SoapySDR to receiver.Device. It
+// streams signed 8-bit samples, which are converted to the rtl-sdr's
+// unsigned format.
+type soapyDevice struct {
+	dev    *device.SDRDevice
+	stream *device.SDRStreamCS8
+
+	samples [][]int8
+	flags   []int
+}
+
+// Open the device matching the given arguments, key=value pairs separated
+// by ';' since ',' separates devices.
+func openSoapy(cfg dsp.PacketConfig, source string) (receiver.Device, io.Closer, error) {
+	op := "open " + source
+
+	args := map[string]string{}
+	for _, pair := range strings.Split(source, ";") {
+		if kv := strings.SplitN(pair, "=", 2); len(kv) == 2 {
+			args[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+		}
+	}
+
+	dev, err := device.Make(args)
+	if err != nil {
+		return nil, nil, &receiver.DeviceError{Op: op, Err: err}
+	}
+
+	d := &soapyDevice{
+		dev:     dev,
+		samples: [][]int8{make([]int8, cfg.DeviceBlockSize2)},
+		flags:   make([]int, 1),
+	}
+
+	if err := d.init(cfg); err != nil {
+		dev.Unmake()
+		return nil, nil, err
+	}
+
+	return d, d, nil
+}
+
+func (d *soapyDevice) init(cfg dsp.PacketConfig) error {
+	steps := []struct {
+		op string
+		fn func() error
+	}{
+		{"set sample rate", func() error {
+			return d.dev.SetSampleRate(device.DirectionRX, 0, float64(cfg.DeviceSampleRate))
+		}},
+		{"set gain mode", func() error { return d.dev.SetGainMode(device.DirectionRX, 0, true) }},
+		{"setup stream", func() (err error) {
+			d.stream, err = d.dev.SetupSDRStreamCS8(device.DirectionRX, []uint{0}, nil)
+			return err
+		}},
+		{"activate stream", func() error { return d.stream.Activate(0, 0, 0) }},
+	}
+
+	for _, step := range steps {
+		if err := step.fn(); err != nil {
+			return &receiver.DeviceError{Op: step.op, Err: err}
+		}
+	}
+
+	return nil
+}
+
+func (d *soapyDevice) Read(buf []byte) (int, error) {
+	want := len(buf) / 2
+	if want > len(d.samples[0])/2 {
+		want = len(d.samples[0]) / 2
+	}
+
+	_, n, err := d.stream.Read(d.samples, uint(want), d.flags, soapyTimeoutUs)
+	if err != nil {
+		return 0, &receiver.DeviceError{Op: "read", Err: err}
+	}
+
+	// Signed to unsigned, as read from an rtl-sdr.
+	for idx, v := range d.samples[0][:2*n] {
+		buf[idx] = byte(int(v) + 128)
+	}
+	return int(2 * n), nil
+}
+
+func (d *soapyDevice) SetCenterFreq(freq int) error {
+	if err := d.dev.SetFrequency(device.DirectionRX, 0, float64(freq), nil); err != nil {
+		return &receiver.DeviceError{Op: "set center frequency", Err: err}
+	}
+	return nil
+}
+
+func (d *soapyDevice) SetSampleRate(rate int) error {
+	if err := d.dev.SetSampleRate(device.DirectionRX, 0, float64(rate)); err != nil {
+		return &receiver.DeviceError{Op: "set sample rate", Err: err}
+	}
+	return nil
+}
+
+// SetGain fixes the overall gain in tenths of a dB, SoapySDR distributes it
+// between the radio's gain stages.
+func (d *soapyDevice) SetGain(gain int) error {
+	if err := d.dev.SetGainMode(device.DirectionRX, 0, false); err != nil {
+		return &receiver.DeviceError{Op: "set gain mode", Err: err}
+	}
+	if err := d.dev.SetGain(device.DirectionRX, 0, float64(gain)/10); err != nil {
+		return &receiver.DeviceError{Op: "set gain", Err: err}
+	}
+	return nil
+}
+
+func (d *soapyDevice) Close() error {
+	d.stream.Deactivate(0, 0)
+	d.stream.Close()
+	return d.dev.Unmake()
+}